@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/veandco/go-sdl2/gfx"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// AnnotationLayer draws annotation ranges and their labels over
+// the signal.
+type AnnotationLayer struct {
+	anns []Annotation
+	area AreaRect
+	view *View
+}
+
+// x converts a time in seconds into the horizontal position in the area.
+func (this *AnnotationLayer) x(t float64) int32 {
+	sample := int(t * sampleRate)
+	return int32(sample/this.view.scaleFactor-this.view.start/this.view.scaleFactor) * barWidth
+}
+
+func (this *AnnotationLayer) Draw(renderer *sdl.Renderer) {
+	for _, a := range this.anns {
+		x0 := this.x(a.Start)
+		x1 := this.x(a.End)
+		if x1 < 0 || x0 >= this.area.w {
+			continue
+		}
+		renderer.SetDrawColor(255, 140, 0, 255)
+		renderer.DrawRect(&sdl.Rect{this.area.x + x0, this.area.y, x1 - x0 + 1, this.area.h})
+		label := a.Label
+		if a.Text != "" {
+			label += " [" + a.Text + "]"
+		}
+		gfx.StringRGBA(renderer, this.area.x+x0+2, this.area.y+2, label, 160, 80, 0, 255)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Annotation is a note about a time range of a recording. Text is the
+// text actually sent in the range, it is used for measuring accuracy.
+// Times are in seconds from the beginning of the recording.
+type Annotation struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Label string  `json:"label,omitempty"`
+	Text  string  `json:"text,omitempty"`
+}
+
+type annotationFile struct {
+	Annotations []Annotation `json:"annotations"`
+}
+
+// Audacity labels have a single string, ground truth text follows
+// this marker in it.
+const audacityTextMarker = "text:"
+
+// sidecarName is the annotation file kept next to a recording.
+func sidecarName(recording string) string {
+	return strings.TrimSuffix(recording, filepath.Ext(recording)) + ".annotations.json"
+}
+
+// loadAnnotations reads a json sidecar or an Audacity label track
+// if the name ends with .txt.
+func loadAnnotations(name string) ([]Annotation, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if filepath.Ext(name) == ".txt" {
+		return readAudacityLabels(f)
+	}
+	var af annotationFile
+	if err := json.NewDecoder(f).Decode(&af); err != nil {
+		return nil, fmt.Errorf("Failed to parse annotations %v: %w", name, err)
+	}
+	return af.Annotations, nil
+}
+
+// saveAnnotations writes a json sidecar or an Audacity label track
+// if the name ends with .txt.
+func saveAnnotations(name string, anns []Annotation) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if filepath.Ext(name) == ".txt" {
+		err = writeAudacityLabels(f, anns)
+	} else {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(annotationFile{anns})
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func convertAnnotations(from, to string) error {
+	anns, err := loadAnnotations(from)
+	if err != nil {
+		return err
+	}
+	if err := saveAnnotations(to, anns); err != nil {
+		return err
+	}
+	fmt.Printf("%d annotations written to %s\n", len(anns), to)
+	return nil
+}
+
+// readAudacityLabels parses a label track exported from Audacity.
+// Lines with spectral selection of labels are skipped.
+func readAudacityLabels(r io.Reader) ([]Annotation, error) {
+	var anns []Annotation
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimRight(scanner.Text(), "\r")
+		if s == "" || strings.HasPrefix(s, "\\") {
+			continue
+		}
+		fields := strings.SplitN(s, "\t", 3)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("Wrong label on line %v: %q", line, s)
+		}
+		start, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Wrong label start on line %v: %w", line, err)
+		}
+		end, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Wrong label end on line %v: %w", line, err)
+		}
+		a := Annotation{Start: start, End: end}
+		if len(fields) == 3 {
+			a.Label = fields[2]
+			if i := strings.Index(a.Label, audacityTextMarker); i >= 0 {
+				a.Text = strings.TrimSpace(a.Label[i+len(audacityTextMarker):])
+				a.Label = strings.TrimSpace(a.Label[:i])
+			}
+		}
+		anns = append(anns, a)
+	}
+	return anns, scanner.Err()
+}
+
+func writeAudacityLabels(w io.Writer, anns []Annotation) error {
+	bw := bufio.NewWriter(w)
+	for _, a := range anns {
+		label := a.Label
+		if a.Text != "" {
+			label = strings.TrimSpace(label + " " + audacityTextMarker + " " + a.Text)
+		}
+		fmt.Fprintf(bw, "%.6f\t%.6f\t%s\n", a.Start, a.End, label)
+	}
+	return bw.Flush()
+}
+
+// selectionAnnotations turns runs of selected blocks into annotations.
+func selectionAnnotations(selected []bool, label string) []Annotation {
+	var anns []Annotation
+	for i := 0; i < len(selected); i++ {
+		if !selected[i] {
+			continue
+		}
+		start := i
+		for i < len(selected) && selected[i] {
+			i++
+		}
+		anns = append(anns, Annotation{Start: blockTime(start), End: blockTime(i), Label: label})
+	}
+	return anns
+}
+
+// detectAnnotations loads annotations given explicitly or the sidecar
+// of the recording if it exists.
+func detectAnnotations(recording string, name string) ([]Annotation, error) {
+	if name == "" {
+		name = sidecarName(recording)
+		if _, err := os.Stat(name); err != nil {
+			return nil, nil
+		}
+	}
+	return loadAnnotations(name)
+}
+
+// characterCollector keeps decoded characters for comparing them
+// with annotations.
+type characterCollector struct {
+	chars []CharacterEvent
+}
+
+func (cc *characterCollector) Element(e ElementEvent) {
+}
+
+func (cc *characterCollector) Character(c CharacterEvent) {
+	cc.chars = append(cc.chars, c)
+}
+
+func (cc *characterCollector) Status(s StatusEvent) {
+}
+
+// AccuracyEvent compares decoded text with the ground truth
+// of an annotation.
+type AccuracyEvent struct {
+	Type     string  `json:"type"`
+	Start    float64 `json:"start"`
+	End      float64 `json:"end"`
+	Label    string  `json:"label,omitempty"`
+	Expected string  `json:"expected"`
+	Decoded  string  `json:"decoded"`
+	Errors   int     `json:"errors"`
+	Accuracy float64 `json:"accuracy"`
+}
+
+func (a AccuracyEvent) String() string {
+	return fmt.Sprintf("%.2f-%.2f %s: %.1f%% (%d errors)\n  expected: %s\n  decoded:  %s",
+		a.Start, a.End, a.Label, 100*a.Accuracy, a.Errors, a.Expected, a.Decoded)
+}
+
+// measureAccuracy compares characters decoded within every annotation
+// having ground truth text with that text. Errors are counted as edit
+// distance between the texts ignoring case and repeated spaces.
+func measureAccuracy(anns []Annotation, chars []CharacterEvent) []AccuracyEvent {
+	var res []AccuracyEvent
+	for _, a := range anns {
+		expected := normalizeText(a.Text)
+		if expected == "" {
+			continue
+		}
+		var decoded strings.Builder
+		for _, c := range chars {
+			if c.Start >= a.Start && c.Start < a.End {
+				decoded.WriteString(c.Text)
+			}
+		}
+		got := normalizeText(decoded.String())
+		errors := editDistance([]rune(expected), []rune(got))
+		accuracy := 1 - float64(errors)/float64(len([]rune(expected)))
+		if accuracy < 0 {
+			accuracy = 0
+		}
+		res = append(res, AccuracyEvent{"accuracy", a.Start, a.End, a.Label, expected, got, errors, accuracy})
+	}
+	return res
+}
+
+// printAccuracy writes results in the output format of detect.
+func printAccuracy(w io.Writer, format string, res []AccuracyEvent) error {
+	if len(res) == 0 {
+		return nil
+	}
+	if format == "jsonl" {
+		enc := json.NewEncoder(w)
+		for _, a := range res {
+			if err := enc.Encode(a); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	errors, length := 0, 0
+	for _, a := range res {
+		fmt.Fprintln(w, a)
+		errors += a.Errors
+		length += len([]rune(a.Expected))
+	}
+	_, err := fmt.Fprintf(w, "Accuracy: %.1f%% (%d errors in %d characters)\n",
+		100*(1-float64(errors)/float64(length)), errors, length)
+	return err
+}
+
+func normalizeText(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadAudacityLabels(t *testing.T) {
+	labels := "1.500000\t3.250000\tW1AW text: cq de w1aw\n" +
+		"\\\t220.0\t880.0\n" +
+		"4\t5\tQRM\r\n" +
+		"6\t6\n"
+	anns, err := readAudacityLabels(strings.NewReader(labels))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Annotation{
+		{1.5, 3.25, "W1AW", "cq de w1aw"},
+		{4, 5, "QRM", ""},
+		{6, 6, "", ""},
+	}
+	if !reflect.DeepEqual(anns, expected) {
+		t.Errorf("Unexpected annotations: %v", anns)
+	}
+
+	if _, err := readAudacityLabels(strings.NewReader("1.0 2.0 label\n")); err == nil {
+		t.Errorf("Labels separated by spaces were accepted")
+	}
+}
+
+func TestAnnotationsRoundTrip(t *testing.T) {
+	anns := []Annotation{
+		{0.5, 2, "W1AW", "cq de w1aw"},
+		{3, 4.125, "", "test"},
+		{5, 6, "QRM", ""},
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"rec.annotations.json", "rec.txt"} {
+		name = filepath.Join(dir, name)
+		if err := saveAnnotations(name, anns); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := loadAnnotations(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(loaded, anns) {
+			t.Errorf("%v: unexpected annotations: %v", name, loaded)
+		}
+	}
+}
+
+func TestSidecarName(t *testing.T) {
+	if n := sidecarName("/tmp/cw.raw"); n != "/tmp/cw.annotations.json" {
+		t.Errorf("Unexpected sidecar: %v", n)
+	}
+}
+
+func TestSelectionAnnotations(t *testing.T) {
+	anns := selectionAnnotations([]bool{false, true, true, false, true}, "selection")
+	expected := []Annotation{
+		{blockTime(1), blockTime(3), "selection", ""},
+		{blockTime(4), blockTime(5), "selection", ""},
+	}
+	if !reflect.DeepEqual(anns, expected) {
+		t.Errorf("Unexpected annotations: %v", anns)
+	}
+}
+
+func TestMeasureAccuracy(t *testing.T) {
+	chars := []CharacterEvent{}
+	for i, c := range "xcq  dx w1aw" {
+		chars = append(chars, CharacterEvent{Start: float64(i), Text: string(c)})
+	}
+	anns := []Annotation{
+		{1, 20, "W1AW", "CQ  DE W1AW"},
+		{0, 1, "no text", ""},
+	}
+	res := measureAccuracy(anns, chars)
+	if len(res) != 1 {
+		t.Fatalf("Expected one result, got %v", res)
+	}
+	if res[0].Expected != "cq de w1aw" || res[0].Decoded != "cq dx w1aw" {
+		t.Errorf("Unexpected texts: %q, %q", res[0].Expected, res[0].Decoded)
+	}
+	if res[0].Errors != 1 || res[0].Accuracy != 0.9 {
+		t.Errorf("Unexpected accuracy: %v", res[0])
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	cases := []struct {
+		a, b string
+		d    int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "ab", 2},
+		{"paris", "paris", 0},
+		{"paris", "parts", 1},
+		{"paris", "aris", 1},
+		{"cq", "qc", 2},
+	}
+	for _, c := range cases {
+		if d := editDistance([]rune(c.a), []rune(c.b)); d != c.d {
+			t.Errorf("Distance between %q and %q is %v, expected %v", c.a, c.b, d, c.d)
+		}
+	}
+}
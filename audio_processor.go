@@ -1,8 +1,8 @@
 package main
 
 import (
-	"encoding/binary"
 	"fmt"
+	"io"
 	"math"
 	"math/cmplx"
 	"os"
@@ -12,110 +12,111 @@ import (
 	"github.com/go-echarts/go-echarts/v2/opts"
 	"github.com/mjibson/go-dsp/dsputils"
 	"github.com/mjibson/go-dsp/fft"
+	log "github.com/sirupsen/logrus"
 )
 
 const fragmentSize = 512
 
+// sampleRate is the working rate of the pipeline, sources and recordings
+// at other rates are resampled to it. It is fixed, block sizes, band
+// edges and spectrum bins of the decoder are tuned to it, so decimating
+// to a lower working rate such as 8 kHz to save CPU isn't supported.
+const sampleRate = 44100
+
 type Range struct {
 	lb, ub int64
 }
 
-func correlateFile(name string) {
-	sig := make([]float64, 0)
-	res := make([]float64, 0)
-	filter := NewBpFilter(200, 7.0/fragmentSize, 30.0/fragmentSize, fragmentSize)
-
-	file, err := os.Open(name)
+func processFile(name string, rng *Range, classRng *Range) (sig []float64, res []float64, values []bool, spectra [][]float64, err error) {
+	sig, res, spectra, err = readSpectra(name, rng, ReaderOptions{})
 	if err != nil {
-		fmt.Printf("Failed to open file: %v\n", err)
-		return
+		return nil, nil, nil, nil, err
 	}
-	defer file.Close()
-	spectra := make([][]float64, 0)
-	for pieceNum := int64(0); ; pieceNum++ {
-		buf := make([]float64, fragmentSize)
-		for i := 0; i < fragmentSize; i++ {
-			var v int16
-			err := binary.Read(file, binary.LittleEndian, &v)
-			if err != nil {
-				goto exit
-			}
-			buf[i] = float64(v)
+	/*
+		var sd *KMeansSignalDetector
+		if classRng.lb == 0 && classRng.ub == 0 {
+			sd = classifySegments(spectra)
+		} else {
+			sd = classifySegments(spectra[classRng.lb:classRng.ub])
 		}
-		sig = append(sig, buf...)
-		buf = filter.FilterBuf(buf)
-		res = append(res, buf...)
-		hann(buf)
-		rawSpectrum := ToAbs(fft.FFTReal(buf))
-		spectra = append(spectra, rawSpectrum[0:222])
-	}
-exit:
-	matr := correlationMatrix(spectra)
-	for i := 0; i < len(matr); i++ {
-		for j := 0; j < len(matr[i]); j++ {
-			fmt.Printf("%0.2f ", matr[i][j])
+		drawChart("signalMean.html", sd.signal)
+		drawChart("noiseMean.html", sd.noise)
+		for i := 0; i < len(spectra); i++ {
+			values = append(values, sd.isSignal(spectra[i]))
 		}
-		fmt.Printf("\n")
-	}
-}
+		return sig, res, values, nil
+	*/
+	/*
+		var sd *EMSignalDetector
+		if classRng == nil || (classRng.lb == 0 && classRng.ub == 0) {
+			sd = expectationMaximizationClassifySegments(spectra)
+		} else {
+			sd = expectationMaximizationClassifySegments(spectra[classRng.lb:classRng.ub])
+		}
+	*/
 
-func processFile(name string, rng *Range, classRng *Range) (sig []float64, res []float64, values []bool, linSpectra []float64, spectra [][]float64, err error) {
+	//drawChart("signalMean.html", sd.centroids[0])
+	//drawChart("noiseMean.html", sd.centroids[1])
 
-	values = make([]bool, 0)
-	linSpectra = make([]float64, 0)
+	detection, err := detectSignal(spectra)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	//smoothOutSignal(values)
 
-	filter := NewBpFilter(200, 7.0/fragmentSize, 30.0/fragmentSize, fragmentSize)
+	return sig, res, detection.values, spectra, nil
+}
 
-	file, err := os.Open(name)
+// readSpectra reads a raw S16_LE mono or WAV file, band-pass filters it,
+// passes it through the optional stages and calculates the spectrum of
+// every block. The whole recording is kept
+// in memory, decodeFile handles long recordings.
+func readSpectra(name string, rng *Range, opts ReaderOptions) (sig []float64, res []float64, spectra [][]float64, err error) {
+	file, err := openRecording(name)
 	if err != nil {
-		return nil, nil, nil, nil, nil, err
+		return nil, nil, nil, err
 	}
 	defer file.Close()
-	sig = make([]float64, 0)
-	res = make([]float64, 0)
-
-	spectra = make([][]float64, 0)
+	sr := NewSpectrumReader(file)
+	if err := sr.configure(opts); err != nil {
+		return nil, nil, nil, err
+	}
 	for pieceNum := int64(0); ; pieceNum++ {
-		buf := make([]float64, fragmentSize)
-		for i := 0; i < fragmentSize; i++ {
-			var v int16
-			err := binary.Read(file, binary.LittleEndian, &v)
-			if err != nil {
-				goto exit
-			}
-			buf[i] = float64(v)
+		blockSig, blockRes, rawSpectrum, err := sr.Next()
+		if err == io.EOF {
+			return sig, res, spectra, nil
+		}
+		if err != nil {
+			return nil, nil, nil, err
 		}
-		sig = append(sig, buf...)
-		buf = filter.FilterBuf(buf)
-		res = append(res, buf...)
-		hann(buf)
-		rawSpectrum := ToAbs(fft.FFTReal(buf))
+		sig = append(sig, blockSig...)
+		res = append(res, blockRes...)
 		spectra = append(spectra, rawSpectrum[0:fragmentSize/2+2])
-		linSpectra = append(linSpectra, rawSpectrum...)
 		if rng != nil && pieceNum > rng.lb && pieceNum < rng.ub {
 			fn := fmt.Sprintf("%d.html", pieceNum)
 			drawChart(fn, rawSpectrum)
 		}
-
 	}
-exit:
-	/*
-		var sd *KMeansSignalDetector
-		if classRng.lb == 0 && classRng.ub == 0 {
-			sd = classifySegments(spectra)
-		} else {
-			sd = classifySegments(spectra[classRng.lb:classRng.ub])
-		}
-		drawChart("signalMean.html", sd.signal)
-		drawChart("noiseMean.html", sd.noise)
-		for i := 0; i < len(spectra); i++ {
-			values = append(values, sd.isSignal(spectra[i]))
-		}
-		return sig, res, values, nil
-	*/
+}
+
+// Detection is the result of classifying blocks of a signal into
+// keyed and silent ones.
+type Detection struct {
+	frequency int       // Spectrum bin of the carrier.
+	signals   []float64 // Magnitude of the carrier bin in every block.
+	detector  *EMSingleFrequencyDetector
+	values    []bool
+	filtered  []float64 // Signals correlated with a dit by matchDetection.
+	threshold float64
+	shift     float64 // Blocks the correlation is centred after the block.
+}
 
+func detectSignal(spectra [][]float64) (*Detection, error) {
 	significantFrequency, err := calculateSignificantFrequency(spectra)
-	fmt.Printf("Significant frequency result: %v, %v\n", significantFrequency, err)
+	log.Debugf("Significant frequency result: %v, %v", significantFrequency, err)
+	if err != nil {
+		return nil, err
+	}
 
 	signals := extractFrequency(spectra, significantFrequency)
 	//signals = signals[10:len(signals)]
@@ -128,28 +129,19 @@ exit:
 	tSig := make([]float64, len(spectra))
 	copy(tSig, signals)
 	tSig = cleanupSignal(tSig)
-	sd := classifyEMFromSingleFrequency(tSig)
-	fmt.Printf("EM Classifier: %v\n", sd)
-	/*
-		var sd *EMSignalDetector
-		if classRng == nil || (classRng.lb == 0 && classRng.ub == 0) {
-			sd = expectationMaximizationClassifySegments(spectra)
-		} else {
-			sd = expectationMaximizationClassifySegments(spectra[classRng.lb:classRng.ub])
-		}
-	*/
-
-	//drawChart("signalMean.html", sd.centroids[0])
-	//drawChart("noiseMean.html", sd.centroids[1])
+	sd, err := classifyEMFromSingleFrequency(tSig)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("EM Classifier: %v", sd)
 
+	values := make([]bool, 0, len(signals))
 	for i := 0; i < len(signals); i++ {
 		//rk := assignPoint(signals[i], sd.m, sd.sigma, sd.pi)
 		//fmt.Printf("%v\n", rk)
 		values = append(values, sd.isSignal(signals[i]))
 	}
-	//smoothOutSignal(values)
-
-	return sig, res, values, linSpectra, spectra, nil
+	return &Detection{frequency: significantFrequency, signals: signals, detector: sd, values: values}, nil
 }
 
 func smoothOutSignal(values []bool) {
@@ -162,7 +154,7 @@ func smoothOutSignal(values []bool) {
 
 func cleanupSignal(signal []float64) []float64 {
 	sort.Float64s(signal)
-	for {
+	for len(signal) > 2 {
 		allMin := signal[0]
 		allMax := signal[len(signal)-1]
 		middle := (allMin + allMax) / 2
@@ -194,12 +186,12 @@ func processWhole() {
 
 	kernel := dsputils.ZeroPadF(windowSincKernelHp(200, 2.0/fragmentSize), 200+len(buf))
 	buf = dsputils.ZeroPadF(buf, 200+len(buf))
-	filtered := ToReal(fft.Convolve(dsputils.ToComplex(buf), dsputils.ToComplex(kernel)))
+	filtered, _ := ToReal(fft.Convolve(dsputils.ToComplex(buf), dsputils.ToComplex(kernel)))
 
 	buf = filtered
 	kernel = dsputils.ZeroPadF(windowSincKernelHp(200, 7.0/fragmentSize), 200+len(buf))
 	buf = dsputils.ZeroPadF(buf, 200+len(buf))
-	filtered = ToReal(fft.Convolve(dsputils.ToComplex(buf), dsputils.ToComplex(kernel)))
+	filtered, _ = ToReal(fft.Convolve(dsputils.ToComplex(buf), dsputils.ToComplex(kernel)))
 	drawChart("filtered.html", filtered)
 
 	cut := filtered[27400:38360]
@@ -230,6 +222,9 @@ func drawCut(cut []float64) {
 }
 
 func measureIntervals(s []bool) (es []Element) {
+	if len(s) == 0 {
+		return nil
+	}
 	es = make([]Element, 1)
 	es[0].s = s[0]
 	es[0].d = 1
@@ -251,33 +246,43 @@ func ToAbs(a []complex128) []float64 {
 	return r
 }
 
-func ToReal(a []complex128) []float64 {
-	r := make([]float64, len(a))
+// ToReal returns real parts of the numbers. Residual imaginary parts
+// left by rounding are dropped, an error is returned if they are bigger
+// than rounding could produce.
+func ToReal(a []complex128) ([]float64, error) {
+	r := realParts(a)
+	mx := 1.0
 	for i := 0; i < len(a); i++ {
-		if math.Abs(imag(a[i])) > 0.000001 {
-			panic("Converting complex number with non zero imaginary part")
+		mx = math.Max(mx, cmplx.Abs(a[i]))
+	}
+	for i := 0; i < len(a); i++ {
+		if math.Abs(imag(a[i])) > 0.000001*mx {
+			return r, fmt.Errorf("Converting complex number with non zero imaginary part: %v", a[i])
 		}
+	}
+	return r, nil
+}
+
+func realParts(a []complex128) []float64 {
+	r := make([]float64, len(a))
+	for i := 0; i < len(a); i++ {
 		r[i] = real(a[i])
 	}
 	return r
 }
 
 func readFile(name string) (res []float64, err error) {
-	file, err := os.Open(name)
+	file, err := openRecording(name)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 	buf := make([]float64, 88200)
-	for i := 0; i < len(buf); i++ {
-		var v int16
-		err := binary.Read(file, binary.LittleEndian, &v)
-		if err != nil {
-			return res, nil
-		}
-		buf[i] = float64(v)
+	n, err := NewPCMReader(file).Read(buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
 	}
-	return buf, nil
+	return buf[:n], nil
 }
 
 func hann(y []float64) {
@@ -302,8 +307,7 @@ func drawChart(name string, buf []float64) {
 
 	// Set global options
 	bar.SetGlobalOptions(charts.WithTitleOpts(opts.Title{
-		Title:    "Bar chart in Go",
-		Subtitle: "This is fun to use!",
+		Title: name,
 	}))
 	barData := make([]opts.BarData, len(buf))
 	for i := 0; i < len(buf); i++ {
@@ -316,17 +320,21 @@ func drawChart(name string, buf []float64) {
 	_ = bar.Render(f)
 }
 
+// windowSincKernelLp designs a low-pass kernel of m+1 taps, fc is
+// a fraction of the sampling rate.
 func windowSincKernelLp(m int, fc float64) []float64 {
 	h := make([]float64, m+1)
+	mF := float64(m)
 	for i := 0; i <= m; i++ {
 		// Blackman window
 		iF := float64(i)
-		mF := float64(m)
-		mF2 := float64(m / 2)
 		w := 0.42 - 0.5*math.Cos(2*math.Pi*iF/mF) + 0.08*math.Cos(4*math.Pi*iF/mF)
-		h[i] = w * math.Sin(2*math.Pi*fc*(iF-mF2)) / (iF - mF2)
+		if x := iF - mF/2; x == 0 {
+			h[i] = 2 * math.Pi * fc
+		} else {
+			h[i] = w * math.Sin(2*math.Pi*fc*x) / x
+		}
 	}
-	h[100] = 2 * math.Pi * fc
 	var sum float64 = 0
 	for i := 0; i <= m; i++ {
 		sum += h[i]
@@ -337,8 +345,10 @@ func windowSincKernelLp(m int, fc float64) []float64 {
 	return h
 }
 
+// windowSincKernelHp inverts a low-pass kernel. Inversion needs a middle
+// tap, odd m is rounded up.
 func windowSincKernelHp(m int, fc float64) []float64 {
-	hp := windowSincKernelLp(m, fc)
+	hp := windowSincKernelLp(m+m%2, fc)
 	for i := 0; i < len(hp); i++ {
 		hp[i] = -hp[i]
 	}
@@ -346,7 +356,9 @@ func windowSincKernelHp(m int, fc float64) []float64 {
 	return hp
 }
 
+// windowSincKernelBp rounds odd m up like windowSincKernelHp.
 func windowSincKernelBp(m int, fcL, fcH float64) []float64 {
+	m += m % 2
 	lp := windowSincKernelLp(m, fcL)
 	hp := windowSincKernelHp(m, fcH)
 	bp := make([]float64, m+1)
@@ -360,7 +372,7 @@ func windowSincKernelBp(m int, fcL, fcH float64) []float64 {
 
 func calculateSignificantFrequency(spectra [][]float64) (int, error) {
 	if len(spectra) == 0 {
-		return 0, fmt.Errorf("Not enough data to calculate significant frequency")
+		return 0, fmt.Errorf("%w: not enough data to calculate significant frequency", ErrInsufficientData)
 	}
 	n := len(spectra)
 	m := len(spectra[0])
@@ -370,13 +382,7 @@ func calculateSignificantFrequency(spectra [][]float64) (int, error) {
 			sum[j] += spectra[i][j]
 		}
 	}
-	r := 0
-	for j := 1; j < m; j++ {
-		if sum[j] > sum[r] {
-			r = j
-		}
-	}
-	return r, nil
+	return strongestKeyed(spectra, sum), nil
 }
 
 func extractFrequency(spectra [][]float64, frequency int) []float64 {
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestHann(t *testing.T) {
+	y := make([]float64, 9)
+	for i := range y {
+		y[i] = 1
+	}
+	hann(y)
+	if y[0] != 0 || math.Abs(y[8]) > 1e-12 {
+		t.Errorf("Window edges are not zero: %v", y)
+	}
+	if math.Abs(y[4]-1) > 1e-12 {
+		t.Errorf("Window middle is not one: %v", y[4])
+	}
+	for i := 0; i < len(y)/2; i++ {
+		if math.Abs(y[i]-y[len(y)-1-i]) > 1e-12 {
+			t.Errorf("Window is not symmetric: %v", y)
+		}
+	}
+}
+
+func TestMeasureIntervals(t *testing.T) {
+	for _, c := range []struct {
+		in  []bool
+		out []Element
+	}{
+		{nil, nil},
+		{[]bool{true}, []Element{{1, true}}},
+		{[]bool{false, false, true, true, true, false}, []Element{{2, false}, {3, true}, {1, false}}},
+		{[]bool{true, false, true}, []Element{{1, true}, {1, false}, {1, true}}},
+	} {
+		if es := measureIntervals(c.in); !reflect.DeepEqual(es, c.out) {
+			t.Errorf("measureIntervals(%v) = %v, expected %v", c.in, es, c.out)
+		}
+	}
+}
+
+func TestCalculateSignificantFrequency(t *testing.T) {
+	spectra := [][]float64{{1, 2, 5, 1}, {1, 6, 1, 1}, {1, 1, 4, 1}}
+	f, err := calculateSignificantFrequency(spectra)
+	if err != nil || f != 2 {
+		t.Errorf("Frequency: %v, %v", f, err)
+	}
+	if _, err := calculateSignificantFrequency(nil); !errors.Is(err, ErrInsufficientData) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+#cgo LDFLAGS: -lasound
+#include <alsa/asoundlib.h>
+#include <stdint.h>
+*/
+import "C"
+
+var alsaFormats = map[string]C.snd_pcm_format_t{
+	"S16_LE":   C.SND_PCM_FORMAT_S16_LE,
+	"S24_LE":   C.SND_PCM_FORMAT_S24_LE,
+	"S24_3LE":  C.SND_PCM_FORMAT_S24_3LE,
+	"S32_LE":   C.SND_PCM_FORMAT_S32_LE,
+	"FLOAT_LE": C.SND_PCM_FORMAT_FLOAT_LE,
+}
+
+type AudioStream struct {
+	handle *C.snd_pcm_t
+	cfg    CaptureConfig
+	ch     chan *SampleBlock
+	errs   chan error
+	cancel context.CancelFunc
+	done   chan struct{}
+	once   sync.Once
+
+	overruns uint64
+	dropped  uint64
+}
+
+// OpenAudioStream starts capturing from the device. Capture stops and
+// the sample channel is closed when ctx is cancelled or Close is called.
+// The device may not support the requested rate exactly, Rate returns
+// the rate it captures at.
+func OpenAudioStream(ctx context.Context, cfg CaptureConfig) (*AudioStream, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	as := &AudioStream{
+		cfg:    cfg,
+		ch:     make(chan *SampleBlock, audioStreamBlocks),
+		errs:   make(chan error, 1),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	var rc C.int
+
+	log.Tracef("Openning device: %v", cfg.Device)
+	deviceCString := C.CString(cfg.Device)
+	defer C.free(unsafe.Pointer(deviceCString))
+
+	rc = C.snd_pcm_open(&as.handle, deviceCString, C.SND_PCM_STREAM_CAPTURE, 0)
+	if rc < 0 {
+		cancel()
+		return nil, fmt.Errorf("Unable to open pcm device: %v", C.GoString(C.snd_strerror(rc)))
+	}
+	fail := func(msg string) (*AudioStream, error) {
+		cancel()
+		C.snd_pcm_close(as.handle)
+		return nil, fmt.Errorf("%s: %v", msg, C.GoString(C.snd_strerror(rc)))
+	}
+
+	var params *C.snd_pcm_hw_params_t
+	rc = C.snd_pcm_hw_params_malloc(&params)
+	if rc < 0 {
+		return fail("Couldn't alloc hw params")
+	}
+	defer C.snd_pcm_hw_params_free(params)
+
+	rc = C.snd_pcm_hw_params_any(as.handle, params)
+	if rc < 0 {
+		return fail("Couldn't set default hw params")
+	}
+	rc = C.snd_pcm_hw_params_set_access(as.handle, params, C.SND_PCM_ACCESS_RW_INTERLEAVED)
+	if rc < 0 {
+		return fail("Couldn't set access params")
+	}
+	rc = C.snd_pcm_hw_params_set_format(as.handle, params, alsaFormats[cfg.Format])
+	if rc < 0 {
+		return fail("Couldn't set sample format " + cfg.Format)
+	}
+	rc = C.snd_pcm_hw_params_set_channels(as.handle, params, C.uint(cfg.Channels))
+	if rc < 0 {
+		return fail(fmt.Sprintf("Couldn't set %v channels", cfg.Channels))
+	}
+	val := C.uint(cfg.Rate)
+	var dir C.int
+	rc = C.snd_pcm_hw_params_set_rate_near(as.handle, params, &val, &dir)
+	if rc < 0 {
+		return fail("Couldn't set rate")
+	}
+	if int(val) != cfg.Rate {
+		log.Warnf("Device %v doesn't support %v Hz, capturing at %v Hz", cfg.Device, cfg.Rate, val)
+	}
+	as.cfg.Rate = int(val)
+
+	frames := C.snd_pcm_uframes_t(cfg.PeriodSize)
+	rc = C.snd_pcm_hw_params_set_period_size_near(as.handle, params, &frames, &dir)
+	if rc < 0 {
+		return fail("Couldn't set period size")
+	}
+	if cfg.BufferSize > 0 {
+		bufferFrames := C.snd_pcm_uframes_t(cfg.BufferSize)
+		rc = C.snd_pcm_hw_params_set_buffer_size_near(as.handle, params, &bufferFrames)
+		if rc < 0 {
+			return fail("Couldn't set buffer size")
+		}
+	}
+
+	rc = C.snd_pcm_hw_params(as.handle, params)
+	if rc < 0 {
+		return fail("Couldn't set params")
+	}
+
+	rc = C.snd_pcm_hw_params_get_period_size(params, &frames, &dir)
+	if rc < 0 {
+		return fail("Couldn't get period size")
+	}
+	var bufferFrames C.snd_pcm_uframes_t
+	rc = C.snd_pcm_hw_params_get_buffer_size(params, &bufferFrames)
+	if rc < 0 {
+		return fail("Couldn't get buffer size")
+	}
+	log.Debugf("Capturing %v: %v Hz, %v channels, %v, period %v frames, buffer %v frames",
+		cfg.Device, as.cfg.Rate, cfg.Channels, cfg.Format, frames, bufferFrames)
+
+	buffer := make([]byte, int(frames)*as.cfg.frameSize())
+	log.Tracef("Buffer len: %v", len(buffer))
+
+	go as.read(ctx, buffer, frames)
+
+	return as, nil
+}
+
+// read runs in its own goroutine and is the only user of the handle
+// until it returns. Capture can't wait for the pipeline, blocks that
+// don't fit into the channel are dropped and counted.
+func (as *AudioStream) read(ctx context.Context, buffer []byte, frames C.snd_pcm_uframes_t) {
+	defer close(as.done)
+	defer close(as.ch)
+	frameSize := as.cfg.frameSize()
+	var offset int64
+	for ctx.Err() == nil {
+		rcl := C.snd_pcm_readi(as.handle, unsafe.Pointer(&buffer[0]), frames)
+		now := time.Now()
+		log.Tracef("Received rcl: %v", rcl)
+		if rcl < 0 {
+			if rcl == -C.EPIPE || rcl == -C.ESTRPIPE {
+				atomic.AddUint64(&as.overruns, 1)
+				log.Debugf("Overrun occurred")
+			}
+			// Recovers from overruns and suspends, other errors stop capture.
+			if rc := C.snd_pcm_recover(as.handle, C.int(rcl), 1); rc < 0 {
+				as.errs <- fmt.Errorf("Error from read: %v", C.GoString(C.snd_strerror(rc)))
+				return
+			}
+			continue
+		} else if rcl != C.long(frames) {
+			log.Debugf("Short read, read %v frames", rcl)
+		}
+		n := int(rcl)
+		b := newSampleBlock(n)
+		convertFrames(b.Samples, buffer[:n*frameSize], as.cfg)
+		b.Stamp = Stamp{offset, now.Add(-time.Duration(n) * time.Second / time.Duration(as.cfg.Rate))}
+		offset += int64(n)
+		select {
+		case as.ch <- b:
+		case <-ctx.Done():
+			b.Release()
+			return
+		default:
+			b.Release()
+			atomic.AddUint64(&as.dropped, uint64(n))
+		}
+	}
+}
+
+// GetChan returns sample blocks of the stream. The channel is closed
+// when capture stops.
+func (as *AudioStream) GetChan() <-chan *SampleBlock {
+	return as.ch
+}
+
+func (as *AudioStream) Read() *SampleBlock {
+	return <-as.ch
+}
+
+// Err returns the channel receiving the error that stopped capture.
+func (as *AudioStream) Err() <-chan error {
+	return as.errs
+}
+
+// Rate is the sample rate the device captures at.
+func (as *AudioStream) Rate() int {
+	return as.cfg.Rate
+}
+
+func (as *AudioStream) Stats() AudioStats {
+	return AudioStats{atomic.LoadUint64(&as.overruns), atomic.LoadUint64(&as.dropped)}
+}
+
+// Close stops the reader and waits for it before releasing the device.
+// It is safe to call Close more than once.
+func (as *AudioStream) Close() {
+	as.once.Do(func() {
+		as.cancel()
+		<-as.done
+		C.snd_pcm_drop(as.handle)
+		C.snd_pcm_close(as.handle)
+	})
+}
+
+// listDevices returns PCMs known to ALSA, both hardware devices and
+// ones defined in the configuration.
+func listDevices() ([]DeviceInfo, error) {
+	var hints *unsafe.Pointer
+	pcm := C.CString("pcm")
+	defer C.free(unsafe.Pointer(pcm))
+	if rc := C.snd_device_name_hint(-1, pcm, &hints); rc < 0 {
+		return nil, fmt.Errorf("Couldn't list devices: %v", C.GoString(C.snd_strerror(rc)))
+	}
+	defer C.snd_device_name_free_hint(hints)
+
+	hint := func(h unsafe.Pointer, id string) (string, bool) {
+		cid := C.CString(id)
+		defer C.free(unsafe.Pointer(cid))
+		v := C.snd_device_name_get_hint(h, cid)
+		if v == nil {
+			return "", false
+		}
+		defer C.free(unsafe.Pointer(v))
+		return C.GoString(v), true
+	}
+
+	var devices []DeviceInfo
+	for p := hints; *p != nil; p = (*unsafe.Pointer)(unsafe.Add(unsafe.Pointer(p), unsafe.Sizeof(*p))) {
+		name, ok := hint(*p, "NAME")
+		if !ok {
+			continue
+		}
+		desc, _ := hint(*p, "DESC")
+		// A missing IOID means the device does both.
+		ioid, ok := hint(*p, "IOID")
+		devices = append(devices, DeviceInfo{
+			Name:        name,
+			Description: desc,
+			Input:       !ok || ioid == "Input",
+			Output:      !ok || ioid == "Output",
+		})
+	}
+	return devices, nil
+}
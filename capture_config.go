@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// CaptureConfig selects hardware parameters of an ALSA capture.
+// Captured frames are converted to mono 16 bit samples, either from
+// a single channel or mixed down from all of them.
+type CaptureConfig struct {
+	Device     string
+	Rate       int
+	Channels   int
+	Channel    int // Channel to keep, -1 mixes all channels down.
+	Format     string
+	PeriodSize int // Frames.
+	BufferSize int // Frames, 0 lets ALSA choose.
+}
+
+func defaultCaptureConfig(device string) CaptureConfig {
+	return CaptureConfig{
+		Device:     device,
+		Rate:       sampleRate,
+		Channels:   1,
+		Channel:    -1,
+		Format:     "S16_LE",
+		PeriodSize: 8192,
+	}
+}
+
+// sampleFormat describes a little endian sample format of ALSA.
+type sampleFormat struct {
+	width  int // Bytes per sample.
+	decode func(b []byte) int16
+}
+
+var sampleFormats = map[string]sampleFormat{
+	"S16_LE": {2, func(b []byte) int16 {
+		return int16(binary.LittleEndian.Uint16(b))
+	}},
+	// 24 bit samples in the lower bytes of 32 bit words.
+	"S24_LE": {4, func(b []byte) int16 {
+		return int16(int32(binary.LittleEndian.Uint32(b)<<8) >> 16)
+	}},
+	"S24_3LE": {3, func(b []byte) int16 {
+		return int16(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 16)
+	}},
+	"S32_LE": {4, func(b []byte) int16 {
+		return int16(int32(binary.LittleEndian.Uint32(b)) >> 16)
+	}},
+	"FLOAT_LE": {4, func(b []byte) int16 {
+		v := float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		return int16(math.Max(-32768, math.Min(32767, math.Round(v*32767))))
+	}},
+}
+
+func sampleFormatNames() string {
+	names := make([]string, 0, len(sampleFormats))
+	for n := range sampleFormats {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func (cfg CaptureConfig) validate() error {
+	if _, ok := sampleFormats[cfg.Format]; !ok {
+		return fmt.Errorf("Unknown sample format %v, supported formats: %v", cfg.Format, sampleFormatNames())
+	}
+	if cfg.Channels < 1 {
+		return fmt.Errorf("Wrong number of channels: %v", cfg.Channels)
+	}
+	if cfg.Channel < -1 || cfg.Channel >= cfg.Channels {
+		return fmt.Errorf("Channel %v is out of %v channels", cfg.Channel, cfg.Channels)
+	}
+	if cfg.Rate <= 0 || cfg.PeriodSize <= 0 || cfg.BufferSize < 0 {
+		return fmt.Errorf("Wrong rate, period or buffer size: %v, %v, %v", cfg.Rate, cfg.PeriodSize, cfg.BufferSize)
+	}
+	return nil
+}
+
+// frameSize is the number of bytes in a frame.
+func (cfg CaptureConfig) frameSize() int {
+	return sampleFormats[cfg.Format].width * cfg.Channels
+}
+
+// convertFrames converts interleaved frames in src into mono samples
+// and returns the number of samples written into dst.
+func convertFrames(dst []int16, src []byte, cfg CaptureConfig) int {
+	f := sampleFormats[cfg.Format]
+	frame := f.width * cfg.Channels
+	n := len(src) / frame
+	if n > len(dst) {
+		n = len(dst)
+	}
+	for i := 0; i < n; i++ {
+		b := src[i*frame:]
+		if cfg.Channel >= 0 {
+			dst[i] = f.decode(b[cfg.Channel*f.width:])
+			continue
+		}
+		sum := 0
+		for c := 0; c < cfg.Channels; c++ {
+			sum += int(f.decode(b[c*f.width:]))
+		}
+		dst[i] = int16(sum / cfg.Channels)
+	}
+	return n
+}
+
+// AudioStats counts samples lost by the capture. Overruns happen when
+// the reader doesn't keep up with the device, samples are dropped when
+// the pipeline doesn't keep up with the reader.
+type AudioStats struct {
+	Overruns uint64
+	Dropped  uint64
+}
+
+// DeviceInfo describes an ALSA PCM.
+type DeviceInfo struct {
+	Name        string
+	Description string
+	Input       bool
+	Output      bool
+}
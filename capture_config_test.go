@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestConvertFrames(t *testing.T) {
+	s24 := func(v int32) []byte {
+		return []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+	}
+	f32 := func(v float32) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, math.Float32bits(v))
+		return b
+	}
+	le32 := func(v int32) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, uint32(v))
+		return b
+	}
+	cat := func(bs ...[]byte) []byte {
+		var r []byte
+		for _, b := range bs {
+			r = append(r, b...)
+		}
+		return r
+	}
+	tests := []struct {
+		name     string
+		format   string
+		channels int
+		channel  int
+		src      []byte
+		want     []int16
+	}{
+		{"s16", "S16_LE", 1, -1, []byte{0x34, 0x12, 0xff, 0xff}, []int16{0x1234, -1}},
+		{"s24_3", "S24_3LE", 1, -1, cat(s24(0x123456), s24(-0x100)), []int16{0x1234, -1}},
+		{"s24", "S24_LE", 1, -1, cat(le32(0x123456), le32(-0x123456)), []int16{0x1234, -0x1235}},
+		{"s32", "S32_LE", 1, -1, cat(le32(0x12345678), le32(math.MinInt32)), []int16{0x1234, -32768}},
+		{"float", "FLOAT_LE", 1, -1, cat(f32(0.5), f32(-2)), []int16{16384, -32768}},
+		{"pick", "S16_LE", 2, 1, []byte{1, 0, 2, 0, 3, 0, 4, 0}, []int16{2, 4}},
+		{"mixdown", "S16_LE", 2, -1, []byte{0x10, 0, 0x20, 0, 0xff, 0x7f, 0xff, 0x7f}, []int16{0x18, 32767}},
+	}
+	for _, tt := range tests {
+		cfg := CaptureConfig{Format: tt.format, Channels: tt.channels, Channel: tt.channel}
+		if len(tt.src)%cfg.frameSize() != 0 {
+			t.Fatalf("%s: %v bytes aren't whole frames", tt.name, len(tt.src))
+		}
+		dst := make([]int16, 8)
+		n := convertFrames(dst, tt.src, cfg)
+		if n != len(tt.want) {
+			t.Errorf("%s: converted %v samples, want %v", tt.name, n, len(tt.want))
+			continue
+		}
+		for i := range tt.want {
+			if dst[i] != tt.want[i] {
+				t.Errorf("%s: sample %v is %v, want %v", tt.name, i, dst[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestConvertFramesShortDestination(t *testing.T) {
+	cfg := defaultCaptureConfig("default")
+	dst := make([]int16, 1)
+	if n := convertFrames(dst, []byte{1, 0, 2, 0}, cfg); n != 1 || dst[0] != 1 {
+		t.Errorf("Converted %v samples %v", n, dst)
+	}
+}
+
+func TestCaptureConfigValidate(t *testing.T) {
+	if err := defaultCaptureConfig("default").validate(); err != nil {
+		t.Errorf("Default config is invalid: %v", err)
+	}
+	bad := []func(*CaptureConfig){
+		func(c *CaptureConfig) { c.Format = "U8" },
+		func(c *CaptureConfig) { c.Channels = 0 },
+		func(c *CaptureConfig) { c.Channel = 1 },
+		func(c *CaptureConfig) { c.Channel = -2 },
+		func(c *CaptureConfig) { c.PeriodSize = 0 },
+		func(c *CaptureConfig) { c.BufferSize = -1 },
+	}
+	for i, f := range bad {
+		cfg := defaultCaptureConfig("default")
+		f(&cfg)
+		if err := cfg.validate(); err == nil {
+			t.Errorf("Config %v %+v is valid", i, cfg)
+		}
+	}
+}
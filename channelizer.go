@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"github.com/mjibson/go-dsp/fft"
+)
+
+const (
+	channelizerTaps   = 8   // Taps of every branch of the polyphase filter.
+	minChannelSpacing = 50  // Hz, wider channels than blocks aren't decoded.
+	maxChannelSpacing = 500 // Hz
+	channelMinSNR     = 10  // dB, channels keyed weaker than that stay silent.
+)
+
+// Channelizer splits audio into channels of equal width centred on
+// multiples of the spacing with a polyphase DFT filter bank. The branches
+// of the prototype low-pass weigh the history into a frame of m samples,
+// one FFT of the frame gives a sample of every channel. Frames overlap
+// by half, so a channel is sampled at twice its width and a carrier
+// between two channels isn't lost.
+type Channelizer struct {
+	m, d  int
+	h     []float64
+	hist  []float64
+	next  int   // Index in hist of the newest sample of the next frame.
+	count int64 // Samples before hist[next], they rotate the channels to baseband.
+	frame []float64
+}
+
+func NewChannelizer(spacing float64) (*Channelizer, error) {
+	if spacing < minChannelSpacing || spacing > maxChannelSpacing {
+		return nil, fmt.Errorf("Channel spacing %v Hz isn't between %v and %v Hz", spacing, minChannelSpacing, maxChannelSpacing)
+	}
+	m := 2 * int(math.Round(sampleRate/spacing/2))
+	n := m * channelizerTaps
+	return &Channelizer{
+		m:     m,
+		d:     m / 2,
+		h:     windowSincKernelLp(n-1, 0.5/float64(m)),
+		hist:  make([]float64, n-1),
+		next:  n - 1 + m/2 - 1,
+		count: int64(m/2 - 1),
+		frame: make([]float64, m),
+	}, nil
+}
+
+// Spacing is the distance between channel centres in Hz.
+func (c *Channelizer) Spacing() float64 {
+	return sampleRate / float64(c.m)
+}
+
+// Rate is the sampling rate of a channel.
+func (c *Channelizer) Rate() float64 {
+	return sampleRate / float64(c.d)
+}
+
+// band returns the channels from lo up to hi inclusive whose centres are
+// in the band-pass in front of the decoder.
+func (c *Channelizer) band() (lo, hi int) {
+	return int(math.Ceil(bandLow * float64(c.m))), int(math.Floor(bandHigh * float64(c.m)))
+}
+
+// Process appends a frame of samples of channels from 0 up to the
+// Nyquist frequency to dst for every d samples of src.
+func (c *Channelizer) Process(dst [][]complex128, src []float64) [][]complex128 {
+	c.hist = append(c.hist, src...)
+	for c.next < len(c.hist) {
+		for p := range c.frame {
+			var u float64
+			for i := p; i < len(c.h); i += c.m {
+				u += c.h[i] * c.hist[c.next-i]
+			}
+			c.frame[p] = u
+		}
+		spectrum := fft.FFTReal(c.frame)
+		channels := make([]complex128, c.m/2+1)
+		for k := range channels {
+			// The frame is filtered by channels modulated up, the spectrum
+			// is conjugated and rotated back to baseband.
+			rot := cmplx.Rect(1, -2*math.Pi*float64(int64(k)*c.count%int64(c.m))/float64(c.m))
+			channels[k] = cmplx.Conj(spectrum[k]) * rot
+		}
+		dst = append(dst, channels)
+		c.next += c.d
+		c.count += int64(c.d)
+	}
+	// Only the history needed by the next frame is kept.
+	drop := c.next - len(c.h) + 1
+	if drop > len(c.hist) {
+		drop = len(c.hist)
+	}
+	if drop > 0 {
+		n := copy(c.hist, c.hist[drop:])
+		c.hist = c.hist[:n]
+		c.next -= drop
+	}
+	return dst
+}
+
+// channelEnvelopes is the pipeline stage turning every fragment into the
+// mean magnitudes of the channels of the band within the fragment. They
+// are decoded like spectra with a bin per channel.
+func channelEnvelopes(ctx context.Context, in <-chan Fragment, c *Channelizer) <-chan SpectrumBlock {
+	out := make(chan SpectrumBlock)
+	lo, hi := c.band()
+	go func() {
+		defer close(out)
+		var frames [][]complex128
+		last := make([]float64, hi-lo+1)
+		for {
+			var f Fragment
+			select {
+			case b, ok := <-in:
+				if !ok {
+					return
+				}
+				f = b
+			case <-ctx.Done():
+				return
+			}
+			frames = c.Process(frames[:0], f.Samples)
+			envelopes := make([]float64, hi-lo+1)
+			if len(frames) == 0 {
+				copy(envelopes, last)
+			}
+			for _, frame := range frames {
+				for k := range envelopes {
+					envelopes[k] += cmplx.Abs(frame[lo+k]) / float64(len(frames))
+				}
+			}
+			last = envelopes
+			select {
+			case out <- SpectrumBlock{Magnitudes: envelopes, Stamp: f.Stamp}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// ChannelDecoder decodes every channel of envelopes with a decoder of
+// its own. A carrier leaks into neighbouring channels, only the channel
+// where it is strongest is reported.
+type ChannelDecoder struct {
+	decoders []*StreamDecoder
+	sinks    []*channelSink
+	levels   []float64
+	block    int
+}
+
+// NewChannelDecoder decodes the channels of the band, envelopes start
+// with the lowest one.
+func NewChannelDecoder(c *Channelizer, sink EventSink) *ChannelDecoder {
+	lo, hi := c.band()
+	cd := &ChannelDecoder{levels: make([]float64, hi-lo+1)}
+	for k := lo; k <= hi; k++ {
+		cs := &channelSink{sink: sink, frequency: float64(k) * c.Spacing()}
+		cd.sinks = append(cd.sinks, cs)
+		cd.decoders = append(cd.decoders, NewStreamDecoder(cs))
+	}
+	return cd
+}
+
+func (cd *ChannelDecoder) Add(envelopes []float64, at Stamp) {
+	for k, e := range envelopes {
+		// Mean over the window the decoders look at.
+		cd.levels[k] += (e - cd.levels[k]) / decoderWindow
+	}
+	if cd.block%decoderInterval == 0 {
+		for k, cs := range cd.sinks {
+			cs.strongest = (k == 0 || cd.levels[k] >= cd.levels[k-1]) &&
+				(k == len(cd.levels)-1 || cd.levels[k] >= cd.levels[k+1])
+		}
+	}
+	for k, d := range cd.decoders {
+		d.Add(envelopes[k:k+1], at)
+	}
+	cd.block++
+}
+
+func (cd *ChannelDecoder) setKeyingDetector(kind string) error {
+	for _, d := range cd.decoders {
+		if err := d.setKeyingDetector(kind); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cd *ChannelDecoder) setDecision(opts DecisionOptions) error {
+	for _, d := range cd.decoders {
+		if err := d.setDecision(opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cd *ChannelDecoder) Flush() {
+	for _, d := range cd.decoders {
+		d.Flush()
+	}
+}
+
+// channelSink passes events of a channel decoder on while the channel
+// carries a keyed signal. Decoders see a single bin, frequencies of their
+// events are replaced by the frequency of the channel.
+type channelSink struct {
+	sink      EventSink
+	frequency float64
+	snr       float64
+	strongest bool
+}
+
+func (cs *channelSink) open() bool {
+	return cs.strongest && cs.snr >= channelMinSNR
+}
+
+func (cs *channelSink) Element(e ElementEvent) {
+	if cs.open() {
+		e.Frequency = cs.frequency
+		cs.sink.Element(e)
+	}
+}
+
+func (cs *channelSink) Character(c CharacterEvent) {
+	if cs.open() {
+		c.Frequency = cs.frequency
+		cs.sink.Character(c)
+	}
+}
+
+func (cs *channelSink) Status(s StatusEvent) {
+	cs.snr = s.Snr
+	if cs.open() {
+		s.Frequency = cs.frequency
+		cs.sink.Status(s)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChannelizerTone(t *testing.T) {
+	c, err := NewChannelizer(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(c.Spacing()-100) > 0.5 {
+		t.Fatalf("Spacing is %v", c.Spacing())
+	}
+	k := 12
+	in := sine(sampleRate, float64(k)*c.Spacing(), sampleRate, 10000)
+	var frames [][]complex128
+	// Blocks not lining up with frames.
+	for i := 0; i < len(in); i += 1000 {
+		frames = c.Process(frames, in[i:minInt(i+1000, len(in))])
+	}
+	if n := int(c.Rate()); len(frames) < n-1 || len(frames) > n {
+		t.Fatalf("Got %v frames, want %v", len(frames), n)
+	}
+	frames = frames[2*channelizerTaps:]
+	for _, frame := range frames {
+		if a := cmplx.Abs(frame[k]); math.Abs(a-5000) > 50 {
+			t.Fatalf("Channel %v magnitude is %v, want 5000", k, a)
+		}
+		for _, j := range []int{k - 2, k + 2, 30} {
+			if a := cmplx.Abs(frame[j]); a > 50 {
+				t.Fatalf("Channel %v magnitude is %v", j, a)
+			}
+		}
+	}
+	// The channel is at baseband, its phase doesn't turn.
+	if d := cmplx.Abs(frames[0][k]/frames[len(frames)-1][k] - 1); d > 0.05 {
+		t.Errorf("Channel phase turned by %v", cmplx.Phase(frames[0][k]/frames[len(frames)-1][k]))
+	}
+}
+
+func TestChannelizerBetweenChannels(t *testing.T) {
+	c, _ := NewChannelizer(100)
+	in := sine(sampleRate/2, 12.5*c.Spacing(), sampleRate, 10000)
+	frames := c.Process(nil, in)
+	frame := frames[len(frames)-1]
+	for _, k := range []int{12, 13} {
+		if a := cmplx.Abs(frame[k]); a < 2000 {
+			t.Errorf("Channel %v magnitude is %v", k, a)
+		}
+	}
+}
+
+func TestChannelizerSpacing(t *testing.T) {
+	for _, spacing := range []float64{10, 1000} {
+		if _, err := NewChannelizer(spacing); err == nil {
+			t.Errorf("Spacing %v Hz is accepted", spacing)
+		}
+	}
+}
+
+// keyedTone renders keyed elements as a tone of frequency f Hz. Durations
+// of elements are in blocks.
+func keyedTone(es []Element, f, amplitude float64) []float64 {
+	var s []float64
+	for _, e := range es {
+		for n := 0; n < e.d*fragmentSize; n++ {
+			v := 0.0
+			if e.s {
+				v = amplitude * math.Sin(2*math.Pi*f*float64(len(s))/sampleRate)
+			}
+			s = append(s, v)
+		}
+	}
+	return s
+}
+
+type channelText struct {
+	text     map[float64]*strings.Builder
+	elements map[float64]int
+}
+
+func (ct *channelText) Element(e ElementEvent) {
+	ct.elements[e.Frequency]++
+}
+
+func (ct *channelText) Character(c CharacterEvent) {
+	if ct.text[c.Frequency] == nil {
+		ct.text[c.Frequency] = &strings.Builder{}
+	}
+	ct.text[c.Frequency].WriteString(c.Text)
+}
+
+func (ct *channelText) Status(s StatusEvent) {
+}
+
+// decodeChannels decodes audio with a channel decoder.
+func decodeChannels(t testing.TB, audio []float64, spacing float64, sink EventSink) {
+	c, err := NewChannelizer(spacing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	in := make(chan Fragment)
+	go func() {
+		defer close(in)
+		for i := 0; i+fragmentSize <= len(audio); i += fragmentSize {
+			in <- Fragment{Samples: audio[i : i+fragmentSize], Stamp: blockStamp(i / fragmentSize)}
+		}
+	}()
+	cd := NewChannelDecoder(c, sink)
+	timeout := time.After(time.Minute)
+	envelopes := channelEnvelopes(ctx, in, c)
+	for {
+		select {
+		case sp, ok := <-envelopes:
+			if !ok {
+				cd.Flush()
+				return
+			}
+			cd.Add(sp.Magnitudes, sp.Stamp)
+		case <-timeout:
+			t.Fatalf("Channels weren't decoded in time")
+		}
+	}
+}
+
+// channelStations are two stations keyed on different channels 100 Hz
+// apart.
+var channelStations = []struct {
+	text    string
+	channel int
+	dit     int
+}{
+	{"cq cq cq de ab1c ab1c k", 8, 6},
+	{"test test test de xy2z k", 15, 5},
+}
+
+func channelStationsAudio(c *Channelizer) []float64 {
+	rnd := rand.New(rand.NewSource(1))
+	var audio []float64
+	for _, st := range channelStations {
+		s := keyedTone(encodeText(st.text, st.dit), float64(st.channel)*c.Spacing()+10, 5000)
+		for len(audio) < len(s) {
+			audio = append(audio, 0)
+		}
+		for i, v := range s {
+			audio[i] += v
+		}
+	}
+	for i := range audio {
+		audio[i] += 200 * rnd.NormFloat64()
+	}
+	return audio
+}
+
+func TestChannelDecoder(t *testing.T) {
+	c, _ := NewChannelizer(100)
+	stations := channelStations
+	sink := &channelText{map[float64]*strings.Builder{}, map[float64]int{}}
+	decodeChannels(t, channelStationsAudio(c), c.Spacing(), sink)
+	for _, st := range stations {
+		f := float64(st.channel) * c.Spacing()
+		text := sink.text[f]
+		if text == nil || sink.elements[f] == 0 {
+			t.Errorf("Nothing decoded at %v Hz", f)
+			continue
+		}
+		// The decoder needs a second of audio before it locks on.
+		if want := st.text[strings.Index(st.text, "de"):]; !strings.Contains(text.String(), want) {
+			t.Errorf("Decoded %q at %v Hz, want %q", text.String(), f, want)
+		}
+	}
+	for f, text := range sink.text {
+		if f != float64(stations[0].channel)*c.Spacing() && f != float64(stations[1].channel)*c.Spacing() {
+			t.Errorf("Decoded %q at %v Hz", text.String(), f)
+		}
+	}
+	for f, n := range sink.elements {
+		if f != float64(stations[0].channel)*c.Spacing() && f != float64(stations[1].channel)*c.Spacing() {
+			t.Errorf("%v elements at %v Hz", n, f)
+		}
+	}
+}
+
+func TestChannelSpotter(t *testing.T) {
+	c, _ := NewChannelizer(100)
+	var spots []string
+	sp := NewSpotter("N0CALL", 7000, func(s Spot) {
+		spots = append(spots, fmt.Sprintf("%v %v %.1f", s.Kind, s.Call, s.Frequency))
+	})
+	decodeChannels(t, channelStationsAudio(c), c.Spacing(), sp)
+	sp.Flush()
+	// Words of the stations sent at the same time aren't mixed.
+	sort.Strings(spots)
+	if got := strings.Join(spots, ", "); got != "CQ AB1C 7000.8, DE XY2Z 7001.5" {
+		t.Errorf("Spotted %v", got)
+	}
+}
+
+func benchmarkChannelDecoder(b *testing.B, spacing float64) {
+	c, _ := NewChannelizer(spacing)
+	lo, hi := c.band()
+	audio := make([]float64, sampleRate)
+	for k := lo; k <= hi; k++ {
+		for i, v := range keyedTone(encodeText("cq test", 4), float64(k)*c.Spacing(), 1000) {
+			if i < len(audio) {
+				audio[i] += v
+			}
+		}
+	}
+	sink := &channelText{map[float64]*strings.Builder{}, map[float64]int{}}
+	cd := NewChannelDecoder(c, sink)
+	var frames [][]complex128
+	envelopes := make([]float64, hi-lo+1)
+	b.ResetTimer()
+	start := time.Now()
+	block := 0
+	for i := 0; i < b.N; i++ {
+		for j := 0; j+fragmentSize <= len(audio); j += fragmentSize {
+			frames = c.Process(frames[:0], audio[j:j+fragmentSize])
+			for k := range envelopes {
+				envelopes[k] = 0
+				for _, frame := range frames {
+					envelopes[k] += cmplx.Abs(frame[lo+k])
+				}
+			}
+			cd.Add(envelopes, blockStamp(block))
+			block++
+		}
+	}
+	// Every iteration is a second of audio.
+	elapsed := time.Since(start).Seconds() / float64(b.N)
+	b.ReportMetric(elapsed*1000, "ms/audio-s")
+	b.ReportMetric(float64(hi-lo+1)/elapsed, "realtime-channels")
+}
+
+func BenchmarkChannelDecoder200Hz(b *testing.B) {
+	benchmarkChannelDecoder(b, 200)
+}
+
+func BenchmarkChannelDecoder100Hz(b *testing.B) {
+	benchmarkChannelDecoder(b, 100)
+}
+
+func BenchmarkChannelDecoder50Hz(b *testing.B) {
+	benchmarkChannelDecoder(b, 50)
+}
+
+func BenchmarkChannelizer(b *testing.B) {
+	c, _ := NewChannelizer(50)
+	audio := sine(sampleRate, 1000, sampleRate, 10000)
+	var frames [][]complex128
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		frames = c.Process(frames[:0], audio)
+	}
+	b.ReportMetric(time.Since(start).Seconds()*1000/float64(b.N), "ms/audio-s")
+}
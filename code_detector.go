@@ -2,8 +2,10 @@ package main
 
 import (
 	"fmt"
-	"os"
+	"math"
 	"sort"
+
+	log "github.com/sirupsen/logrus"
 )
 
 const (
@@ -84,42 +86,152 @@ type Element struct {
 	s bool
 }
 
-func detectCode(ds []Element) string {
-	ditMean, dahMean := classifySignals(ds)
-	fmt.Printf("dit mean: %v\n", ditMean)
-	fmt.Printf("dah mean: %v\n", dahMean)
-	ditGap := ditMean
-	charGap := dahMean
+type ElementClass int
+
+const (
+	Dit ElementClass = iota
+	Dah
+	DitGap
+	CharGap
+	WordGap
+)
+
+func (c ElementClass) String() string {
+	switch c {
+	case Dit:
+		return "dit"
+	case Dah:
+		return "dah"
+	case DitGap:
+		return "dit gap"
+	case CharGap:
+		return "char gap"
+	case WordGap:
+		return "word gap"
+	}
+	return "unknown"
+}
+
+// Timing holds the mean durations of dits and dahs in blocks.
+// Gap durations are derived from them.
+type Timing struct {
+	dit int
+	dah int
+}
+
+func (t Timing) classify(e Element) ElementClass {
+	if e.s {
+		if abs(e.d-t.dit) < abs(e.d-t.dah) {
+			return Dit
+		}
+		return Dah
+	}
+	ditGap := t.dit
+	charGap := t.dah
 	wordGap := 7 * ditGap
+	if abs(e.d-charGap) < abs(e.d-ditGap) && abs(e.d-charGap) < abs(e.d-wordGap) {
+		return CharGap
+	}
+	if abs(e.d-wordGap) < abs(e.d-ditGap) && abs(e.d-wordGap) < abs(e.d-charGap) {
+		return WordGap
+	}
+	return DitGap
+}
+
+// confidence tells how well duration of a mark fits its class.
+// It is 1 for an exact match and 0.5 for a mark right between a dit and a dah.
+func (t Timing) confidence(e Element) float64 {
+	a := abs(e.d - t.dit)
+	b := abs(e.d - t.dah)
+	if a+b == 0 {
+		return 1
+	}
+	if a > b {
+		a, b = b, a
+	}
+	return float64(b) / float64(a+b)
+}
+
+// wpm converts dit duration into words per minute using PARIS timing.
+func (t Timing) wpm() float64 {
+	if t.dit == 0 {
+		return 0
+	}
+	return 1.2 / blockTime(t.dit)
+}
+
+// Character is a decoded letter or a word space.
+// start and end are block offsets of the character in the signal.
+type Character struct {
+	text       string
+	start      int
+	end        int
+	confidence float64
+}
+
+func detectCode(ds []Element) (string, error) {
+	chars, err := decodeCharacters(ds)
+	if err != nil {
+		return "", err
+	}
+	str := ""
+	for _, c := range chars {
+		str += c.text
+	}
+	return str, nil
+}
+
+func decodeCharacters(ds []Element) ([]Character, error) {
+	ditMean, dahMean, err := classifySignals(ds)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("dit mean: %v", ditMean)
+	log.Debugf("dah mean: %v", dahMean)
+	timing := Timing{ditMean, dahMean}
 	res := make([]byte, 0, len(ds))
 	char := make([]byte, 0, 4)
-	str := ""
+	chars := make([]Character, 0)
+	pos := 0
+	charStart := 0
+	confidence := 1.0
+	flush := func() {
+		if t := string(alphabet[code(char)]); t != "" {
+			chars = append(chars, Character{t, charStart, pos, confidence})
+		}
+		char = char[0:0]
+		confidence = 1
+	}
 	for i := 0; i < len(ds); i++ {
 		if ds[i].s {
-			if abs(ds[i].d-ditMean) < abs(ds[i].d-dahMean) {
-				res = append(res, '.')
-				char = append(char, '.')
-			} else {
-				res = append(res, '-')
-				char = append(char, '-')
-			}
-		} else {
-			if abs(ds[i].d-charGap) < abs(ds[i].d-ditGap) && abs(ds[i].d-charGap) < abs(ds[i].d-wordGap) {
-				res = append(res, '>')
-				str += string(alphabet[code(char)])
-				char = char[0:0]
+			confidence = math.Min(confidence, timing.confidence(ds[i]))
+		}
+		switch timing.classify(ds[i]) {
+		case Dit:
+			if len(char) == 0 {
+				charStart = pos
 			}
-			if abs(ds[i].d-wordGap) < abs(ds[i].d-ditGap) && abs(ds[i].d-wordGap) < abs(ds[i].d-charGap) {
-				res = append(res, '<')
-				str += string(alphabet[code(char)])
-				str += " "
-				char = char[0:0]
+			res = append(res, '.')
+			char = append(char, '.')
+		case Dah:
+			if len(char) == 0 {
+				charStart = pos
 			}
+			res = append(res, '-')
+			char = append(char, '-')
+		case CharGap:
+			res = append(res, '>')
+			flush()
+		case WordGap:
+			res = append(res, '<')
+			flush()
+			chars = append(chars, Character{" ", pos, pos + ds[i].d, 1})
 		}
+		pos += ds[i].d
 	}
-	fmt.Printf("res: %v\n", string(res))
-	str += string(alphabet[code(char)])
-	return str
+	log.Debugf("res: %v", string(res))
+	flush()
+	return chars, nil
 }
 
 func abs(x int) int {
@@ -136,12 +248,7 @@ func absInt16(x int16) int16 {
 	return x
 }
 
-func classifyGaps(ds []Element) (ditGap int, charGap int, wordGap int) {
-	f, err := os.Create("classifyGaps.log")
-	if err != nil {
-		os.Exit(1)
-	}
-	defer f.Close()
+func classifyGaps(ds []Element) (ditGap int, charGap int, wordGap int, err error) {
 	unsortedGaps := make([]int, 0)
 	gaps := make([]int, 0)
 	for _, d := range ds {
@@ -151,15 +258,18 @@ func classifyGaps(ds []Element) (ditGap int, charGap int, wordGap int) {
 		}
 	}
 	sort.IntSlice(gaps).Sort()
+	if len(gaps) < 3 {
+		return 0, 0, 0, fmt.Errorf("%w: %v gaps", ErrInsufficientData, len(gaps))
+	}
 
 	lastDitGap := gaps[0]
 	lastCharGap := lastDitGap * 3
 	lastWordGap := gaps[len(gaps)-1]
 
-	fmt.Fprintf(f, "Gaps: %v\n", gaps)
+	log.Debugf("Gaps: %v", gaps)
 
 	for {
-		fmt.Fprintf(f, "DitGap: %v, CharGap: %v, WordGap: %v\n", lastDitGap, lastCharGap, lastWordGap)
+		log.Debugf("DitGap: %v, CharGap: %v, WordGap: %v", lastDitGap, lastCharGap, lastWordGap)
 		border1 := 0
 		border2 := 0
 		for i, s := range gaps {
@@ -174,7 +284,10 @@ func classifyGaps(ds []Element) (ditGap int, charGap int, wordGap int) {
 				break
 			}
 		}
-		fmt.Fprintf(f, "border1: %v, border2: %v\n", border1, border2)
+		log.Debugf("border1: %v, border2: %v", border1, border2)
+		if border1 == 0 || border2 <= border1 {
+			return 0, 0, 0, fmt.Errorf("%w: gaps %v", ErrAmbiguousTiming, gaps)
+		}
 		ditGapMean := 0
 		for i := 0; i < border1; i++ {
 			ditGapMean += gaps[i]
@@ -201,11 +314,11 @@ func classifyGaps(ds []Element) (ditGap int, charGap int, wordGap int) {
 		lastWordGap = wordGapMean
 	}
 
-	return lastDitGap, lastCharGap, lastWordGap
+	return lastDitGap, lastCharGap, lastWordGap, nil
 }
 
 // K-means for classifying dots and dashes
-func classifySignals(ds []Element) (int, int) {
+func classifySignals(ds []Element) (int, int, error) {
 	unsortedSignals := make([]int, 0)
 	signals := make([]int, 0)
 	for _, d := range ds {
@@ -215,6 +328,12 @@ func classifySignals(ds []Element) (int, int) {
 		}
 	}
 	sort.IntSlice(signals).Sort()
+	if len(signals) == 0 {
+		return 0, 0, fmt.Errorf("%w: no marks", ErrInsufficientData)
+	}
+	if signals[0] == signals[len(signals)-1] {
+		return 0, 0, fmt.Errorf("%w: all marks are %v blocks long", ErrAmbiguousTiming, signals[0])
+	}
 
 	lastDotMean := signals[0]
 	lastDahMean := signals[len(signals)-1]
@@ -226,6 +345,9 @@ func classifySignals(ds []Element) (int, int) {
 				break
 			}
 		}
+		if border == 0 {
+			return 0, 0, fmt.Errorf("%w: marks %v", ErrAmbiguousTiming, signals)
+		}
 		ditMean := 0
 		for i := 0; i < border; i++ {
 			ditMean += signals[i]
@@ -242,5 +364,5 @@ func classifySignals(ds []Element) (int, int) {
 		lastDotMean = ditMean
 		lastDahMean = dahMean
 	}
-	return lastDotMean, lastDahMean
+	return lastDotMean, lastDahMean, nil
 }
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// splitLetters splits a word into letters keeping prosigns like <aa> whole.
+func splitLetters(w string) []letter {
+	ls := make([]letter, 0, len(w))
+	for len(w) > 0 {
+		n := 1
+		if w[0] == '<' {
+			n = strings.IndexByte(w, '>') + 1
+		}
+		ls = append(ls, letter(w[:n]))
+		w = w[n:]
+	}
+	return ls
+}
+
+// encodeText keys the text with the given dit duration in blocks.
+func encodeText(text string, dit int) []Element {
+	es := make([]Element, 0)
+	gap := func(d int) {
+		if len(es) > 0 && !es[len(es)-1].s {
+			es[len(es)-1].d += d
+		} else {
+			es = append(es, Element{d, false})
+		}
+	}
+	for _, w := range strings.Fields(text) {
+		for _, l := range splitLetters(w) {
+			for _, s := range morse[l] {
+				if s == '.' {
+					es = append(es, Element{dit, true})
+				} else {
+					es = append(es, Element{3 * dit, true})
+				}
+				gap(dit)
+			}
+			gap(2 * dit)
+		}
+		gap(4 * dit)
+	}
+	return es
+}
+
+func TestDetectCodeRoundTrip(t *testing.T) {
+	for _, text := range []string{
+		"cq cq de test k",
+		"the quick brown fox jumps over the lazy dog",
+		"0123456789",
+		"5nn tu 73",
+	} {
+		for _, dit := range []int{2, 3, 5} {
+			s, err := detectCode(encodeText(text, dit))
+			if err != nil {
+				t.Errorf("Decoding %q with dit %v: %v", text, dit, err)
+				continue
+			}
+			if strings.TrimSpace(s) != text {
+				t.Errorf("Decoding %q with dit %v: %q", text, dit, s)
+			}
+		}
+	}
+}
+
+func TestDetectCodeEveryLetter(t *testing.T) {
+	for l := range morse {
+		// Every letter is sent next to a dit and a dah to make timing unambiguous.
+		text := "e " + string(l) + " t"
+		s, err := detectCode(encodeText(text, 3))
+		if err != nil {
+			t.Errorf("Decoding %q: %v", text, err)
+			continue
+		}
+		if strings.TrimSpace(s) != text {
+			t.Errorf("Decoding %q: %q", text, s)
+		}
+	}
+}
+
+func TestDetectCodeErrors(t *testing.T) {
+	if _, err := detectCode(nil); !errors.Is(err, ErrInsufficientData) {
+		t.Errorf("Empty input: %v", err)
+	}
+	if _, err := detectCode(encodeText("eee", 3)); !errors.Is(err, ErrAmbiguousTiming) {
+		t.Errorf("Dits only: %v", err)
+	}
+}
+
+func TestDecodeCharactersOffsets(t *testing.T) {
+	es := encodeText("et", 2)
+	chars, err := decodeCharacters(es)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Character{{"e", 0, 2, 1}, {"t", 8, 14, 1}, {" ", 14, 28, 1}}
+	if len(chars) != len(expected) {
+		t.Fatalf("Characters: %v", chars)
+	}
+	for i := range expected {
+		if chars[i] != expected[i] {
+			t.Errorf("Character %v: %v, expected %v", i, chars[i], expected[i])
+		}
+	}
+}
+
+func TestTimingClassify(t *testing.T) {
+	timing := Timing{3, 9}
+	for _, c := range []struct {
+		e     Element
+		class ElementClass
+	}{
+		{Element{3, true}, Dit},
+		{Element{4, true}, Dit},
+		{Element{8, true}, Dah},
+		{Element{3, false}, DitGap},
+		{Element{9, false}, CharGap},
+		{Element{21, false}, WordGap},
+		{Element{100, false}, WordGap},
+	} {
+		if class := timing.classify(c.e); class != c.class {
+			t.Errorf("classify(%v) = %v, expected %v", c.e, class, c.class)
+		}
+	}
+}
+
+func FuzzDetectCode(f *testing.F) {
+	f.Add([]byte{3, 3, 9, 3, 3, 9})
+	f.Add([]byte{1, 1, 1})
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		es := make([]Element, len(data))
+		for i, b := range data {
+			es[i] = Element{int(b%64) + 1, i%2 == 0}
+		}
+		chars, err := decodeCharacters(es)
+		if err != nil {
+			return
+		}
+		total := 0
+		for _, e := range es {
+			total += e.d
+		}
+		for _, c := range chars {
+			if c.start < 0 || c.end < c.start || c.end > total {
+				t.Errorf("Character %v outside of signal of %v blocks", c, total)
+			}
+			if c.confidence < 0.5 || c.confidence > 1 {
+				t.Errorf("Character %v has invalid confidence", c)
+			}
+		}
+	})
+}
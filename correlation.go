@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/opts"
+)
+
+const defaultUpperCorrelationBin = 222
+
+// correlateFile calculates correlation between spectrum bins of a file.
+// bins restricts spectrum bins, blocks restricts the time window in blocks.
+// Zero upper bounds mean no restriction. The matrix is written to
+// out.csv or out.npy depending on format and the heatmap to out.html.
+func correlateFile(name string, bins *Range, blocks *Range, out string, format string) error {
+	cut, binLb, err := readCorrelationWindow(name, bins, blocks)
+	if err != nil {
+		return err
+	}
+	matr := correlationMatrix(cut)
+
+	switch format {
+	case "csv":
+		err = writeMatrixCsv(out+".csv", matr)
+	case "npy":
+		err = writeMatrixNpy(out+".npy", matr)
+	default:
+		err = fmt.Errorf("Unknown matrix format: %v", format)
+	}
+	if err != nil {
+		return err
+	}
+	return drawCorrelationHeatMap(out+".html", matr, binLb)
+}
+
+// readCorrelationWindow keeps only the requested bins of the blocks
+// in the time window, the rest of the file is never held in memory.
+func readCorrelationWindow(name string, bins *Range, blocks *Range) ([][]float64, int, error) {
+	file, err := openRecording(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	lb, ub := clampRange(blocks, math.MaxInt)
+	binLb, binUb := clampRange(bins, defaultUpperCorrelationBin)
+	sr := NewSpectrumReader(file)
+	var cut [][]float64
+	for block := 0; block < ub; block++ {
+		_, _, spectrum, err := sr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if block >= lb {
+			cut = append(cut, append([]float64(nil), spectrum[binLb:binUb]...))
+		}
+	}
+	if len(cut) < 2 || binUb-binLb < 1 {
+		return nil, 0, fmt.Errorf("Not enough data to correlate: %v blocks, %v bins", len(cut), binUb-binLb)
+	}
+	return cut, binLb, nil
+}
+
+// clampRange converts optional range into slice bounds within [0, n].
+func clampRange(r *Range, n int) (int, int) {
+	if r == nil {
+		return 0, n
+	}
+	lb := int(r.lb)
+	ub := int(r.ub)
+	if ub <= 0 || ub > n {
+		ub = n
+	}
+	if lb < 0 {
+		lb = 0
+	}
+	if lb > ub {
+		lb = ub
+	}
+	return lb, ub
+}
+
+func writeMatrixCsv(name string, matr [][]float64) (err error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	w := bufio.NewWriter(f)
+	row := make([]string, 0, len(matr))
+	for i := 0; i < len(matr); i++ {
+		row = row[:0]
+		for j := 0; j < len(matr[i]); j++ {
+			row = append(row, strconv.FormatFloat(matr[i][j], 'g', -1, 64))
+		}
+		if _, err := w.WriteString(strings.Join(row, ",") + "\n"); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// writeMatrixNpy writes a square matrix in numpy .npy format version 1.0.
+func writeMatrixNpy(name string, matr [][]float64) (err error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	w := bufio.NewWriter(f)
+
+	header := fmt.Sprintf("{'descr': '<f8', 'fortran_order': False, 'shape': (%d, %d), }", len(matr), len(matr))
+	// Magic, version and header length take 10 bytes. Whole preamble
+	// has to be aligned to 64 bytes and terminated by a new line.
+	for (10+len(header)+1)%64 != 0 {
+		header += " "
+	}
+	header += "\n"
+	if _, err := w.WriteString("\x93NUMPY\x01\x00"); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint16(len(header))); err != nil {
+		return err
+	}
+	if _, err := w.WriteString(header); err != nil {
+		return err
+	}
+	for i := 0; i < len(matr); i++ {
+		if err := binary.Write(w, binary.LittleEndian, matr[i]); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func drawCorrelationHeatMap(name string, matr [][]float64, firstBin int) error {
+	axis := make([]string, len(matr))
+	for i := 0; i < len(matr); i++ {
+		axis[i] = fmt.Sprintf("%.0f", binFrequency(firstBin+i))
+	}
+	data := make([]opts.HeatMapData, 0, len(matr)*len(matr))
+	for i := 0; i < len(matr); i++ {
+		for j := 0; j < len(matr[i]); j++ {
+			var v interface{} = matr[i][j]
+			if math.IsNaN(matr[i][j]) {
+				// Constant bins have no correlation, echarts skips "-".
+				v = "-"
+			}
+			data = append(data, opts.HeatMapData{Value: [3]interface{}{i, j, v}})
+		}
+	}
+
+	hm := charts.NewHeatMap()
+	hm.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{Title: "Correlation of spectrum bins"}),
+		charts.WithInitializationOpts(opts.Initialization{Width: "900px", Height: "900px"}),
+		charts.WithTooltipOpts(opts.Tooltip{Show: true}),
+		charts.WithXAxisOpts(opts.XAxis{Name: "Hz", Type: "category"}),
+		charts.WithYAxisOpts(opts.YAxis{Name: "Hz", Type: "category", Data: axis}),
+		charts.WithVisualMapOpts(opts.VisualMap{
+			Calculable: true,
+			Min:        -1,
+			Max:        1,
+			InRange:    &opts.VisualMapInRange{Color: []string{"#313695", "#f2f2f2", "#a50026"}},
+		}),
+	)
+	hm.SetXAxis(axis).AddSeries("correlation", data)
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := hm.Render(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// readNpy reads a matrix written by writeMatrixNpy checking the header.
+func readNpy(t *testing.T, name string) [][]float64 {
+	t.Helper()
+	raw, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(raw, []byte("\x93NUMPY\x01\x00")) {
+		t.Fatalf("Magic and version are %q", raw[:8])
+	}
+	n := int(binary.LittleEndian.Uint16(raw[8:10]))
+	if (10+n)%64 != 0 {
+		t.Errorf("Preamble of %v bytes isn't aligned", 10+n)
+	}
+	header := string(raw[10 : 10+n])
+	if !strings.HasSuffix(header, "\n") {
+		t.Errorf("Header %q isn't terminated by a new line", header)
+	}
+	if !strings.Contains(header, "'descr': '<f8'") || !strings.Contains(header, "'fortran_order': False") {
+		t.Errorf("Header is %q", header)
+	}
+	data := raw[10+n:]
+	size := int(math.Sqrt(float64(len(data) / 8)))
+	if !strings.Contains(header, "'shape': ("+strconv.Itoa(size)+", "+strconv.Itoa(size)+")") {
+		t.Errorf("Header %q doesn't match %v values", header, len(data)/8)
+	}
+	matr := make([][]float64, size)
+	for i := range matr {
+		matr[i] = make([]float64, size)
+		for j := range matr[i] {
+			matr[i][j] = math.Float64frombits(binary.LittleEndian.Uint64(data[8*(i*size+j):]))
+		}
+	}
+	return matr
+}
+
+func equalMatrices(a, b [][]float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if len(a[i]) != len(b[i]) {
+			return false
+		}
+		for j := range a[i] {
+			if a[i][j] != b[i][j] && !(math.IsNaN(a[i][j]) && math.IsNaN(b[i][j])) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+var testMatrix = [][]float64{
+	{1, -0.25, math.NaN()},
+	{-0.25, 1, 1.0 / 3},
+	{math.NaN(), 1.0 / 3, 1},
+}
+
+func TestWriteMatrixCsv(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "m.csv")
+	if err := writeMatrixCsv(name, testMatrix); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	matr := make([][]float64, len(records))
+	for i, r := range records {
+		for _, s := range r {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			matr[i] = append(matr[i], v)
+		}
+	}
+	if !equalMatrices(matr, testMatrix) {
+		t.Errorf("Read %v, want %v", matr, testMatrix)
+	}
+}
+
+func TestWriteMatrixNpy(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "m.npy")
+	if err := writeMatrixNpy(name, testMatrix); err != nil {
+		t.Fatal(err)
+	}
+	if matr := readNpy(t, name); !equalMatrices(matr, testMatrix) {
+		t.Errorf("Read %v, want %v", matr, testMatrix)
+	}
+}
+
+func TestWriteMatrixErrors(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip(err)
+	}
+	if err := writeMatrixCsv("/dev/full", testMatrix); err == nil {
+		t.Errorf("Csv write error is dropped")
+	}
+	if err := writeMatrixNpy("/dev/full", testMatrix); err == nil {
+		t.Errorf("Npy write error is dropped")
+	}
+}
+
+func TestClampRange(t *testing.T) {
+	for _, c := range []struct {
+		r      *Range
+		lb, ub int
+	}{
+		{nil, 0, 100},
+		{&Range{0, 0}, 0, 100},
+		{&Range{10, 20}, 10, 20},
+		{&Range{-5, 500}, 0, 100},
+		{&Range{50, 20}, 20, 20},
+	} {
+		if lb, ub := clampRange(c.r, 100); lb != c.lb || ub != c.ub {
+			t.Errorf("%+v: got %v, %v, want %v, %v", c.r, lb, ub, c.lb, c.ub)
+		}
+	}
+}
+
+func TestCorrelateFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "paris.raw")
+	if err := os.WriteFile(name, keyedPCM(encodeText("paris paris", 6), 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cut, binLb, err := readCorrelationWindow(name, &Range{10, 20}, &Range{5, 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cut) != 45 || len(cut[0]) != 10 || binLb != 10 {
+		t.Fatalf("Window of %v blocks, %v bins from %v", len(cut), len(cut[0]), binLb)
+	}
+	out := filepath.Join(dir, "paris-correlation")
+	for _, format := range []string{"csv", "npy"} {
+		if err := correlateFile(name, &Range{10, 20}, &Range{5, 50}, out, format); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(out + "." + format); err != nil {
+			t.Error(err)
+		}
+	}
+	matr := readNpy(t, out+".npy")
+	if !equalMatrices(matr, correlationMatrix(cut)) {
+		t.Errorf("Matrix of the window isn't written")
+	}
+	// The keyed tone correlates with itself.
+	if math.Abs(matr[5][5]-1) > 1e-9 {
+		t.Errorf("Correlation of the tone bin with itself is %v", matr[5][5])
+	}
+	if _, err := os.Stat(out + ".html"); err != nil {
+		t.Error(err)
+	}
+	if err := correlateFile(name, nil, nil, out, "mat"); err == nil {
+		t.Errorf("Unknown format is accepted")
+	}
+	if _, _, err := readCorrelationWindow(name, &Range{10, 20}, &Range{5, 6}); err == nil {
+		t.Errorf("Window of a block is accepted")
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/cmplx"
+
+	"github.com/mjibson/go-dsp/fft"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	minCWBandwidth = 50
+	maxCWBandwidth = 500
+	cwFilterPoles  = 4
+	cwFilterGlide  = 0.05 // Seconds the filter takes to reach a new carrier.
+)
+
+// CWFilter is a narrow band-pass around the carrier. The audio is mixed
+// down by the carrier frequency, low-pass filtered by a cascade of one
+// pole filters and mixed back up, so the filter retunes without clicks.
+// The cascade has no overshoot, keying isn't smeared into ringing.
+type CWFilter struct {
+	alpha  float64
+	glide  float64
+	stages [cwFilterPoles]complex128
+	phase  float64
+	// Hz, the filter glides from frequency to target.
+	frequency, target float64
+}
+
+// NewCWFilter returns a filter of the bandwidth in Hz, it passes audio
+// unchanged until it is tuned.
+func NewCWFilter(bandwidth float64) (*CWFilter, error) {
+	if bandwidth < minCWBandwidth || bandwidth > maxCWBandwidth {
+		return nil, fmt.Errorf("CW filter bandwidth %v Hz isn't between %v and %v Hz", bandwidth, minCWBandwidth, maxCWBandwidth)
+	}
+	// Cutoff of every pole making the cascade 3 dB down at half the bandwidth.
+	fc := bandwidth / 2 / math.Sqrt(math.Pow(2, 1.0/cwFilterPoles)-1)
+	return &CWFilter{
+		alpha: 1 - math.Exp(-2*math.Pi*fc/sampleRate),
+		glide: 1 - math.Exp(-1/(cwFilterGlide*sampleRate)),
+	}, nil
+}
+
+// Tune moves the filter to the carrier at frequency Hz. The first
+// tuning is immediate, later ones glide.
+func (f *CWFilter) Tune(frequency float64) {
+	if f.target == 0 {
+		f.frequency = frequency
+	}
+	f.target = frequency
+}
+
+func (f *CWFilter) Frequency() float64 {
+	return f.frequency
+}
+
+// Process appends filtered src to dst.
+func (f *CWFilter) Process(dst []float64, src []float64) []float64 {
+	if f.target == 0 {
+		return append(dst, src...)
+	}
+	for _, x := range src {
+		f.frequency += (f.target - f.frequency) * f.glide
+		f.phase += 2 * math.Pi * f.frequency / sampleRate
+		if f.phase > math.Pi {
+			f.phase -= 2 * math.Pi
+		}
+		lo := cmplx.Rect(1, f.phase)
+		y := complex(x, 0) * cmplx.Conj(lo)
+		for i := range f.stages {
+			f.stages[i] += complex(f.alpha, 0) * (y - f.stages[i])
+			y = f.stages[i]
+		}
+		// The other half of the power was at the negative frequency.
+		dst = append(dst, 2*real(y*lo))
+	}
+	return dst
+}
+
+// carrierTracker finds the carrier in spectra of recent blocks the
+// same way the stream decoder does, but between spectrum bins.
+type carrierTracker struct {
+	window [][]float64
+	block  int
+}
+
+// add takes the spectrum of the next block and returns the carrier
+// frequency in Hz whenever it is re-estimated.
+func (ct *carrierTracker) add(spectrum []float64) (float64, bool) {
+	ct.window = append(ct.window, spectrum)
+	if len(ct.window) > decoderWindow {
+		ct.window = ct.window[len(ct.window)-decoderWindow:]
+	}
+	ct.block++
+	if len(ct.window) < decoderInterval || ct.block%decoderInterval != 0 {
+		return 0, false
+	}
+	bin, err := calculateSignificantFrequency(ct.window)
+	if err != nil || bin == 0 || bin == len(spectrum)-1 {
+		return 0, false
+	}
+	var a, b, c float64
+	for _, s := range ct.window {
+		a, b, c = a+s[bin-1], b+s[bin], c+s[bin+1]
+	}
+	// Vertex of the parabola through the bin and its neighbours.
+	delta := 0.0
+	if d := a - 2*b + c; d != 0 {
+		delta = 0.5 * (a - c) / d
+	}
+	return (float64(bin) + delta) * sampleRate / fragmentSize, true
+}
+
+// blockSpectrum is the spectrum of a block as the pipeline calculates it.
+func blockSpectrum(samples []float64) []float64 {
+	buf := make([]float64, len(samples))
+	copy(buf, samples)
+	hann(buf)
+	return ToAbs(fft.FFTReal(buf))[0:222]
+}
+
+// narrowFilter is the pipeline stage centring a CW filter on the carrier
+// of the band-pass filtered fragments.
+func narrowFilter(ctx context.Context, in <-chan Fragment, bandwidth float64) (<-chan Fragment, error) {
+	filter, err := NewCWFilter(bandwidth)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan Fragment)
+	go func() {
+		defer close(out)
+		tracker := &carrierTracker{}
+		for {
+			var f Fragment
+			select {
+			case b, ok := <-in:
+				if !ok {
+					return
+				}
+				f = b
+			case <-ctx.Done():
+				return
+			}
+			if frequency, ok := tracker.add(blockSpectrum(f.Samples)); ok {
+				log.Debugf("CW filter tuned to %.1f Hz", frequency)
+				filter.Tune(frequency)
+			}
+			f.Samples = filter.Process(make([]float64, 0, len(f.Samples)), f.Samples)
+			f.Carrier = filter.Frequency()
+			select {
+			case out <- f:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// monitorAudio writes every fragment as S16_LE PCM to w for listening.
+// Writing stops on the first error, decoding goes on.
+func monitorAudio(ctx context.Context, in <-chan Fragment, w io.Writer) <-chan Fragment {
+	out := make(chan Fragment)
+	go func() {
+		defer close(out)
+		pcm := make([]int16, fragmentSize)
+		raw := make([]byte, 2*fragmentSize)
+		for {
+			var f Fragment
+			select {
+			case b, ok := <-in:
+				if !ok {
+					return
+				}
+				f = b
+			case <-ctx.Done():
+				return
+			}
+			if w != nil {
+				for i, v := range f.Samples {
+					pcm[i] = clampInt16(v)
+				}
+				encodePCM(raw, pcm[:len(f.Samples)])
+				if _, err := w.Write(raw[:2*len(f.Samples)]); err != nil {
+					log.Warnf("Stopped monitoring audio: %v", err)
+					w = nil
+				}
+			}
+			select {
+			case out <- f:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// filterRecording writes the recording band-pass filtered, through the
+// optional stages and, if bandwidth isn't 0, narrow filtered around the
+// carrier into out.
+func filterRecording(name, out string, bandwidth float64, opts ReaderOptions) error {
+	var filter *CWFilter
+	if bandwidth != 0 {
+		var err error
+		if filter, err = NewCWFilter(bandwidth); err != nil {
+			return err
+		}
+	}
+	file, err := openRecording(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	sr := NewSpectrumReader(file)
+	if err := sr.configure(opts); err != nil {
+		return err
+	}
+	rf, err := createRecordingFile(out, nil)
+	if err != nil {
+		return err
+	}
+	tracker := &carrierTracker{}
+	// Audio before the first tuning is kept until the carrier is known,
+	// for a window of blocks at most.
+	var pending [][]float64
+	untuned := false
+	write := func(samples []float64) error {
+		pcm := make([]int16, len(samples))
+		for i, v := range samples {
+			pcm[i] = clampInt16(v)
+		}
+		raw := make([]byte, 2*len(pcm))
+		encodePCM(raw, pcm)
+		_, err := rf.Write(raw)
+		return err
+	}
+	for {
+		_, res, spectrum, err := sr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rf.Close()
+			return err
+		}
+		if filter == nil {
+			err = write(res)
+		} else {
+			if frequency, ok := tracker.add(spectrum[0:222]); ok {
+				filter.Tune(frequency)
+			}
+			pending = append(pending, res)
+			if filter.Frequency() == 0 && !untuned && len(pending) >= decoderWindow {
+				log.Warnf("No carrier found in the first %v blocks of %v, writing them band-pass filtered", len(pending), name)
+				untuned = true
+			}
+			if filter.Frequency() != 0 || untuned {
+				for _, p := range pending {
+					if err = write(filter.Process(nil, p)); err != nil {
+						break
+					}
+				}
+				pending = pending[:0]
+			}
+		}
+		if err != nil {
+			rf.Close()
+			return err
+		}
+	}
+	// Without a carrier the audio is only band-pass filtered.
+	if len(pending) > 0 {
+		log.Warnf("No carrier found in %v", name)
+	}
+	for _, p := range pending {
+		if err := write(filter.Process(nil, p)); err != nil {
+			rf.Close()
+			return err
+		}
+	}
+	return rf.Close()
+}
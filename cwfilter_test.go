@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestCWFilterResponse(t *testing.T) {
+	for _, bandwidth := range []float64{50, 100, 500} {
+		f, err := NewCWFilter(bandwidth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Tune(800)
+		gain := func(frequency float64) float64 {
+			out := f.Process(nil, sine(sampleRate, frequency, sampleRate, 1000))
+			return goertzel(out[sampleRate/2:], frequency) / 1000
+		}
+		if g := gain(800); math.Abs(g-1) > 0.01 {
+			t.Errorf("%v Hz: gain at the carrier is %v", bandwidth, g)
+		}
+		if g := gain(800 + bandwidth/2); math.Abs(g-math.Sqrt(0.5)) > 0.02 {
+			t.Errorf("%v Hz: gain at the edge is %v, want -3 dB", bandwidth, g)
+		}
+		if g := gain(800 + 3*bandwidth); g > 0.02 {
+			t.Errorf("%v Hz: gain 3 bandwidths away is %v", bandwidth, g)
+		}
+	}
+	if _, err := NewCWFilter(20); err == nil {
+		t.Errorf("Bandwidth of 20 Hz is accepted")
+	}
+}
+
+func TestCWFilterGlides(t *testing.T) {
+	f, _ := NewCWFilter(100)
+	f.Tune(700)
+	f.Process(nil, sine(sampleRate/10, 700, sampleRate, 1000))
+	f.Tune(800)
+	out := f.Process(nil, sine(sampleRate/100, 800, sampleRate, 1000))
+	if f.Frequency() <= 700 || f.Frequency() >= 800 {
+		t.Errorf("Filter jumped to %v Hz", f.Frequency())
+	}
+	// Retuning doesn't click, the output doesn't exceed the input.
+	for i, v := range out {
+		if math.Abs(v) > 1000 {
+			t.Fatalf("Sample %v is %v while retuning", i, v)
+		}
+	}
+	out = f.Process(nil, sine(sampleRate, 800, sampleRate, 1000))
+	if math.Abs(f.Frequency()-800) > 0.1 {
+		t.Errorf("Filter is at %v Hz", f.Frequency())
+	}
+	if g := goertzel(out[sampleRate/2:], 800) / 1000; math.Abs(g-1) > 0.01 {
+		t.Errorf("Gain after retuning is %v", g)
+	}
+}
+
+func TestCarrierTracker(t *testing.T) {
+	s := sine(2*sampleRate, 777, sampleRate, 1000)
+	ct := &carrierTracker{}
+	var frequency float64
+	found := false
+	for i := 0; i+fragmentSize <= len(s); i += fragmentSize {
+		if f, ok := ct.add(blockSpectrum(s[i : i+fragmentSize])); ok {
+			frequency, found = f, true
+		}
+	}
+	if !found || math.Abs(frequency-777) > 5 {
+		t.Errorf("Carrier at %v Hz, found %v", frequency, found)
+	}
+}
+
+func TestNarrowFilterStage(t *testing.T) {
+	ctx := context.Background()
+	// A carrier and a stronger neighbour 300 Hz away. The tracker tunes
+	// to the stronger one, so the neighbour is what survives.
+	s := tone(3*sampleRate, 12)
+	n := tone(3*sampleRate, 15.5)
+	in := make(chan *SampleBlock, 3*sampleRate/fragmentSize+1)
+	for i := 0; i+fragmentSize <= len(s); i += fragmentSize {
+		b := newSampleBlock(fragmentSize)
+		for j := range b.Samples {
+			b.Samples[j] = s[i+j]/2 + n[i+j]
+		}
+		in <- b
+	}
+	close(in)
+	out, err := narrowFilter(ctx, filterSignal(ctx, in), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var audio []float64
+	for f := range out {
+		audio = append(audio, f.Samples...)
+	}
+	if len(audio) != 3*sampleRate/fragmentSize*fragmentSize {
+		t.Fatalf("Got %v samples", len(audio))
+	}
+	last := audio[2*sampleRate:]
+	strong := goertzel(last, binFrequency(15)+binFrequency(1)/2)
+	weak := goertzel(last, binFrequency(12))
+	if strong < 9000 || weak > 100 {
+		t.Errorf("Carrier amplitude %v, neighbour amplitude %v", strong, weak)
+	}
+}
+
+func TestFilterRecording(t *testing.T) {
+	dir := t.TempDir()
+	in := sine(3*sampleRate, 1000, sampleRate, 5000)
+	for i, v := range sine(3*sampleRate, 1300, sampleRate, 2000) {
+		in[i] += v
+	}
+	name := filepath.Join(dir, "in.raw")
+	writePCM(t, name, in)
+	out := filepath.Join(dir, "out.wav")
+	if err := filterRecording(name, out, 100, ReaderOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	audio := readAll(t, out)
+	if len(audio) != len(in)/fragmentSize*fragmentSize {
+		t.Fatalf("Got %v samples", len(audio))
+	}
+	if a := goertzel(audio[sampleRate:], 1000); math.Abs(a-5000) > 250 {
+		t.Errorf("Carrier amplitude is %v, want 5000", a)
+	}
+	if a := goertzel(audio[sampleRate:], 1300); a > 50 {
+		t.Errorf("Neighbour amplitude is %v", a)
+	}
+}
+
+func TestFilterRecordingWithoutCarrier(t *testing.T) {
+	dir := t.TempDir()
+	// Silence longer than the window kept until the carrier is found.
+	in := make([]float64, (decoderWindow+decoderInterval/2)*fragmentSize)
+	name := filepath.Join(dir, "in.raw")
+	writePCM(t, name, in)
+	out := filepath.Join(dir, "out.raw")
+	if err := filterRecording(name, out, 100, ReaderOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if audio := readAll(t, out); len(audio) != len(in) {
+		t.Errorf("Got %v samples, want %v", len(audio), len(in))
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"math"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	decoderWindow   = 20 * sampleRate / fragmentSize // Blocks used for detecting carrier.
+	decoderInterval = sampleRate / fragmentSize      // Blocks between detector updates.
+	decoderHistory  = 64                             // Elements used for estimating timing.
+)
+
+// StreamDecoder decodes morse code from a stream of spectra block by block.
+// The carrier and the detector are re-estimated periodically on a window
+// of the most recent spectra. A block is step samples long, durations
+// are counted in blocks.
+type StreamDecoder struct {
+	sink       EventSink
+	step       int
+	windowSize int
+	interval   int
+
+	window    [][]float64
+	block     int
+	stamp     Stamp // Of the current block.
+	frequency int
+	detector  *EMSingleFrequencyDetector
+	threshold float64
+	// Correlates the envelope with a dit, nil decides on every block alone.
+	matched  *matchedFilter
+	filtered float64 // Previous magnitude or correlation.
+
+	decision  DecisionOptions
+	keyed     bool // Previous decision of the detector.
+	squelched bool
+	debouncer *debouncer
+	at        decided // The block decided last.
+	// Elements of the detector before the debouncer. Minimum durations
+	// are relative to their dit, the dit of merged elements only grows.
+	detected        Element
+	detectedHistory []Element
+	detectedDit     int
+
+	current      Element
+	currentStart int
+	currentStamp Stamp
+	history      []Element
+	timing       *Timing
+
+	marks      []Element
+	charStart  int
+	charStamp  Stamp
+	spaceSent  bool
+	textLength int
+}
+
+func NewStreamDecoder(sink EventSink) *StreamDecoder {
+	return newStreamDecoderStep(sink, fragmentSize)
+}
+
+// newStreamDecoderStep decodes blocks of step samples, the window and
+// the interval between updates are as long as with full blocks.
+func newStreamDecoderStep(sink EventSink, step int) *StreamDecoder {
+	return &StreamDecoder{
+		sink:       sink,
+		step:       step,
+		windowSize: decoderWindow * fragmentSize / step,
+		interval:   decoderInterval * fragmentSize / step,
+		debouncer:  &debouncer{},
+	}
+}
+
+// Add decodes the spectrum of the next block, at is the stamp of
+// its first sample.
+func (sd *StreamDecoder) Add(spectrum []float64, at Stamp) {
+	sd.stamp = at
+	sd.window = append(sd.window, spectrum)
+	if len(sd.window) > sd.windowSize {
+		sd.window = sd.window[len(sd.window)-sd.windowSize:]
+	}
+	if len(sd.window) >= sd.interval && (sd.detector == nil || sd.block%sd.interval == 0) {
+		sd.retune()
+	}
+	if sd.detector != nil {
+		if sd.matched != nil {
+			sd.pushMatched(spectrum[sd.frequency], at)
+		} else {
+			sd.pushSignal(spectrum[sd.frequency], at)
+		}
+	}
+	sd.block++
+}
+
+// setKeyingDetector selects one of keyingDetectors deciding whether
+// blocks are keyed.
+func (sd *StreamDecoder) setKeyingDetector(kind string) error {
+	if err := checkKeyingDetector(kind); err != nil {
+		return err
+	}
+	sd.matched = nil
+	if kind == "matched" {
+		sd.matched = newMatchedFilter(ditBlocks(matchedStartWpm, sd.step), sd.step)
+	}
+	return nil
+}
+
+// setDecision configures the decision stage between the detector and
+// elements.
+func (sd *StreamDecoder) setDecision(opts DecisionOptions) error {
+	if err := checkDecision(opts); err != nil {
+		return err
+	}
+	sd.decision = opts
+	sd.debouncer = &debouncer{opts: opts}
+	return nil
+}
+
+// pushSignal decides on the magnitude of the carrier. Boundaries of
+// elements are put between blocks where it crosses half way between the
+// means.
+func (sd *StreamDecoder) pushSignal(v float64, at Stamp) {
+	keyed := sd.detector.isSignal(v)
+	if sd.decision.Hysteresis > 0 {
+		off, on := sd.decision.thresholds(sd.detector, sd.threshold)
+		keyed = hysteresis(v, off, on, sd.keyed)
+	}
+	if sd.current.d > 0 && sd.keyed != keyed {
+		f := crossing(sd.filtered, v, sd.detector.midpoint())
+		sd.stamp = at.after(int(math.Round((f - 0.5) * float64(sd.step))))
+	}
+	sd.filtered = v
+	sd.push(keyed)
+}
+
+// pushMatched decides on the correlation of the envelope with a dit.
+// The correlation lags, stamps are moved back by its delay. Boundaries
+// of elements are put between blocks where it crosses the threshold.
+func (sd *StreamDecoder) pushMatched(v float64, at Stamp) {
+	y := sd.matched.filter(v)
+	off, on := sd.decision.thresholds(sd.detector, sd.threshold)
+	keyed := hysteresis(y, off, on, sd.keyed)
+	delay := int(math.Round(sd.matched.delay() * float64(sd.step)))
+	sd.stamp = at.before(delay)
+	if sd.current.d > 0 && sd.keyed != keyed {
+		threshold := off
+		if keyed {
+			threshold = on
+		}
+		f := crossing(sd.filtered, y, threshold)
+		sd.stamp = sd.stamp.after(int(math.Round((f - 0.5) * float64(sd.step))))
+	}
+	sd.filtered = y
+	sd.push(keyed)
+}
+
+func (sd *StreamDecoder) retune() {
+	frequency, err := calculateSignificantFrequency(sd.window)
+	if err != nil {
+		return
+	}
+	signals := extractFrequency(sd.window, frequency)
+	detector, err := classifyEMFromSingleFrequency(cleanupSignal(signals))
+	if err != nil {
+		// Keep the previous detector until the carrier is found again.
+		log.Debugf("Failed to retune: %v", err)
+		return
+	}
+	sd.frequency = frequency
+	sd.detector = detector
+	sd.threshold = detector.midpoint()
+	if sd.matched == nil {
+		sd.threshold = detector.threshold()
+	}
+	if squelched := sd.decision.squelched(detector); squelched && !sd.squelched {
+		log.Debugf("Squelch closed at %.1f dB", detector.snr())
+	} else if !squelched && sd.squelched {
+		log.Debugf("Squelch opened at %.1f dB", detector.snr())
+	}
+	sd.squelched = sd.decision.squelched(detector)
+	timing := Timing{}
+	if sd.timing != nil {
+		timing = *sd.timing
+	}
+	status := newStatusEvent(sd.stamp, timing, sd.detector, sd.frequency)
+	// Timing is in blocks of the decoder.
+	status.Wpm = status.Wpm * fragmentSize / float64(sd.step)
+	sd.sink.Status(status)
+}
+
+// push passes the decision of the detector on the current block through
+// the decision stage.
+func (sd *StreamDecoder) push(v bool) {
+	sd.keyed = v
+	if sd.squelched {
+		v = false
+	}
+	if sd.decision.MinMark == 0 && sd.decision.MinSpace == 0 {
+		sd.pushDecided(decided{v, sd.block, sd.stamp})
+		return
+	}
+	if sd.detected.d > 0 && sd.detected.s != v {
+		sd.detectedHistory = appendHistory(sd.detectedHistory, sd.detected)
+		// The first elements are decided with the first detector,
+		// few of them don't tell dits from dahs either.
+		if len(sd.detectedHistory) >= decoderHistory/2 {
+			if dit, _, err := classifySignals(sd.detectedHistory); err == nil {
+				sd.detectedDit = dit
+			}
+		}
+		sd.detected = Element{}
+	}
+	sd.detected.s = v
+	sd.detected.d++
+	for _, b := range sd.debouncer.add(decided{v, sd.block, sd.stamp}, sd.detectedDit) {
+		sd.pushDecided(b)
+	}
+}
+
+func (sd *StreamDecoder) pushDecided(b decided) {
+	sd.at = b
+	v := b.on
+	if sd.current.d > 0 && sd.current.s != v {
+		sd.finish(b.stamp)
+		sd.current = Element{}
+	}
+	if sd.current.d == 0 {
+		sd.current.s = v
+		sd.currentStart = b.block
+		sd.currentStamp = b.stamp
+	}
+	sd.current.d++
+
+	if sd.current.s {
+		sd.spaceSent = false
+		return
+	}
+	if sd.timing == nil {
+		return
+	}
+	// Don't wait for the next mark to emit a character.
+	class := sd.timing.classify(sd.current)
+	if class == CharGap || class == WordGap {
+		sd.flush()
+	}
+	if class == WordGap && !sd.spaceSent && sd.textLength > 0 {
+		space := Character{" ", sd.currentStart, b.block + 1, 1}
+		sd.sink.Character(newCharacterEvent(space, sd.currentStamp, b.stamp.after(sd.step), sd.frequency))
+		sd.spaceSent = true
+	}
+}
+
+// finish handles the element that has just ended before end.
+func (sd *StreamDecoder) finish(end Stamp) {
+	e := sd.current
+	sd.history = appendHistory(sd.history, e)
+	sd.updateTiming()
+	if sd.timing == nil {
+		return
+	}
+	sd.sink.Element(newElementEvent(e, sd.currentStamp, end, sd.timing.classify(e), sd.frequency))
+	if e.s {
+		if len(sd.marks) == 0 {
+			sd.charStart = sd.currentStart
+			sd.charStamp = sd.currentStamp
+		}
+		sd.marks = append(sd.marks, e)
+	}
+}
+
+// appendHistory appends e to the history of the last decoderHistory
+// elements.
+func appendHistory(history []Element, e Element) []Element {
+	history = append(history, e)
+	if len(history) > decoderHistory {
+		history = history[len(history)-decoderHistory:]
+	}
+	return history
+}
+
+// updateTiming estimates dit and dah durations once marks of different
+// length have been seen.
+func (sd *StreamDecoder) updateTiming() {
+	dit, dah, err := classifySignals(sd.history)
+	if err != nil {
+		return
+	}
+	sd.timing = &Timing{dit, dah}
+	if sd.matched != nil {
+		sd.matched.setDit(float64(dit), sd.step)
+	}
+}
+
+// Flush finishes decoding at the end of the input.
+func (sd *StreamDecoder) Flush() {
+	for _, b := range sd.debouncer.flush() {
+		sd.pushDecided(b)
+	}
+	if sd.current.d > 0 {
+		sd.finish(sd.at.stamp.after(sd.step))
+		sd.current = Element{}
+	}
+	if sd.timing != nil {
+		sd.flush()
+	}
+}
+
+// flush emits the character collected from marks so far.
+func (sd *StreamDecoder) flush() {
+	if len(sd.marks) == 0 {
+		return
+	}
+	char := make([]byte, 0, len(sd.marks))
+	confidence := 1.0
+	for _, e := range sd.marks {
+		if sd.timing.classify(e) == Dit {
+			char = append(char, '.')
+		} else {
+			char = append(char, '-')
+		}
+		if c := sd.timing.confidence(e); c < confidence {
+			confidence = c
+		}
+	}
+	sd.marks = sd.marks[0:0]
+	text := string(alphabet[code(char)])
+	if text == "" {
+		return
+	}
+	c := Character{text, sd.charStart, sd.currentStart, confidence}
+	sd.sink.Character(newCharacterEvent(c, sd.charStamp, sd.currentStamp, sd.frequency))
+	sd.textLength++
+}
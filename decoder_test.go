@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+type eventRecorder struct {
+	elements   []ElementEvent
+	characters []CharacterEvent
+}
+
+func (er *eventRecorder) Element(e ElementEvent) {
+	er.elements = append(er.elements, e)
+}
+
+func (er *eventRecorder) Character(c CharacterEvent) {
+	er.characters = append(er.characters, c)
+}
+
+func (er *eventRecorder) Status(s StatusEvent) {
+}
+
+func TestStreamDecoderStamps(t *testing.T) {
+	const dit = 6
+	es := encodeText(strings.Repeat("paris ", 5), dit)
+	sr := NewSpectrumReader(bytes.NewReader(keyedPCM(es, 2)))
+	er := &eventRecorder{}
+	decoder := NewStreamDecoder(er)
+	// Live capture started 1000 samples before the first block.
+	origin := Stamp{1000, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	for block := 0; ; block++ {
+		_, _, spectrum, err := sr.Next()
+		if err == io.EOF {
+			break
+		}
+		decoder.Add(spectrum[0:222], origin.after(block*fragmentSize))
+	}
+	decoder.Flush()
+
+	if len(er.characters) == 0 || len(er.elements) == 0 {
+		t.Fatalf("Nothing decoded")
+	}
+	check := func(what string, start, end int64, startTime, endTime *time.Time) {
+		// Keying of the recording is aligned with blocks.
+		if d := (start - origin.Offset + fragmentSize/2) % fragmentSize; d < fragmentSize/4 || d > fragmentSize*3/4 || end < start {
+			t.Errorf("%v has wrong samples: %v, %v", what, start, end)
+		}
+		if startTime == nil || endTime == nil {
+			t.Fatalf("%v has no wall clock time", what)
+		}
+		expected := origin.Time.Add(time.Duration(start-origin.Offset) * time.Second / sampleRate)
+		if d := startTime.Sub(expected); d < -time.Microsecond || d > time.Microsecond {
+			t.Errorf("%v starts at %v, expected %v", what, startTime, expected)
+		}
+	}
+	var last int64
+	for _, e := range er.elements {
+		check("Element", e.StartSample, e.EndSample, e.StartTime, e.EndTime)
+		if e.StartSample < last {
+			t.Errorf("Element at %v overlaps the previous one", e.StartSample)
+		}
+		last = e.EndSample
+	}
+	for _, c := range er.characters {
+		check("Character "+c.Text, c.StartSample, c.EndSample, c.StartTime, c.EndTime)
+	}
+
+	// The last letter ends where the trailing word gap begins.
+	total := 0
+	for _, e := range es {
+		total += e.d
+	}
+	end := origin.Offset + int64((total-7*dit)*fragmentSize)
+	lastChar := er.characters[len(er.characters)-1]
+	if lastChar.Text == " " {
+		lastChar = er.characters[len(er.characters)-2]
+	}
+	if d := lastChar.EndSample - end; d < -fragmentSize || d > fragmentSize {
+		t.Errorf("Last character ends at %v, expected %v", lastChar.EndSample, end)
+	}
+}
+
+func TestEmitDetectionStamps(t *testing.T) {
+	er := &eventRecorder{}
+	d := &Detection{frequency: 15, detector: &EMSingleFrequencyDetector{m: []float64{10, 1}}}
+	for _, e := range encodeText("ab ab", 3) {
+		for i := 0; i < e.d; i++ {
+			d.values = append(d.values, e.s)
+		}
+	}
+	if err := emitDetection(er, d); err != nil {
+		t.Fatal(err)
+	}
+	if er.characters[0].StartSample != 0 || er.characters[0].EndSample != 15*fragmentSize {
+		t.Errorf("Unexpected samples of the first letter: %v", er.characters[0])
+	}
+	if er.characters[0].StartTime != nil {
+		t.Errorf("Recording has wall clock time")
+	}
+}
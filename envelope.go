@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"math"
+)
+
+const (
+	hilbertTaps    = 255 // Odd, the delay of the in-phase path is a whole sample.
+	envelopeStep   = 64  // Samples between envelope values the decoder gets.
+	envelopeCutoff = 150 // Hz of the smoother, keying edges stay a few ms long.
+)
+
+// hilbertKernel designs a Hilbert transformer of m+1 taps with
+// the Blackman window, m must be even. Every other tap is zero.
+func hilbertKernel(m int) []float64 {
+	h := make([]float64, m+1)
+	mF := float64(m)
+	for i := 0; i <= m; i++ {
+		n := i - m/2
+		if n%2 == 0 {
+			continue
+		}
+		iF := float64(i)
+		w := 0.42 - 0.5*math.Cos(2*math.Pi*iF/mF) + 0.08*math.Cos(4*math.Pi*iF/mF)
+		h[i] = w * 2 / (math.Pi * float64(n))
+	}
+	return h
+}
+
+// EnvelopeDetector takes the magnitude of the analytic signal of the
+// audio and smooths it with a low-pass. The quadrature part comes from
+// a Hilbert transformer, the in-phase part is delayed to match it. Unlike
+// block magnitudes the envelope follows keying within a few samples.
+type EnvelopeDetector struct {
+	hilbert  []float64
+	hist     []float64
+	smoother *IIRFilter
+	step     int
+	phase    int
+}
+
+// NewEnvelopeDetector returns a detector giving an envelope value every
+// step samples.
+func NewEnvelopeDetector(step int) (*EnvelopeDetector, error) {
+	smoother, err := NewButterworthLp(2, float64(envelopeCutoff)/sampleRate)
+	if err != nil {
+		return nil, err
+	}
+	return &EnvelopeDetector{
+		hilbert:  hilbertKernel(hilbertTaps - 1),
+		hist:     make([]float64, hilbertTaps-1),
+		smoother: smoother,
+		step:     step,
+	}, nil
+}
+
+// Process appends the envelope of src to dst.
+func (ed *EnvelopeDetector) Process(dst []float64, src []float64) []float64 {
+	ed.hist = append(ed.hist, src...)
+	n := len(ed.hilbert)
+	magnitudes := make([]float64, len(src))
+	for t := range src {
+		x := ed.hist[t : t+n]
+		var q float64
+		// Only taps an odd distance from the middle are non-zero.
+		for j := (n/2 + 1) % 2; j < n; j += 2 {
+			q += ed.hilbert[j] * x[n-1-j]
+		}
+		i := x[n-1-n/2]
+		magnitudes[t] = math.Hypot(i, q)
+	}
+	ed.hist = ed.hist[:copy(ed.hist, ed.hist[len(src):])]
+	for _, v := range ed.smoother.FilterBuf(magnitudes) {
+		if ed.phase == 0 {
+			dst = append(dst, v)
+		}
+		ed.phase = (ed.phase + 1) % ed.step
+	}
+	return dst
+}
+
+// envelopeSignal is the pipeline stage turning every narrow filtered
+// fragment into its envelope. Magnitudes of the blocks are envelope
+// values envelopeStep samples apart.
+func envelopeSignal(ctx context.Context, in <-chan Fragment) (<-chan SpectrumBlock, error) {
+	ed, err := NewEnvelopeDetector(envelopeStep)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan SpectrumBlock)
+	go func() {
+		defer close(out)
+		for {
+			var f Fragment
+			select {
+			case b, ok := <-in:
+				if !ok {
+					return
+				}
+				f = b
+			case <-ctx.Done():
+				return
+			}
+			envelope := ed.Process(make([]float64, 0, len(f.Samples)/envelopeStep+1), f.Samples)
+			select {
+			case out <- SpectrumBlock{envelope, f.Stamp, f.Carrier}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// EnvelopeDecoder decodes envelopes with a decoder stepping through every
+// value. Events carry the frequency the narrow filter is tuned to.
+type EnvelopeDecoder struct {
+	decoder *StreamDecoder
+	sink    *frequencySink
+}
+
+func NewEnvelopeDecoder(sink EventSink) *EnvelopeDecoder {
+	fs := &frequencySink{sink: sink}
+	return &EnvelopeDecoder{newStreamDecoderStep(fs, envelopeStep), fs}
+}
+
+// Tune sets the carrier frequency in Hz of the following envelopes.
+func (ed *EnvelopeDecoder) Tune(frequency float64) {
+	ed.sink.frequency = frequency
+}
+
+func (ed *EnvelopeDecoder) Add(envelope []float64, at Stamp) {
+	for i := range envelope {
+		ed.decoder.Add(envelope[i:i+1], at.after(i*envelopeStep))
+	}
+}
+
+func (ed *EnvelopeDecoder) setKeyingDetector(kind string) error {
+	return ed.decoder.setKeyingDetector(kind)
+}
+
+func (ed *EnvelopeDecoder) setDecision(opts DecisionOptions) error {
+	return ed.decoder.setDecision(opts)
+}
+
+func (ed *EnvelopeDecoder) Flush() {
+	ed.decoder.Flush()
+}
+
+// frequencySink replaces frequencies of events with the one it is set to.
+type frequencySink struct {
+	sink      EventSink
+	frequency float64
+}
+
+func (fs *frequencySink) Element(e ElementEvent) {
+	fs.sink.Element(e)
+}
+
+func (fs *frequencySink) Character(c CharacterEvent) {
+	c.Frequency = fs.frequency
+	fs.sink.Character(c)
+}
+
+func (fs *frequencySink) Status(s StatusEvent) {
+	s.Frequency = fs.frequency
+	fs.sink.Status(s)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestEnvelopeDetectorTone(t *testing.T) {
+	for _, f := range []float64{600, 1000, 2500} {
+		ed, err := NewEnvelopeDetector(envelopeStep)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := sine(sampleRate/2, f, sampleRate, 1000)
+		var envelope []float64
+		for i := 0; i < len(in); i += fragmentSize {
+			envelope = ed.Process(envelope, in[i:minInt(i+fragmentSize, len(in))])
+		}
+		if want := (len(in) + envelopeStep - 1) / envelopeStep; len(envelope) != want {
+			t.Fatalf("Got %v envelope values, want %v", len(envelope), want)
+		}
+		for i, v := range envelope[len(envelope)/4:] {
+			if math.Abs(v-1000) > 20 {
+				t.Fatalf("%v Hz: envelope value %v is %v, want 1000", f, i, v)
+			}
+		}
+	}
+}
+
+func TestEnvelopeDetectorKeying(t *testing.T) {
+	ed, err := NewEnvelopeDetector(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	es := []Element{{10, false}, {3, true}, {10, false}}
+	envelope := ed.Process(nil, keyedTone(es, 800, 1000))
+	// Edges of the envelope are at the edges of the mark delayed by
+	// the filters and take a few ms.
+	rise := func(lo, hi float64) (int, int) {
+		a, b := -1, -1
+		for i, v := range envelope {
+			if a < 0 && v > lo {
+				a = i
+			}
+			if b < 0 && v > hi {
+				b = i
+			}
+		}
+		return a, b
+	}
+	a, b := rise(100, 900)
+	if ms := float64(b-a) * 1000 / sampleRate; ms > 5 {
+		t.Errorf("Envelope rises in %v ms", ms)
+	}
+	_, half := rise(500, 500)
+	if ms := float64(half-10*fragmentSize) * 1000 / sampleRate; ms < 0 || ms > 6 {
+		t.Errorf("Envelope is delayed by %v ms", ms)
+	}
+	for i := half + 300; i < half+3*fragmentSize-300; i++ {
+		if math.Abs(envelope[i]-1000) > 20 {
+			t.Fatalf("Envelope during the mark is %v", envelope[i])
+		}
+	}
+}
+
+type statusRecorder struct {
+	collectingSink
+	statuses []StatusEvent
+}
+
+func (sr *statusRecorder) Status(s StatusEvent) {
+	sr.statuses = append(sr.statuses, s)
+}
+
+func TestEnvelopeDecoder(t *testing.T) {
+	text := "cq cq de test test k"
+	audio := keyedTone(encodeText(text, 4), 900, 5000)
+	for i := range audio {
+		audio[i] += 300 * math.Sin(float64(i)*1.7) * math.Sin(float64(i)*0.013)
+	}
+	ed, _ := NewEnvelopeDetector(envelopeStep)
+	sink := &statusRecorder{}
+	decoder := NewEnvelopeDecoder(sink)
+	decoder.Tune(900)
+	for i := 0; i+fragmentSize <= len(audio); i += fragmentSize {
+		decoder.Add(ed.Process(nil, audio[i:i+fragmentSize]), blockStamp(i/fragmentSize))
+	}
+	decoder.Flush()
+	if got := sink.text.String(); !strings.Contains(got, "de test test k") {
+		t.Errorf("Decoded %q, want %q", got, text)
+	}
+	if len(sink.statuses) == 0 {
+		t.Fatalf("No status")
+	}
+	// Dits of 4 blocks.
+	wpm := 1.2 / blockTime(4)
+	for _, s := range sink.statuses {
+		if s.Wpm == 0 {
+			// Timing isn't known yet.
+			continue
+		}
+		if math.Abs(s.Wpm-wpm) > 2 || s.Frequency != 900 {
+			t.Errorf("Status %+v, want %.1f wpm at 900 Hz", s, wpm)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import "errors"
+
+// Errors returned by the decoding pipeline. They are wrapped with details,
+// use errors.Is to check for them.
+var (
+	// ErrInsufficientData means there are too few samples or elements to decode.
+	ErrInsufficientData = errors.New("insufficient data")
+	// ErrNoSignal means no keyed carrier can be distinguished from noise.
+	ErrNoSignal = errors.New("no signal found")
+	// ErrAmbiguousTiming means dits and dahs can't be told apart.
+	ErrAmbiguousTiming = errors.New("ambiguous timing")
+)
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Stamp locates a sample in the audio. Offset counts samples from the
+// beginning of the recording or of the capture. Time is the wall clock
+// time the sample was captured at, it is zero for recordings.
+type Stamp struct {
+	Offset int64
+	Time   time.Time
+}
+
+// blockStamp is the stamp of the first sample of a block of a recording.
+func blockStamp(block int) Stamp {
+	return Stamp{Offset: int64(block) * fragmentSize}
+}
+
+// after returns the stamp of the sample n samples later.
+func (s Stamp) after(n int) Stamp {
+	if !s.Time.IsZero() {
+		s.Time = s.Time.Add(time.Duration(math.Round(float64(n) * float64(time.Second) / sampleRate)))
+	}
+	s.Offset += int64(n)
+	return s
+}
+
+// before returns the stamp of the sample n samples earlier, stamps
+// don't go before the first sample.
+func (s Stamp) before(n int) Stamp {
+	if int64(n) > s.Offset {
+		n = int(s.Offset)
+	}
+	return s.after(-n)
+}
+
+func (s Stamp) seconds() float64 {
+	return float64(s.Offset) / sampleRate
+}
+
+func (s Stamp) wallTime() *time.Time {
+	if s.Time.IsZero() {
+		return nil
+	}
+	t := s.Time
+	return &t
+}
+
+// ElementEvent is a keyed or silent interval of the signal.
+// Start and Duration are in seconds from the beginning of the signal,
+// samples and wall clock times locate the interval exactly. They are
+// interpolated between blocks.
+type ElementEvent struct {
+	Type        string     `json:"type"`
+	Start       float64    `json:"start"`
+	Duration    float64    `json:"duration"`
+	StartSample int64      `json:"start_sample"`
+	EndSample   int64      `json:"end_sample"`
+	StartTime   *time.Time `json:"start_time,omitempty"`
+	EndTime     *time.Time `json:"end_time,omitempty"`
+	On          bool       `json:"on"`
+	Class       string     `json:"class"`
+	Frequency   float64    `json:"frequency"`
+}
+
+// CharacterEvent is a decoded letter or a word space.
+type CharacterEvent struct {
+	Type        string     `json:"type"`
+	Start       float64    `json:"start"`
+	End         float64    `json:"end"`
+	StartSample int64      `json:"start_sample"`
+	EndSample   int64      `json:"end_sample"`
+	StartTime   *time.Time `json:"start_time,omitempty"`
+	EndTime     *time.Time `json:"end_time,omitempty"`
+	Text        string     `json:"text"`
+	Confidence  float64    `json:"confidence"`
+	Frequency   float64    `json:"frequency"`
+}
+
+// StatusEvent describes the decoded signal at the given time.
+type StatusEvent struct {
+	Type      string  `json:"type"`
+	Time      float64 `json:"time"`
+	Wpm       float64 `json:"wpm"`
+	Snr       float64 `json:"snr"`
+	Frequency float64 `json:"frequency"`
+}
+
+// SpectrumEvent is a row of the waterfall. Frequency is the frequency
+// of the first magnitude and Step is the distance between magnitudes in Hz.
+type SpectrumEvent struct {
+	Type       string    `json:"type"`
+	Time       float64   `json:"time"`
+	Frequency  float64   `json:"frequency"`
+	Step       float64   `json:"step"`
+	Magnitudes []float64 `json:"magnitudes"`
+}
+
+func newElementEvent(e Element, start, end Stamp, class ElementClass, frequency int) ElementEvent {
+	return ElementEvent{
+		Type:        "element",
+		Start:       start.seconds(),
+		Duration:    end.seconds() - start.seconds(),
+		StartSample: start.Offset,
+		EndSample:   end.Offset,
+		StartTime:   start.wallTime(),
+		EndTime:     end.wallTime(),
+		On:          e.s,
+		Class:       class.String(),
+		Frequency:   binFrequency(frequency),
+	}
+}
+
+func newCharacterEvent(c Character, start, end Stamp, frequency int) CharacterEvent {
+	return CharacterEvent{
+		Type:        "character",
+		Start:       start.seconds(),
+		End:         end.seconds(),
+		StartSample: start.Offset,
+		EndSample:   end.Offset,
+		StartTime:   start.wallTime(),
+		EndTime:     end.wallTime(),
+		Text:        c.text,
+		Confidence:  c.confidence,
+		Frequency:   binFrequency(frequency),
+	}
+}
+
+func newStatusEvent(at Stamp, timing Timing, d *EMSingleFrequencyDetector, frequency int) StatusEvent {
+	return StatusEvent{"status", at.seconds(), timing.wpm(), d.snr(), binFrequency(frequency)}
+}
+
+// EventSink receives results of decoding.
+type EventSink interface {
+	Element(e ElementEvent)
+	Character(c CharacterEvent)
+	Status(s StatusEvent)
+}
+
+// SpectrumSink is implemented by sinks that display raw spectra.
+type SpectrumSink interface {
+	Spectrum(s SpectrumEvent)
+}
+
+func newSpectrumEvent(at Stamp, spectrum []float64) SpectrumEvent {
+	magnitudes := make([]float64, upperMeaningfulHarmonic-lowerMeaningfulHarmonic)
+	for j := range magnitudes {
+		magnitudes[j] = math.Round(spectrum[lowerMeaningfulHarmonic+j])
+	}
+	return SpectrumEvent{"spectrum", at.seconds(), binFrequency(lowerMeaningfulHarmonic), binFrequency(1), magnitudes}
+}
+
+// newChannelSpectrumEvent is a row of magnitudes of channels, the first
+// one at frequency Hz.
+func newChannelSpectrumEvent(at Stamp, magnitudes []float64, frequency, spacing float64) SpectrumEvent {
+	rounded := make([]float64, len(magnitudes))
+	for j, m := range magnitudes {
+		rounded[j] = math.Round(m)
+	}
+	return SpectrumEvent{"spectrum", at.seconds(), frequency, spacing, rounded}
+}
+
+// MultiSink sends every event to all its sinks.
+type MultiSink []EventSink
+
+func (ms MultiSink) Element(e ElementEvent) {
+	for _, s := range ms {
+		s.Element(e)
+	}
+}
+
+func (ms MultiSink) Character(c CharacterEvent) {
+	for _, s := range ms {
+		s.Character(c)
+	}
+}
+
+func (ms MultiSink) Status(st StatusEvent) {
+	for _, s := range ms {
+		s.Status(st)
+	}
+}
+
+func (ms MultiSink) Spectrum(sp SpectrumEvent) {
+	for _, s := range ms {
+		if ss, ok := s.(SpectrumSink); ok {
+			ss.Spectrum(sp)
+		}
+	}
+}
+
+func newEventSink(format string, w io.Writer) (EventSink, error) {
+	switch format {
+	case "text":
+		return &TextSink{w}, nil
+	case "jsonl":
+		return NewJsonlSink(w), nil
+	}
+	return nil, fmt.Errorf("Unknown output format: %v", format)
+}
+
+// TextSink prints decoded text as it arrives.
+type TextSink struct {
+	w io.Writer
+}
+
+func (ts *TextSink) Element(e ElementEvent) {
+}
+
+func (ts *TextSink) Character(c CharacterEvent) {
+	fmt.Fprint(ts.w, c.Text)
+}
+
+func (ts *TextSink) Status(s StatusEvent) {
+}
+
+// JsonlSink writes every event as a json object on a separate line.
+type JsonlSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewJsonlSink(w io.Writer) *JsonlSink {
+	return &JsonlSink{enc: json.NewEncoder(w)}
+}
+
+func (js *JsonlSink) write(v interface{}) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if err := js.enc.Encode(v); err != nil {
+		log.Warnf("Failed to write event: %v", err)
+	}
+}
+
+func (js *JsonlSink) Element(e ElementEvent) {
+	js.write(e)
+}
+
+func (js *JsonlSink) Character(c CharacterEvent) {
+	js.write(c)
+}
+
+func (js *JsonlSink) Status(s StatusEvent) {
+	js.write(s)
+}
+
+// emitDetection sends results of decoding a whole recording to the sink.
+func emitDetection(sink EventSink, d *Detection) error {
+	es := measureIntervals(d.values)
+	ditMean, dahMean, err := classifySignals(es)
+	if err != nil {
+		return err
+	}
+	chars, err := decodeCharacters(es)
+	if err != nil {
+		return err
+	}
+	timing := Timing{ditMean, dahMean}
+	sink.Status(newStatusEvent(blockStamp(0), timing, d.detector, d.frequency))
+	pos := 0
+	for _, e := range es {
+		sink.Element(newElementEvent(e, d.boundary(pos), d.boundary(pos+e.d), timing.classify(e), d.frequency))
+		pos += e.d
+	}
+	for _, c := range chars {
+		sink.Character(newCharacterEvent(c, d.boundary(c.start), d.boundary(c.end), d.frequency))
+	}
+	return nil
+}
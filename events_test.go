@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestJsonlSinkStatusOfCleanTone(t *testing.T) {
+	for _, m := range [][]float64{{1000, 0}, {0, 0}} {
+		d := &EMSingleFrequencyDetector{m: m, sigma: []float64{1, 1}, pi: []float64{0.5, 0.5}}
+		if snr := d.snr(); math.IsInf(snr, 0) || math.IsNaN(snr) || snr > maxSNR {
+			t.Errorf("SNR of means %v is %v", m, snr)
+		}
+		var buf bytes.Buffer
+		NewJsonlSink(&buf).Status(newStatusEvent(blockStamp(10), Timing{5, 15}, d, 15))
+		var s StatusEvent
+		if err := json.Unmarshal(buf.Bytes(), &s); err != nil {
+			t.Fatalf("Means %v: %q isn't a status: %v", m, buf.String(), err)
+		}
+		if s.Type != "status" || s.Snr != d.snr() {
+			t.Errorf("Means %v: decoded %+v", m, s)
+		}
+	}
+}
+
+func TestStampBefore(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := Stamp{0, start}.after(fragmentSize)
+	if b := s.before(fragmentSize / 2); b != s.after(-fragmentSize/2) {
+		t.Errorf("Half a block before %v is %v", s, b)
+	}
+	// Priming blocks of filters are at the first sample.
+	if b := s.before(3 * fragmentSize); b.Offset != 0 || !b.Time.Equal(start) {
+		t.Errorf("Three blocks before %v is %v", s, b)
+	}
+}
@@ -16,6 +16,9 @@ type FileViewer struct {
 	selection     *Selection
 	signalWindow  *SignalWindow
 	spectraWindow *HeatMap
+
+	annotations     *AnnotationLayer
+	annotationsName string
 }
 
 type WindowSize struct {
@@ -48,6 +51,7 @@ func (this *FileViewer) handleEvent(event sdl.Event) {
 			this.windowSize.Height = e.Data2
 			this.signalWindow.area.w = this.windowSize.Width
 			this.signalWindow.area.h = this.windowSize.Height / 2
+			this.annotations.area = this.signalWindow.area
 			this.spectraWindow.area.y = this.windowSize.Height / 2
 			this.spectraWindow.area.w = this.windowSize.Width
 			this.spectraWindow.area.h = this.windowSize.Height / 2
@@ -94,17 +98,47 @@ func (this *FileViewer) handleEvent(event sdl.Event) {
 				this.signalWindow.norm = this.signalWindow.Max()
 			}
 		}
+	case *sdl.KeyboardEvent:
+		if e.Type == sdl.KEYDOWN && e.Keysym.Sym == sdl.K_s {
+			this.saveSelection()
+		}
 	}
 }
 
-func viewFile(audioFile string) *FileViewer {
+// saveSelection adds selected blocks to the annotations and saves them.
+// Labels and text are edited in the saved file.
+func (this *FileViewer) saveSelection() {
+	anns := append(this.annotations.anns, selectionAnnotations(this.selection.selectedBlocks, "selection")...)
+	if err := saveAnnotations(this.annotationsName, anns); err != nil {
+		log.Errorf("Failed to save annotations: %v", err)
+		return
+	}
+	this.annotations.anns = anns
+	for i := range this.selection.selectedBlocks {
+		this.selection.selectedBlocks[i] = false
+	}
+	log.Infof("Annotations saved to %v", this.annotationsName)
+}
 
-	_, res, _, _, spectra, _ := processFile(
-		audioFile,
-		nil,
-		nil,
-	)
+// viewFile opens the file positioned at the sample at, e.g. the
+// start_sample of a decoded character. Annotations are read from
+// annotationsName or the sidecar of the file and saved back there.
+func viewFile(audioFile string, at int, annotationsName string) (*FileViewer, error) {
+	anns, err := detectAnnotations(audioFile, annotationsName)
+	if err != nil {
+		return nil, err
+	}
+	if annotationsName == "" {
+		annotationsName = sidecarName(audioFile)
+	}
+
+	// Recordings without a carrier are viewed too, nothing is detected.
+	_, res, spectra, err := readSpectra(audioFile, nil, ReaderOptions{})
+	if err != nil {
+		return nil, err
+	}
 	view := NewView()
+	view.JumpTo(at)
 	selection := NewSelection(view, AreaRect{0, 0, 0, 0}, len(res))
 	signalWindow := NewSignalWindow(res, view)
 	spectraWindow := &HeatMap{spectra, AreaRect{0, 0, 0, 0}, view}
@@ -126,8 +160,10 @@ func viewFile(audioFile string) *FileViewer {
 		view,
 		selection,
 		signalWindow,
-		spectraWindow}
-	return fileViewer
+		spectraWindow,
+		&AnnotationLayer{anns, AreaRect{0, 0, 0, 0}, view},
+		annotationsName}
+	return fileViewer, nil
 }
 
 func (this *FileViewer) Render() {
@@ -137,6 +173,7 @@ func (this *FileViewer) Render() {
 	this.selection.Draw(this.renderer)
 	this.signalWindow.Draw(this.renderer)
 	this.spectraWindow.Draw(this.renderer)
+	this.annotations.Draw(this.renderer)
 
 	this.renderer.Present()
 }
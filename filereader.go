@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/mjibson/go-dsp/fft"
+)
+
+const pcmReaderBufferSize = 64 * 1024
+
+// PCMReader reads raw S16_LE mono samples in bulk.
+type PCMReader struct {
+	r       *bufio.Reader
+	raw     []byte
+	samples []int16
+}
+
+func NewPCMReader(r io.Reader) *PCMReader {
+	return &PCMReader{r: bufio.NewReaderSize(r, pcmReaderBufferSize)}
+}
+
+// Read fills buf with the next samples. It returns io.EOF if no samples
+// are left and io.ErrUnexpectedEOF if buf was filled only partially.
+func (pr *PCMReader) Read(buf []float64) (int, error) {
+	if cap(pr.samples) < len(buf) {
+		pr.raw = make([]byte, 2*len(buf))
+		pr.samples = make([]int16, len(buf))
+	}
+	n, err := io.ReadFull(pr.r, pr.raw[:2*len(buf)])
+	m := decodePCM(pr.samples[:len(buf)], pr.raw[:n])
+	for i := 0; i < m; i++ {
+		buf[i] = float64(pr.samples[i])
+	}
+	if err == io.ErrUnexpectedEOF && m == 0 {
+		err = io.EOF
+	}
+	return m, err
+}
+
+// SpectrumReader splits a recording into blocks and calculates
+// the spectrum of every block the same way the stream pipeline does.
+type SpectrumReader struct {
+	pcm    *PCMReader
+	filter BlockFilter
+	stages []BlockFilter // Following the band-pass.
+}
+
+// ReaderOptions are the band-pass of recordings and optional stages
+// following it, they are the same as in the stream pipeline.
+type ReaderOptions struct {
+	BandFilter     string // One of bandFilterKinds, fir if empty.
+	Notch          bool   // Notch carriers that aren't keyed out.
+	NoiseReduction string // One of noiseReducers, empty disables it.
+}
+
+func NewSpectrumReader(r io.Reader) *SpectrumReader {
+	return &SpectrumReader{
+		pcm:    NewPCMReader(r),
+		filter: NewBpFilter(200, 7.0/fragmentSize, 30.0/fragmentSize, fragmentSize),
+	}
+}
+
+func (sr *SpectrumReader) configure(opts ReaderOptions) error {
+	kind := opts.BandFilter
+	if kind == "" {
+		kind = "fir"
+	}
+	filter, err := newBandFilter(kind)
+	if err != nil {
+		return err
+	}
+	sr.filter = filter
+	sr.stages = nil
+	if opts.Notch {
+		sr.stages = append(sr.stages, NewNotcher())
+	}
+	reducer, err := newNoiseReducer(opts.NoiseReduction)
+	if err != nil {
+		return err
+	}
+	if reducer != nil {
+		sr.stages = append(sr.stages, reducer)
+	}
+	return nil
+}
+
+// Next returns samples of the next block, the same samples band-pass
+// filtered and through the optional stages and their spectrum. It returns io.EOF when
+// no complete block is left, an incomplete block at the end is discarded.
+func (sr *SpectrumReader) Next() (sig []float64, res []float64, spectrum []float64, err error) {
+	sig = make([]float64, fragmentSize)
+	if _, err := sr.pcm.Read(sig); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return nil, nil, nil, err
+	}
+	res = sr.filter.FilterBuf(sig)
+	for _, s := range sr.stages {
+		res = s.FilterBuf(res)
+	}
+	buf := make([]float64, fragmentSize)
+	copy(buf, res)
+	hann(buf)
+	spectrum = ToAbs(fft.FFTReal(buf))
+	return sig, res, spectrum, nil
+}
+
+// delay is the number of samples the optional stages delay audio by.
+func (sr *SpectrumReader) delay() int {
+	d := 0
+	for _, s := range sr.stages {
+		d += filterDelay(s)
+	}
+	return d
+}
+
+// decodeFile decodes a recording block by block with the stream decoder.
+// Results go to the sink as soon as they are decoded and memory doesn't
+// depend on the length of the recording.
+func decodeFile(name, detector string, decision DecisionOptions, opts ReaderOptions, sink EventSink) error {
+	file, err := openRecording(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return decodeReader(file, detector, decision, opts, sink)
+}
+
+func decodeReader(r io.Reader, detector string, decision DecisionOptions, opts ReaderOptions, sink EventSink) error {
+	sr := NewSpectrumReader(r)
+	if err := sr.configure(opts); err != nil {
+		return err
+	}
+	decoder := NewStreamDecoder(sink)
+	if err := decoder.setKeyingDetector(detector); err != nil {
+		return err
+	}
+	if err := decoder.setDecision(decision); err != nil {
+		return err
+	}
+	delay := sr.delay()
+	for block := 0; ; block++ {
+		_, _, spectrum, err := sr.Next()
+		if err == io.EOF {
+			decoder.Flush()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		decoder.Add(spectrum[0:222], blockStamp(block).before(delay))
+	}
+}
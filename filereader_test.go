@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// keyedPCM renders keyed elements as a tone in bin 15 with some noise.
+// Durations of elements are in blocks.
+func keyedPCM(es []Element, seed int64) []byte {
+	rnd := rand.New(rand.NewSource(seed))
+	var buf bytes.Buffer
+	i := 0
+	for _, e := range es {
+		for n := 0; n < e.d*fragmentSize; n, i = n+1, i+1 {
+			v := 200 * rnd.NormFloat64()
+			if e.s {
+				v += 8000 * math.Sin(2*math.Pi*15*float64(i)/fragmentSize)
+			}
+			binary.Write(&buf, binary.LittleEndian, int16(v))
+		}
+	}
+	return buf.Bytes()
+}
+
+type collectingSink struct {
+	text strings.Builder
+}
+
+func (cs *collectingSink) Element(e ElementEvent) {
+}
+
+func (cs *collectingSink) Character(c CharacterEvent) {
+	cs.text.WriteString(c.Text)
+}
+
+func (cs *collectingSink) Status(s StatusEvent) {
+}
+
+func TestPCMReader(t *testing.T) {
+	pr := NewPCMReader(bytes.NewReader([]byte{1, 0, 2, 0, 3, 0, 4, 0, 0xff, 0xff, 7}))
+	buf := make([]float64, 3)
+	n, err := pr.Read(buf)
+	if n != 3 || err != nil || buf[0] != 1 || buf[2] != 3 {
+		t.Fatalf("Unexpected first read: %v, %v, %v", n, err, buf)
+	}
+	n, err = pr.Read(buf)
+	if n != 2 || err != io.ErrUnexpectedEOF || buf[0] != 4 || buf[1] != -1 {
+		t.Fatalf("Unexpected partial read: %v, %v, %v", n, err, buf)
+	}
+	if n, err = pr.Read(buf); n != 0 || err != io.EOF {
+		t.Fatalf("Expected EOF, got %v, %v", n, err)
+	}
+}
+
+func TestSpectrumReaderMatchesPipeline(t *testing.T) {
+	pcm := keyedPCM(encodeText("test", 6), 1)
+	samples := make(chan *SampleBlock, 1)
+	go func() {
+		defer close(samples)
+		b := newSampleBlock(len(pcm) / 2)
+		decodePCM(b.Samples, pcm)
+		samples <- b
+	}()
+	ctx := context.Background()
+	sr := NewSpectrumReader(bytes.NewReader(pcm))
+	blocks := 0
+	for expected := range produceSpectra(ctx, filterSignal(ctx, samples)) {
+		_, _, spectrum, err := sr.Next()
+		if err != nil {
+			t.Fatalf("Block %v: %v", blocks, err)
+		}
+		if expected.Stamp != blockStamp(blocks) {
+			t.Errorf("Block %v has stamp %v", blocks, expected.Stamp)
+		}
+		for j, v := range expected.Magnitudes {
+			if math.Abs(spectrum[j]-v) > 1e-6*(1+v) {
+				t.Fatalf("Block %v bin %v: %v, expected %v", blocks, j, spectrum[j], v)
+			}
+		}
+		blocks++
+	}
+	if _, _, _, err := sr.Next(); err != io.EOF {
+		t.Errorf("Expected EOF after %v blocks, got %v", blocks, err)
+	}
+}
+
+func TestDecodeReader(t *testing.T) {
+	text := strings.Repeat("paris ", 6)
+	sink := &collectingSink{}
+	if err := decodeReader(bytes.NewReader(keyedPCM(encodeText(text, 6), 1)), "threshold", DecisionOptions{}, ReaderOptions{}, sink); err != nil {
+		t.Fatal(err)
+	}
+	// The first characters are lost until the carrier and timing are found.
+	if !strings.HasSuffix(sink.text.String(), "paris paris paris paris ") {
+		t.Errorf("Unexpected text: %q", sink.text.String())
+	}
+}
+
+func TestDecodeReaderBandFilters(t *testing.T) {
+	text := strings.Repeat("paris ", 6)
+	pcm := keyedPCM(encodeText(text, 6), 1)
+	for _, kind := range bandFilterKinds {
+		sink := &collectingSink{}
+		if err := decodeReader(bytes.NewReader(pcm), "threshold", DecisionOptions{}, ReaderOptions{BandFilter: kind}, sink); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(sink.text.String(), "paris paris paris ") {
+			t.Errorf("%v: unexpected text: %q", kind, sink.text.String())
+		}
+	}
+	if err := decodeReader(bytes.NewReader(pcm), "threshold", DecisionOptions{}, ReaderOptions{BandFilter: "bessel"}, &collectingSink{}); err == nil {
+		t.Errorf("Unknown band filter is accepted")
+	}
+}
+
+// hourFile writes an hour of keyed tone to a temporary file.
+func hourFile(b *testing.B) string {
+	b.Helper()
+	pcm := keyedPCM(encodeText("cq cq de test k", 6), 1)
+	name := filepath.Join(b.TempDir(), "hour.raw")
+	f, err := os.Create(name)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	for written := 0; written < 2*3600*sampleRate; written += len(pcm) {
+		if _, err := f.Write(pcm); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return name
+}
+
+func BenchmarkReadHourFilePerSample(b *testing.B) {
+	name := hourFile(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f, err := os.Open(name)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var v int16
+		for binary.Read(f, binary.LittleEndian, &v) == nil {
+		}
+		f.Close()
+	}
+}
+
+func BenchmarkReadHourFile(b *testing.B) {
+	name := hourFile(b)
+	buf := make([]float64, fragmentSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f, err := os.Open(name)
+		if err != nil {
+			b.Fatal(err)
+		}
+		pr := NewPCMReader(f)
+		for {
+			if _, err := pr.Read(buf); err != nil {
+				break
+			}
+		}
+		f.Close()
+	}
+}
+
+// BenchmarkDecodeHourFile decodes an hour long recording incrementally
+// and reports the heap used, which must not depend on the length.
+func BenchmarkDecodeHourFile(b *testing.B) {
+	name := hourFile(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := decodeFile(name, "threshold", DecisionOptions{}, ReaderOptions{}, &TextSink{io.Discard}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	b.ReportMetric(float64(ms.HeapSys)/(1<<20), "heap-MiB")
+}
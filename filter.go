@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+
 	"github.com/mjibson/go-dsp/dsputils"
 	"github.com/mjibson/go-dsp/fft"
 )
@@ -11,6 +13,54 @@ func init() {
 	filter = dsputils.ZeroPad([]complex128{5}, 5)
 }
 
+// BlockFilter filters audio block after block. Filter is linear phase,
+// IIRFilter has less latency and needs less CPU.
+type BlockFilter interface {
+	FilterBuf(buf []float64) []float64
+}
+
+// delayedFilter is a BlockFilter whose output lags its input by Delay
+// samples. Stamps of the filtered audio are moved back by it.
+type delayedFilter interface {
+	BlockFilter
+	Delay() int
+}
+
+// filterDelay is the delay of filter in samples, 0 if it doesn't report
+// one.
+func filterDelay(filter BlockFilter) int {
+	if d, ok := filter.(delayedFilter); ok {
+		return d.Delay()
+	}
+	return 0
+}
+
+// Band of the signal the decoder looks at, as fractions of the audio rate.
+const (
+	bandLow  = 7.0 / fragmentSize
+	bandHigh = 30.0 / fragmentSize
+)
+
+var bandFilterKinds = []string{"fir", "butterworth", "chebyshev", "resonator"}
+
+// newBandFilter returns the band-pass in front of the decoder of
+// one of bandFilterKinds.
+func newBandFilter(kind string) (BlockFilter, error) {
+	switch kind {
+	case "fir":
+		return NewBpFilter(200, bandLow, bandHigh, fragmentSize), nil
+	case "butterworth":
+		return NewButterworthBp(4, bandLow, bandHigh)
+	case "chebyshev":
+		return NewChebyshevBp(4, 0.5, bandLow, bandHigh)
+	case "resonator":
+		// Peak in the middle of the band, the band is its bandwidth.
+		f0 := (bandLow + bandHigh) / 2
+		return NewResonator(f0, f0/(bandHigh-bandLow))
+	}
+	return nil, fmt.Errorf("Unknown band filter %v, want one of %v", kind, bandFilterKinds)
+}
+
 type Filter struct {
 	kernel    []float64
 	fft       []complex128
@@ -19,17 +69,19 @@ type Filter struct {
 }
 
 func NewHpFilter(m int, fc float64, blockSize int) *Filter {
-	kernel := dsputils.ZeroPadF(windowSincKernelHp(m, fc), m+blockSize)
-	return &Filter{kernel, fft.FFTReal(kernel), blockSize, []float64{}}
+	return newFirFilter(windowSincKernelHp(m, fc), blockSize)
 }
 
 func NewLpFilter(m int, fc float64, blockSize int) *Filter {
-	kernel := dsputils.ZeroPadF(windowSincKernelLp(m, fc), m+blockSize)
-	return &Filter{kernel, fft.FFTReal(kernel), blockSize, []float64{}}
+	return newFirFilter(windowSincKernelLp(m, fc), blockSize)
 }
 
 func NewBpFilter(m int, fcL float64, fcH float64, blockSize int) *Filter {
-	kernel := dsputils.ZeroPadF(windowSincKernelBp(m, fcL, fcH), m+blockSize)
+	return newFirFilter(windowSincKernelBp(m, fcL, fcH), blockSize)
+}
+
+func newFirFilter(h []float64, blockSize int) *Filter {
+	kernel := dsputils.ZeroPadF(h, len(h)-1+blockSize)
 	return &Filter{kernel, fft.FFTReal(kernel), blockSize, []float64{}}
 }
 
@@ -42,7 +94,8 @@ func (f *Filter) Convolve(signal []float64) []float64 {
 		r[i] = f.fft[i] * fft_y[i]
 	}
 
-	return ToReal(fft.IFFT(r))
+	// Convolution of real signals is real, imaginary parts are rounding errors.
+	return realParts(fft.IFFT(r))
 }
 
 func (f *Filter) FilterBuf(buf []float64) []float64 {
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"testing"
+)
+
+// response calculates magnitude of the frequency response of the kernel
+// at frequency f given as a fraction of the sampling rate.
+func response(h []float64, f float64) float64 {
+	var r complex128
+	for n := 0; n < len(h); n++ {
+		r += complex(h[n], 0) * cmplx.Exp(complex(0, -2*math.Pi*f*float64(n)))
+	}
+	return cmplx.Abs(r)
+}
+
+func TestWindowSincKernelLp(t *testing.T) {
+	h := windowSincKernelLp(200, 0.1)
+	if len(h) != 201 {
+		t.Fatalf("Kernel length: %v", len(h))
+	}
+	for _, c := range []struct {
+		f, lo, hi float64
+	}{
+		{0, 0.999, 1.001},
+		{0.05, 0.99, 1.01},
+		{0.15, 0, 0.001},
+		{0.3, 0, 0.001},
+		{0.5, 0, 0.001},
+	} {
+		if r := response(h, c.f); r < c.lo || r > c.hi {
+			t.Errorf("Response at %v: %v, expected [%v, %v]", c.f, r, c.lo, c.hi)
+		}
+	}
+}
+
+func TestWindowSincKernelHp(t *testing.T) {
+	h := windowSincKernelHp(200, 0.1)
+	for _, c := range []struct {
+		f, lo, hi float64
+	}{
+		{0, 0, 0.001},
+		{0.05, 0, 0.001},
+		{0.15, 0.99, 1.01},
+		{0.5, 0.99, 1.01},
+	} {
+		if r := response(h, c.f); r < c.lo || r > c.hi {
+			t.Errorf("Response at %v: %v, expected [%v, %v]", c.f, r, c.lo, c.hi)
+		}
+	}
+}
+
+func TestWindowSincKernelBp(t *testing.T) {
+	h := windowSincKernelBp(200, 7.0/fragmentSize, 30.0/fragmentSize)
+	for _, c := range []struct {
+		f, lo, hi float64
+	}{
+		{0, 0, 0.001},
+		{2.0 / fragmentSize, 0, 0.02},
+		{18.0 / fragmentSize, 0.99, 1.01},
+		{40.0 / fragmentSize, 0, 0.01},
+		{0.3, 0, 0.001},
+	} {
+		if r := response(h, c.f); r < c.lo || r > c.hi {
+			t.Errorf("Response at %v: %v, expected [%v, %v]", c.f, r, c.lo, c.hi)
+		}
+	}
+}
+
+func convolve(x, h []float64) []float64 {
+	y := make([]float64, len(x))
+	for n := 0; n < len(x); n++ {
+		for k := 0; k < len(h) && k <= n; k++ {
+			y[n] += h[k] * x[n-k]
+		}
+	}
+	return y
+}
+
+func TestFilterBufMatchesConvolution(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	x := make([]float64, 10*fragmentSize)
+	for i := 0; i < len(x); i++ {
+		x[i] = r.Float64()*2 - 1
+	}
+	filter := NewBpFilter(200, 7.0/fragmentSize, 30.0/fragmentSize, fragmentSize)
+	y := make([]float64, 0, len(x))
+	for i := 0; i < len(x); i += fragmentSize {
+		buf := make([]float64, fragmentSize)
+		copy(buf, x[i:i+fragmentSize])
+		y = append(y, filter.FilterBuf(buf)...)
+	}
+	expected := convolve(x, windowSincKernelBp(200, 7.0/fragmentSize, 30.0/fragmentSize))
+	for i := 0; i < len(x); i++ {
+		if math.Abs(y[i]-expected[i]) > 1e-9 {
+			t.Fatalf("Sample %v: %v, expected %v", i, y[i], expected[i])
+		}
+	}
+}
+
+func TestWindowSincKernelLengths(t *testing.T) {
+	for _, m := range []int{50, 101, 300} {
+		h := windowSincKernelLp(m, 0.1)
+		for i, v := range h {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				t.Fatalf("m %v: tap %v is %v", m, i, v)
+			}
+		}
+		if r := response(h, 0); math.Abs(r-1) > 0.001 {
+			t.Errorf("m %v: response at 0 is %v", m, r)
+		}
+		if r := response(h, 0.3); r > 0.001 {
+			t.Errorf("m %v: response at 0.3 is %v", m, r)
+		}
+		if r := response(windowSincKernelBp(m, 0.05, 0.2), 0.12); math.Abs(r-1) > 0.01 {
+			t.Errorf("m %v: band-pass response at 0.12 is %v", m, r)
+		}
+	}
+}
+
+func TestFilterLengths(t *testing.T) {
+	x := make([]float64, 256)
+	x[0] = 1
+	filter := NewLpFilter(101, 0.1, len(x))
+	y := filter.FilterBuf(x)
+	y = append(y, filter.FilterBuf(make([]float64, len(x)))...)
+	h := windowSincKernelLp(101, 0.1)
+	for i, v := range h {
+		if math.Abs(y[i]-v) > 1e-9 {
+			t.Fatalf("Sample %v: %v, expected %v", i, y[i], v)
+		}
+	}
+}
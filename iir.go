@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+)
+
+// Biquad is a second order section in transposed direct form II.
+type Biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             float64
+}
+
+func (q *Biquad) process(x float64) float64 {
+	y := q.b0*x + q.z1
+	q.z1 = q.b1*x - q.a1*y + q.z2
+	q.z2 = q.b2*x - q.a2*y
+	return y
+}
+
+// response is the frequency response at f given as a fraction of the
+// sampling rate.
+func (q *Biquad) response(f float64) complex128 {
+	z := cmplx.Rect(1, -2*math.Pi*f)
+	return (complex(q.b0, 0) + complex(q.b1, 0)*z + complex(q.b2, 0)*z*z) /
+		(1 + complex(q.a1, 0)*z + complex(q.a2, 0)*z*z)
+}
+
+// bilinear maps the analog section (b2 s² + b1 s + b0) / (a2 s² + a1 s + a0)
+// to a biquad. Frequencies of the analog section must be prewarped.
+func bilinear(b, a [3]float64) Biquad {
+	n0, n1, n2 := b[2]+b[1]+b[0], 2*(b[0]-b[2]), b[2]-b[1]+b[0]
+	d0, d1, d2 := a[2]+a[1]+a[0], 2*(a[0]-a[2]), a[2]-a[1]+a[0]
+	return Biquad{b0: n0 / d0, b1: n1 / d0, b2: n2 / d0, a1: d1 / d0, a2: d2 / d0}
+}
+
+// IIRFilter is a cascade of biquads. It has the FilterBuf interface of
+// Filter, but blocks may be of any size and there is no block latency.
+type IIRFilter struct {
+	sections []Biquad
+	gain     float64
+}
+
+func (f *IIRFilter) FilterBuf(buf []float64) []float64 {
+	res := make([]float64, len(buf))
+	for i, x := range buf {
+		y := x * f.gain
+		for j := range f.sections {
+			y = f.sections[j].process(y)
+		}
+		res[i] = y
+	}
+	return res
+}
+
+// response is the magnitude of the frequency response at f given as
+// a fraction of the sampling rate.
+func (f *IIRFilter) response(freq float64) float64 {
+	r := complex(f.gain, 0)
+	for i := range f.sections {
+		r *= f.sections[i].response(freq)
+	}
+	return cmplx.Abs(r)
+}
+
+// analogPoles returns the poles with positive imaginary parts of
+// the normalized low-pass prototype of even order. Butterworth poles
+// are on the unit circle, ripple in dB moves them to an ellipse.
+func analogPoles(order int, ripple float64) []complex128 {
+	var v float64
+	if ripple > 0 {
+		eps := math.Sqrt(math.Pow(10, ripple/10) - 1)
+		v = math.Asinh(1/eps) / float64(order)
+	}
+	poles := make([]complex128, order/2)
+	for k := range poles {
+		theta := math.Pi * float64(2*k+1) / float64(2*order)
+		if ripple > 0 {
+			poles[k] = complex(-math.Sinh(v)*math.Sin(theta), math.Cosh(v)*math.Cos(theta))
+		} else {
+			poles[k] = complex(-math.Sin(theta), math.Cos(theta))
+		}
+	}
+	return poles
+}
+
+func checkOrder(order int) error {
+	if order < 2 || order > 16 || order%2 != 0 {
+		return fmt.Errorf("Filter order %v isn't even between 2 and 16", order)
+	}
+	return nil
+}
+
+func checkCutoff(fc ...float64) error {
+	for _, f := range fc {
+		if f <= 0 || f >= 0.5 {
+			return fmt.Errorf("Cutoff %v isn't between 0 and the Nyquist frequency", f)
+		}
+	}
+	return nil
+}
+
+// iirLp transforms the prototype into a low-pass with cutoff fc given
+// as a fraction of the sampling rate.
+func iirLp(poles []complex128, fc float64) []Biquad {
+	w := math.Tan(math.Pi * fc)
+	sections := make([]Biquad, len(poles))
+	for i, p := range poles {
+		m := real(p)*real(p) + imag(p)*imag(p)
+		sections[i] = bilinear([3]float64{m * w * w, 0, 0}, [3]float64{m * w * w, -2 * real(p) * w, 1})
+	}
+	return sections
+}
+
+// iirHp transforms the prototype into a high-pass, s is replaced by w/s.
+func iirHp(poles []complex128, fc float64) []Biquad {
+	w := math.Tan(math.Pi * fc)
+	sections := make([]Biquad, len(poles))
+	for i, p := range poles {
+		m := real(p)*real(p) + imag(p)*imag(p)
+		sections[i] = bilinear([3]float64{0, 0, 1}, [3]float64{w * w / m, -2 * real(p) * w / m, 1})
+	}
+	return sections
+}
+
+// rippleGain puts the top of the Chebyshev ripple at unity gain, even
+// orders start at the bottom of the ripple.
+func rippleGain(ripple float64) float64 {
+	return math.Pow(10, -ripple/20)
+}
+
+func NewButterworthLp(order int, fc float64) (*IIRFilter, error) {
+	if err := checkOrder(order); err != nil {
+		return nil, err
+	}
+	if err := checkCutoff(fc); err != nil {
+		return nil, err
+	}
+	return &IIRFilter{iirLp(analogPoles(order, 0), fc), 1}, nil
+}
+
+// NewButterworthBp cascades a high-pass at fcL and a low-pass at fcH
+// of the order each.
+func NewButterworthBp(order int, fcL, fcH float64) (*IIRFilter, error) {
+	if err := checkOrder(order); err != nil {
+		return nil, err
+	}
+	if err := checkCutoff(fcL, fcH); err != nil {
+		return nil, err
+	}
+	poles := analogPoles(order, 0)
+	return &IIRFilter{append(iirHp(poles, fcL), iirLp(poles, fcH)...), 1}, nil
+}
+
+func NewChebyshevBp(order int, ripple, fcL, fcH float64) (*IIRFilter, error) {
+	if err := checkOrder(order); err != nil {
+		return nil, err
+	}
+	if err := checkCutoff(fcL, fcH); err != nil {
+		return nil, err
+	}
+	if ripple <= 0 {
+		return nil, fmt.Errorf("Chebyshev ripple %v dB isn't positive", ripple)
+	}
+	poles := analogPoles(order, ripple)
+	g := rippleGain(ripple)
+	return &IIRFilter{append(iirHp(poles, fcL), iirLp(poles, fcH)...), g * g}, nil
+}
+
+// NewResonator is a band-pass peak of unity gain at f0 given as
+// a fraction of the sampling rate, q is f0 divided by the bandwidth.
+func NewResonator(f0, q float64) (*IIRFilter, error) {
+	if err := checkCutoff(f0); err != nil {
+		return nil, err
+	}
+	if q <= 0 {
+		return nil, fmt.Errorf("Resonator Q %v isn't positive", q)
+	}
+	w := 2 * math.Pi * f0
+	alpha := math.Sin(w) / (2 * q)
+	a0 := 1 + alpha
+	return &IIRFilter{[]Biquad{{
+		b0: alpha / a0,
+		b2: -alpha / a0,
+		a1: -2 * math.Cos(w) / a0,
+		a2: (1 - alpha) / a0,
+	}}, 1}, nil
+}
+
+// NewNotch is a band-stop of zero gain at f0 given as a fraction of the
+// sampling rate, q is f0 divided by the bandwidth.
+func NewNotch(f0, q float64) (*IIRFilter, error) {
+	if err := checkCutoff(f0); err != nil {
+		return nil, err
+	}
+	if q <= 0 {
+		return nil, fmt.Errorf("Notch Q %v isn't positive", q)
+	}
+	w := 2 * math.Pi * f0
+	alpha := math.Sin(w) / (2 * q)
+	a0 := 1 + alpha
+	return &IIRFilter{[]Biquad{{
+		b0: 1 / a0,
+		b1: -2 * math.Cos(w) / a0,
+		b2: 1 / a0,
+		a1: -2 * math.Cos(w) / a0,
+		a2: (1 - alpha) / a0,
+	}}, 1}, nil
+}
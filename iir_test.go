@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestIIRResponse(t *testing.T) {
+	db := func(v float64) float64 { return math.Pow(10, v/20) }
+	butterworthLp, _ := NewButterworthLp(4, 0.1)
+	butterworthBp, _ := NewButterworthBp(4, bandLow, bandHigh)
+	chebyshevBp, _ := NewChebyshevBp(4, 0.5, bandLow, bandHigh)
+	resonator, _ := NewResonator(0.05, 10)
+	notch, _ := NewNotch(0.05, 10)
+	for _, c := range []struct {
+		name      string
+		filter    *IIRFilter
+		f, lo, hi float64
+	}{
+		{"butterworth lp", butterworthLp, 0, 0.999, 1.001},
+		{"butterworth lp", butterworthLp, 0.1, db(-3.02), db(-3.00)},
+		{"butterworth lp", butterworthLp, 0.2, 0, db(-24)},
+		{"butterworth bp", butterworthBp, 18.0 / fragmentSize, 0.95, 1.001},
+		{"butterworth bp", butterworthBp, 2.0 / fragmentSize, 0, 0.01},
+		{"butterworth bp", butterworthBp, 90.0 / fragmentSize, 0, 0.01},
+		{"chebyshev bp", chebyshevBp, 18.0 / fragmentSize, db(-1.001), 1.001},
+		{"chebyshev bp", chebyshevBp, 2.0 / fragmentSize, 0, 0.01},
+		{"resonator", resonator, 0.05, 0.999, 1.001},
+		{"resonator", resonator, 0.05 * (1 + 1.0/20), db(-3.2), db(-2.8)},
+		{"resonator", resonator, 0.2, 0, 0.05},
+		{"notch", notch, 0.05, 0, 1e-9},
+		{"notch", notch, 0.05 * (1 + 1.0/20), db(-3.2), db(-2.8)},
+		{"notch", notch, 0.2, 0.99, 1.001},
+	} {
+		if r := c.filter.response(c.f); r < c.lo || r > c.hi {
+			t.Errorf("%v: response at %v: %v, expected [%v, %v]", c.name, c.f, r, c.lo, c.hi)
+		}
+	}
+}
+
+func TestIIRInvalid(t *testing.T) {
+	if _, err := NewButterworthLp(3, 0.1); err == nil {
+		t.Errorf("Odd order is accepted")
+	}
+	if _, err := NewButterworthBp(4, 0.1, 0.6); err == nil {
+		t.Errorf("Cutoff above Nyquist is accepted")
+	}
+	if _, err := NewChebyshevBp(4, 0, bandLow, bandHigh); err == nil {
+		t.Errorf("Chebyshev without ripple is accepted")
+	}
+	if _, err := newBandFilter("bessel"); err == nil {
+		t.Errorf("Unknown band filter is accepted")
+	}
+}
+
+func TestIIRFilterBufBlocks(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	x := make([]float64, 3000)
+	for i := range x {
+		x[i] = r.Float64()*2 - 1
+	}
+	whole, _ := NewChebyshevBp(4, 0.5, bandLow, bandHigh)
+	expected := whole.FilterBuf(x)
+	blocks, _ := NewChebyshevBp(4, 0.5, bandLow, bandHigh)
+	var y []float64
+	for i := 0; i < len(x); i += 700 {
+		y = append(y, blocks.FilterBuf(x[i:minInt(i+700, len(x))])...)
+	}
+	for i := range x {
+		if math.Abs(y[i]-expected[i]) > 1e-12 {
+			t.Fatalf("Sample %v: %v, expected %v", i, y[i], expected[i])
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/cmplx"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const rtlTCPScheme = "rtl_tcp://"
+
+// channelTransition is the transition band in Hz of the 201 tap
+// Blackman channel filter at the rate.
+func channelTransition(rate float64) float64 {
+	return 5.5 * rate / 200
+}
+
+// IQConfig describes a source of complex baseband samples and how it
+// is turned into audio. The station at Shift Hz from the centre is
+// moved to zero, Bandwidth Hz around it are kept after decimation and
+// the BFO moves it to BFO Hz of audio.
+type IQConfig struct {
+	Source     string // File, - for stdin or rtl_tcp://host:port.
+	Format     string // u8, s16 or f32, interleaved I and Q.
+	Rate       int
+	Center     float64 // Hz, tuned by rtl_tcp.
+	Gain       float64 // dB, 0 is automatic gain of rtl_tcp.
+	Shift      float64
+	Decimation int // 0 decimates close to the audio rate.
+	Bandwidth  float64
+	BFO        float64
+}
+
+func defaultIQConfig(source string) IQConfig {
+	return IQConfig{
+		Source:    source,
+		Format:    "s16",
+		Rate:      32 * sampleRate,
+		Bandwidth: 2000,
+		BFO:       1600,
+	}
+}
+
+type iqFormat struct {
+	width  int // Bytes per complex sample.
+	decode func(b []byte) complex128
+}
+
+// Samples are scaled to the range of 16 bit audio.
+var iqFormats = map[string]iqFormat{
+	"u8": {2, func(b []byte) complex128 {
+		return complex((float64(b[0])-127.5)*256, (float64(b[1])-127.5)*256)
+	}},
+	"s16": {4, func(b []byte) complex128 {
+		return complex(
+			float64(int16(binary.LittleEndian.Uint16(b))),
+			float64(int16(binary.LittleEndian.Uint16(b[2:]))))
+	}},
+	"f32": {8, func(b []byte) complex128 {
+		return complex(
+			float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))*32767,
+			float64(math.Float32frombits(binary.LittleEndian.Uint32(b[4:])))*32767)
+	}},
+}
+
+func (cfg IQConfig) isRTLTCP() bool {
+	return strings.HasPrefix(cfg.Source, rtlTCPScheme)
+}
+
+// decimation returns the decimation of the input. The decimated rate
+// is the rate of the audio before it is resampled to the audio rate.
+func (cfg IQConfig) decimation() int {
+	if cfg.Decimation > 0 {
+		return cfg.Decimation
+	}
+	return maxInt(1, cfg.Rate/sampleRate)
+}
+
+func (cfg IQConfig) decimatedRate() float64 {
+	return float64(cfg.Rate) / float64(cfg.decimation())
+}
+
+func (cfg IQConfig) validate() error {
+	if _, ok := iqFormats[cfg.Format]; !ok {
+		return fmt.Errorf("Unknown I/Q format %v, supported formats: u8, s16, f32", cfg.Format)
+	}
+	if cfg.isRTLTCP() && cfg.Format != "u8" {
+		return fmt.Errorf("rtl_tcp sends u8 samples, not %v", cfg.Format)
+	}
+	if cfg.Rate <= 0 || cfg.Bandwidth <= 0 || cfg.BFO < 0 {
+		return fmt.Errorf("Wrong rate, bandwidth or BFO: %v, %v, %v", cfg.Rate, cfg.Bandwidth, cfg.BFO)
+	}
+	// The audio has to stay below the Nyquist frequency.
+	rate := cfg.decimatedRate()
+	if cfg.BFO+cfg.Bandwidth/2+channelTransition(rate)/2 >= rate/2 {
+		return fmt.Errorf("I/Q rate %v Hz decimated %v times is too low for BFO %v Hz and bandwidth %v Hz",
+			cfg.Rate, cfg.decimation(), cfg.BFO, cfg.Bandwidth)
+	}
+	return nil
+}
+
+// firDecimator is a low-pass FIR filter computing only every factor-th
+// output sample.
+type firDecimator struct {
+	taps   []float64
+	hist   []complex128
+	factor int
+	phase  int
+}
+
+func newFirDecimator(taps []float64, factor int) *firDecimator {
+	return &firDecimator{
+		taps:   taps,
+		hist:   make([]complex128, len(taps)-1, 4*len(taps)),
+		factor: factor,
+	}
+}
+
+func (f *firDecimator) push(x complex128) (complex128, bool) {
+	if len(f.hist) == cap(f.hist) {
+		n := copy(f.hist, f.hist[len(f.hist)-len(f.taps)+1:])
+		f.hist = f.hist[:n]
+	}
+	f.hist = append(f.hist, x)
+	f.phase++
+	if f.phase < f.factor {
+		return 0, false
+	}
+	f.phase = 0
+	var y complex128
+	h := f.hist[len(f.hist)-len(f.taps):]
+	for i, t := range f.taps {
+		y += h[i] * complex(t, 0)
+	}
+	return y, true
+}
+
+// oscillator generates e^(j2πft) sample by sample.
+type oscillator struct {
+	v, step complex128
+	n       int
+}
+
+func newOscillator(frequency float64, rate float64) *oscillator {
+	return &oscillator{v: 1, step: cmplx.Rect(1, 2*math.Pi*frequency/rate)}
+}
+
+func (o *oscillator) next() complex128 {
+	v := o.v
+	o.v *= o.step
+	// Rounding errors accumulate in the amplitude.
+	if o.n++; o.n%1024 == 0 {
+		o.v /= complex(cmplx.Abs(o.v), 0)
+	}
+	return v
+}
+
+// IQDemodulator turns complex baseband into real audio at the audio
+// rate. Audio is resampled if the decimated rate isn't the audio rate.
+type IQDemodulator struct {
+	mixer     *oscillator
+	antiAlias *firDecimator
+	channel   *firDecimator
+	bfo       *oscillator
+	resampler *Resampler
+	audio     []float64
+}
+
+func NewIQDemodulator(cfg IQConfig) (*IQDemodulator, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	d := cfg.decimation()
+	rate := cfg.decimatedRate()
+	var resampler *Resampler
+	if cfg.Rate != d*sampleRate {
+		// Ratio of the decimated rate to the audio rate in whole numbers.
+		resampler = NewResampler(cfg.Rate, d*sampleRate)
+	}
+	// The anti aliasing filter only has to protect the channel, its
+	// transition band may reach past the audio Nyquist frequency. The
+	// channel filter is flat over the bandwidth, its cutoff is in the
+	// middle of its transition band.
+	return &IQDemodulator{
+		mixer:     newOscillator(-cfg.Shift, float64(cfg.Rate)),
+		antiAlias: newFirDecimator(windowSincKernelLp(200, 0.25/float64(d)), d),
+		channel:   newFirDecimator(windowSincKernelLp(200, (cfg.Bandwidth/2+channelTransition(rate)/2)/rate), 1),
+		bfo:       newOscillator(cfg.BFO, rate),
+		resampler: resampler,
+	}, nil
+}
+
+// Process appends audio produced from src to dst.
+func (d *IQDemodulator) Process(dst []float64, src []complex128) []float64 {
+	audio := dst
+	if d.resampler != nil {
+		audio = d.audio[:0]
+	}
+	for _, x := range src {
+		y, ok := d.antiAlias.push(x * d.mixer.next())
+		if !ok {
+			continue
+		}
+		y, _ = d.channel.push(y)
+		audio = append(audio, real(y*d.bfo.next()))
+	}
+	if d.resampler == nil {
+		return audio
+	}
+	d.audio = audio
+	return d.resampler.Process(dst, audio)
+}
+
+// Commands of the rtl_tcp protocol.
+const (
+	rtlTCPSetFrequency  = 0x01
+	rtlTCPSetSampleRate = 0x02
+	rtlTCPSetGainMode   = 0x03
+	rtlTCPSetGain       = 0x04
+)
+
+func rtlTCPCommand(w io.Writer, cmd byte, param uint32) error {
+	var b [5]byte
+	b[0] = cmd
+	binary.BigEndian.PutUint32(b[1:], param)
+	_, err := w.Write(b[:])
+	return err
+}
+
+// dialRTLTCP connects to an rtl_tcp server and tunes it.
+func dialRTLTCP(ctx context.Context, cfg IQConfig) (net.Conn, error) {
+	addr := strings.TrimPrefix(cfg.Source, rtlTCPScheme)
+	dialer := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	fail := func(err error) (net.Conn, error) {
+		conn.Close()
+		return nil, fmt.Errorf("rtl_tcp server %v: %w", addr, err)
+	}
+	// The server greets with its magic, tuner type and number of gains.
+	header := make([]byte, 12)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fail(err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	if string(header[:4]) != "RTL0" {
+		return fail(fmt.Errorf("Wrong magic %q", header[:4]))
+	}
+	log.Infof("Connected to rtl_tcp %v, tuner type %v", addr, binary.BigEndian.Uint32(header[4:]))
+
+	gainMode := uint32(0)
+	if cfg.Gain != 0 {
+		gainMode = 1
+	}
+	cmds := [][2]uint32{
+		{rtlTCPSetSampleRate, uint32(cfg.Rate)},
+		{rtlTCPSetFrequency, uint32(cfg.Center)},
+		{rtlTCPSetGainMode, gainMode},
+	}
+	if gainMode == 1 {
+		cmds = append(cmds, [2]uint32{rtlTCPSetGain, uint32(math.Round(cfg.Gain * 10))})
+	}
+	for _, c := range cmds {
+		if err := rtlTCPCommand(conn, byte(c[0]), c[1]); err != nil {
+			return fail(err)
+		}
+	}
+	return conn, nil
+}
+
+// IQStream demodulates I/Q samples from a file, stdin or an rtl_tcp
+// server. It delivers audio like AudioStream does.
+type IQStream struct {
+	cfg    IQConfig
+	r      io.ReadCloser
+	live   bool
+	ch     chan *SampleBlock
+	errs   chan error
+	cancel context.CancelFunc
+	done   chan struct{}
+	once   sync.Once
+}
+
+func OpenIQStream(ctx context.Context, cfg IQConfig) (*IQStream, error) {
+	demod, err := NewIQDemodulator(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var r io.ReadCloser
+	live := true
+	switch {
+	case cfg.isRTLTCP():
+		r, err = dialRTLTCP(ctx, cfg)
+	case cfg.Source == "-":
+		r = os.Stdin
+	default:
+		r, err = os.Open(cfg.Source)
+		live = false
+	}
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	is := &IQStream{
+		cfg:    cfg,
+		r:      r,
+		live:   live,
+		ch:     make(chan *SampleBlock, audioStreamBlocks),
+		errs:   make(chan error, 1),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go is.read(ctx, demod)
+	return is, nil
+}
+
+func (is *IQStream) read(ctx context.Context, demod *IQDemodulator) {
+	defer close(is.done)
+	defer close(is.ch)
+	f := iqFormats[is.cfg.Format]
+	d := is.cfg.decimation()
+	r := bufio.NewReaderSize(is.r, 1<<16)
+	buf := make([]byte, d*fragmentSize*f.width)
+	iq := make([]complex128, d*fragmentSize)
+	var audio []float64
+	var offset int64
+	for ctx.Err() == nil {
+		n, err := io.ReadFull(r, buf)
+		now := time.Now()
+		n /= f.width
+		for i := 0; i < n; i++ {
+			iq[i] = f.decode(buf[i*f.width:])
+		}
+		audio = demod.Process(audio[:0], iq[:n])
+		if len(audio) > 0 {
+			b := newSampleBlock(len(audio))
+			for i, v := range audio {
+				b.Samples[i] = clampInt16(v)
+			}
+			b.Stamp = Stamp{Offset: offset}
+			if is.live {
+				b.Stamp.Time = now.Add(-time.Duration(len(audio)) * time.Second / sampleRate)
+			}
+			offset += int64(len(audio))
+			select {
+			case is.ch <- b:
+			case <-ctx.Done():
+				b.Release()
+				return
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return
+		} else if err != nil {
+			if ctx.Err() == nil {
+				is.errs <- fmt.Errorf("Error reading I/Q samples: %w", err)
+			}
+			return
+		}
+	}
+}
+
+func (is *IQStream) GetChan() <-chan *SampleBlock {
+	return is.ch
+}
+
+func (is *IQStream) Err() <-chan error {
+	return is.errs
+}
+
+// Rate is the rate of the demodulated audio.
+func (is *IQStream) Rate() int {
+	return sampleRate
+}
+
+// Stats are always zero, the reader waits for the pipeline.
+func (is *IQStream) Stats() AudioStats {
+	return AudioStats{}
+}
+
+// Close closes the source to interrupt a blocked read and waits for
+// the reader.
+func (is *IQStream) Close() {
+	is.once.Do(func() {
+		is.cancel()
+		is.r.Close()
+		<-is.done
+	})
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"math/cmplx"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// iqTone returns a complex tone of frequency f Hz at the given rate.
+func iqTone(n int, f float64, rate int, amplitude float64) []complex128 {
+	s := make([]complex128, n)
+	for i := range s {
+		s[i] = cmplx.Rect(amplitude, 2*math.Pi*f*float64(i)/float64(rate))
+	}
+	return s
+}
+
+// goertzel returns the amplitude of frequency f Hz in audio at the audio
+// rate. Only whole periods are taken into account.
+func goertzel(s []float64, f float64) float64 {
+	s = s[:int(math.Floor(f*float64(len(s))/sampleRate)*sampleRate/f)]
+	var re, im float64
+	for i, v := range s {
+		sin, cos := math.Sincos(2 * math.Pi * f * float64(i) / sampleRate)
+		re += v * cos
+		im -= v * sin
+	}
+	return 2 * math.Hypot(re, im) / float64(len(s))
+}
+
+func TestIQDemodulator(t *testing.T) {
+	cfg := defaultIQConfig("")
+	cfg.Rate = 4 * sampleRate
+	cfg.Shift = 10000
+	d, err := NewIQDemodulator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := cfg.Rate / 2
+	station := iqTone(n, cfg.Shift+300, cfg.Rate, 1000)
+	// A neighbour outside of the bandwidth and the image of the station,
+	// which a real mixer would put onto the same audio frequency.
+	for _, f := range []float64{cfg.Shift + 3000, cfg.Shift - 2*cfg.BFO - 300} {
+		for i, v := range iqTone(n, f, cfg.Rate, 1000) {
+			station[i] += v
+		}
+	}
+	audio := d.Process(nil, station)
+	if len(audio) != n/4 {
+		t.Fatalf("Got %v audio samples, want %v", len(audio), n/4)
+	}
+	// Skip the delay of the filters.
+	audio = audio[1000:]
+	if a := goertzel(audio, cfg.BFO+300); math.Abs(a-1000) > 20 {
+		t.Errorf("Station amplitude is %v, want 1000", a)
+	}
+	if a := goertzel(audio, cfg.BFO+3000); a > 10 {
+		t.Errorf("Neighbour amplitude is %v", a)
+	}
+}
+
+func TestIQConfigValidate(t *testing.T) {
+	cfg := defaultIQConfig("rtl_tcp://localhost:1234")
+	if err := cfg.validate(); err == nil {
+		t.Errorf("rtl_tcp accepts s16 samples")
+	}
+	cfg.Format = "u8"
+	if err := cfg.validate(); err != nil {
+		t.Errorf("Default rtl_tcp config is invalid: %v", err)
+	}
+	// Audio at other rates is resampled.
+	cfg.Rate = 2048000
+	if err := cfg.validate(); err != nil {
+		t.Errorf("Rate %v is rejected: %v", cfg.Rate, err)
+	}
+	cfg.Decimation = 512
+	if err := cfg.validate(); err == nil {
+		t.Errorf("BFO %v Hz is accepted at %v Hz", cfg.BFO, cfg.decimatedRate())
+	}
+}
+
+func encodeIQ(t *testing.T, format string, s []complex128) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, v := range s {
+		switch format {
+		case "u8":
+			buf.Write([]byte{byte(math.Round(real(v)/256 + 127.5)), byte(math.Round(imag(v)/256 + 127.5))})
+		case "s16":
+			binary.Write(&buf, binary.LittleEndian, [2]int16{int16(real(v)), int16(imag(v))})
+		case "f32":
+			binary.Write(&buf, binary.LittleEndian, [2]float32{float32(real(v) / 32767), float32(imag(v) / 32767)})
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestIQFormats(t *testing.T) {
+	s := []complex128{complex(12800, -12800), complex(-256, 512)}
+	for format, f := range iqFormats {
+		b := encodeIQ(t, format, s)
+		for i, want := range s {
+			if got := f.decode(b[i*f.width:]); cmplx.Abs(got-want) > 200 {
+				t.Errorf("%v: sample %v is %v, want %v", format, i, got, want)
+			}
+		}
+	}
+}
+
+// readIQStream returns all audio of the stream.
+func readIQStream(t *testing.T, is *IQStream) []float64 {
+	t.Helper()
+	defer is.Close()
+	var audio []float64
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case b, ok := <-is.GetChan():
+			if !ok {
+				select {
+				case err := <-is.Err():
+					t.Fatal(err)
+				default:
+				}
+				return audio
+			}
+			for _, v := range b.Samples {
+				audio = append(audio, float64(v))
+			}
+			b.Release()
+		case <-timeout:
+			t.Fatalf("I/Q stream didn't end")
+		}
+	}
+}
+
+func TestIQStreamFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "tone.iq")
+	cfg := defaultIQConfig(name)
+	cfg.Rate = 2 * sampleRate
+	cfg.Format = "f32"
+	n := cfg.Rate
+	if err := os.WriteFile(name, encodeIQ(t, cfg.Format, iqTone(n, 500, cfg.Rate, 5000)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	is, err := OpenIQStream(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audio := readIQStream(t, is)
+	if len(audio) != n/2 {
+		t.Fatalf("Got %v audio samples, want %v", len(audio), n/2)
+	}
+	if a := goertzel(audio[1000:], cfg.BFO+500); math.Abs(a-5000) > 100 {
+		t.Errorf("Tone amplitude is %v, want 5000", a)
+	}
+}
+
+func TestIQStreamRTLTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	cfg := defaultIQConfig(rtlTCPScheme + l.Addr().String())
+	cfg.Format = "u8"
+	cfg.Center = 7030000
+	cfg.Gain = 20.7
+	samples := encodeIQ(t, "u8", iqTone(cfg.Rate/4, -700, cfg.Rate, 20000))
+
+	cmds := make(chan []byte, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte{'R', 'T', 'L', '0', 0, 0, 0, 5, 0, 0, 0, 29})
+		b := make([]byte, 4*5)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return
+		}
+		cmds <- b
+		conn.Write(samples)
+	}()
+
+	is, err := OpenIQStream(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audio := readIQStream(t, is)
+	want := []byte{
+		rtlTCPSetSampleRate, 0x00, 0x15, 0x88, 0x80,
+		rtlTCPSetFrequency, 0x00, 0x6b, 0x44, 0xf0,
+		rtlTCPSetGainMode, 0, 0, 0, 1,
+		rtlTCPSetGain, 0, 0, 0, 207,
+	}
+	if got := <-cmds; !bytes.Equal(got, want) {
+		t.Errorf("Server got commands %v, want %v", got, want)
+	}
+	if len(audio) != cfg.Rate/4/32 {
+		t.Fatalf("Got %v audio samples, want %v", len(audio), cfg.Rate/4/32)
+	}
+	if a := goertzel(audio[1000:], cfg.BFO-700); math.Abs(a-20000) > 400 {
+		t.Errorf("Tone amplitude is %v, want 20000", a)
+	}
+}
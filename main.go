@@ -4,6 +4,11 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
@@ -25,6 +30,19 @@ func main() {
 	var lb, ub int64
 	var lowerClassificationBoundary, upperClassificationBoundary int64
 	var logLevel string
+	var outDir string
+	var lowerBin, upperBin int64
+	var format string
+	var outputFormat string
+	var listenAddr string
+	var telnetAddr string
+	var dial float64
+	var spotterCall string
+	var incremental bool
+	var annotationsName string
+	var labelsName string
+	captureCfg := defaultCaptureConfig("default")
+	iqCfg := defaultIQConfig("")
 
 	app := &cli.App{
 		Name:                 "cw-server",
@@ -35,8 +53,8 @@ func main() {
 			if err != nil {
 				return err
 			}
-			fmt.Printf("Setting log level: %v\n", ll)
 			log.SetLevel(ll)
+			log.Infof("Setting log level: %v", ll)
 			return nil
 		},
 		Flags: []cli.Flag{
@@ -55,14 +73,33 @@ func main() {
 				Usage:   "Record audio file",
 				Action: func(cCtx *cli.Context) error {
 					fmt.Printf("Handling file name: %s\n", fileName)
-					record(fileName, device)
-					return nil
+					src := sourceConfig(cCtx, device, captureCfg, iqCfg)
+					var meta *RecordingMetadata
+					if cCtx.Bool("metadata") {
+						meta = &RecordingMetadata{Device: device, Dial: dial, Notes: cCtx.String("notes")}
+						if src.IQ != nil {
+							meta.Device = src.IQ.Source
+						}
+					}
+					if cCtx.Bool("vox") {
+						return recordVox(fileName, src, VoxOptions{
+							PreRoll:   cCtx.Duration("pre-roll"),
+							Hang:      cCtx.Duration("hang"),
+							Threshold: cCtx.Float64("threshold"),
+						}, meta)
+					}
+					return record(fileName, src, RecordingOptions{
+						RotateEvery: cCtx.Duration("rotate-every"),
+						RotateSize:  cCtx.Int64("rotate-mb") << 20,
+						Timestamped: cCtx.Bool("timestamp"),
+						Metadata:    meta,
+					})
 				},
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name:        "file",
 						Aliases:     []string{"f"},
-						Usage:       "File for operations with files",
+						Usage:       "File to record to, .wav files get a WAV header, others are raw S16_LE",
 						Destination: &fileName,
 						Required:    true,
 					},
@@ -71,17 +108,60 @@ func main() {
 						Aliases:     []string{"d"},
 						Usage:       "Device to record from",
 						Destination: &device,
-						Required:    true,
+						Value:       "default",
 					},
-				},
+					&cli.DurationFlag{
+						Name:  "rotate-every",
+						Usage: "Start a new file after this much audio, e.g. 1h",
+					},
+					&cli.Int64Flag{
+						Name:  "rotate-mb",
+						Usage: "Start a new file after this many megabytes",
+					},
+					&cli.BoolFlag{
+						Name:  "timestamp",
+						Usage: "Add the time of the first sample to the file name, always done when rotating",
+					},
+					&cli.BoolFlag{
+						Name:  "metadata",
+						Usage: "Write a json sidecar with device, start time, sample rate, dial frequency and notes",
+					},
+					&cli.Float64Flag{
+						Name:        "dial",
+						Usage:       "Dial frequency in kHz for the metadata",
+						Destination: &dial,
+					},
+					&cli.StringFlag{
+						Name:  "notes",
+						Usage: "Notes for the metadata",
+					},
+					&cli.BoolFlag{
+						Name:  "vox",
+						Usage: "Record only while a carrier is present, into a file per segment named after the file, time and frequency",
+					},
+					&cli.DurationFlag{
+						Name:  "pre-roll",
+						Usage: "Audio recorded before the carrier appears",
+						Value: 2 * time.Second,
+					},
+					&cli.DurationFlag{
+						Name:  "hang",
+						Usage: "Audio recorded after the carrier disappears",
+						Value: 5 * time.Second,
+					},
+					&cli.Float64Flag{
+						Name:  "threshold",
+						Usage: "Carrier level above the noise floor in dB starting a segment",
+						Value: 12,
+					},
+				}, append(captureFlags(&captureCfg), iqFlags(&iqCfg)...)...),
 			},
 			{
 				Name:    "visualize",
 				Aliases: []string{"v"},
 				Action: func(cCtx *cli.Context) error {
 					fmt.Printf("Handling file name: %s\n", fileName)
-					MainLoop(fileName)
-					return nil
+					return MainLoop(fileName, int(cCtx.Int64("at")), annotationsName)
 				},
 				Flags: []cli.Flag{
 					&cli.StringFlag{
@@ -91,6 +171,15 @@ func main() {
 						Destination: &fileName,
 						Required:    true,
 					},
+					&cli.StringFlag{
+						Name:        "annotations",
+						Usage:       "Annotations to draw and save selections to, json or Audacity labels. Defaults to the sidecar of the file",
+						Destination: &annotationsName,
+					},
+					&cli.Int64Flag{
+						Name:  "at",
+						Usage: "Sample to open the file at, e.g. start_sample of a decoded character",
+					},
 				},
 			},
 			{
@@ -116,8 +205,17 @@ func main() {
 				Aliases: []string{"c"},
 				Action: func(cCtx *cli.Context) error {
 					fmt.Printf("Correlating file name: %s\n", fileName)
-					correlateFile(fileName)
-					return nil
+					out := cCtx.String("out")
+					if out == "" {
+						out = strings.TrimSuffix(fileName, filepath.Ext(fileName)) + "-correlation"
+					}
+					return correlateFile(
+						fileName,
+						&Range{lowerBin, upperBin},
+						&Range{lb, ub},
+						out,
+						format,
+					)
 				},
 				Flags: []cli.Flag{
 					&cli.StringFlag{
@@ -127,6 +225,42 @@ func main() {
 						Destination: &fileName,
 						Required:    true,
 					},
+					&cli.StringFlag{
+						Name:    "out",
+						Aliases: []string{"o"},
+						Usage:   "Output file name without extension",
+					},
+					&cli.StringFlag{
+						Name:        "format",
+						Usage:       "Matrix format: csv or npy",
+						Destination: &format,
+						Value:       "csv",
+					},
+					&cli.Int64Flag{
+						Name:        "lower_bin",
+						Aliases:     []string{"lbin"},
+						Usage:       "First spectrum bin to correlate",
+						Destination: &lowerBin,
+					},
+					&cli.Int64Flag{
+						Name:        "upper_bin",
+						Aliases:     []string{"ubin"},
+						Usage:       "Spectrum bin after the last one to correlate",
+						Destination: &upperBin,
+						Value:       defaultUpperCorrelationBin,
+					},
+					&cli.Int64Flag{
+						Name:        "lower_bound",
+						Aliases:     []string{"lb"},
+						Usage:       "First segment of the time window",
+						Destination: &lb,
+					},
+					&cli.Int64Flag{
+						Name:        "upper_bound",
+						Aliases:     []string{"ub"},
+						Usage:       "Segment after the last one of the time window",
+						Destination: &ub,
+					},
 				},
 			},
 			{
@@ -134,16 +268,170 @@ func main() {
 				Aliases: []string{"s"},
 				Usage:   "Decode audio stream",
 				Action: func(cCtx *cli.Context) error {
-					return stream(context.Background(), device)
+					sink, err := newEventSink(outputFormat, os.Stdout)
+					if err != nil {
+						return err
+					}
+					ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+					defer stop()
+					if listenAddr != "" {
+						server := NewServer()
+						sink = MultiSink{sink, server}
+						go func() {
+							if err := server.ListenAndServe(ctx, listenAddr); err != nil {
+								log.Errorf("Server failed: %v", err)
+							}
+						}()
+					}
+					var spotter *Spotter
+					if telnetAddr != "" {
+						telnet := NewTelnetServer()
+						spotter = NewSpotter(spotterCall, dial, telnet.Publish)
+						sink = MultiSink{sink, spotter}
+						go func() {
+							if err := telnet.ListenAndServe(ctx, telnetAddr); err != nil {
+								log.Errorf("Telnet server failed: %v", err)
+							}
+						}()
+					}
+					opts := StreamOptions{
+						BandFilter:     cCtx.String("band-filter"),
+						CWBandwidth:    cCtx.Float64("cw-bandwidth"),
+						ChannelSpacing: cCtx.Float64("channel-spacing"),
+						Envelope:       cCtx.Bool("envelope"),
+						Detector:       cCtx.String("detector"),
+						Decision:       decisionOptions(cCtx),
+						Notch:          cCtx.Bool("notch"),
+						NoiseReduction: cCtx.String("noise-reduction"),
+					}
+					if name := cCtx.String("monitor"); name != "" {
+						f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+						if err != nil {
+							return err
+						}
+						defer f.Close()
+						opts.Monitor = f
+					}
+					err = stream(ctx, sourceConfig(cCtx, device, captureCfg, iqCfg), opts, sink)
+					if spotter != nil {
+						spotter.Flush()
+					}
+					return err
 				},
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name:        "device",
 						Aliases:     []string{"d"},
 						Usage:       "Device to record from",
 						Destination: &device,
+						Value:       "default",
+					},
+					&cli.StringFlag{
+						Name:        "format",
+						Usage:       "Output format: text or jsonl",
+						Destination: &outputFormat,
+						Value:       "text",
+					},
+					&cli.StringFlag{
+						Name:        "listen",
+						Usage:       "Address for http and websocket server, e.g. :8080",
+						Destination: &listenAddr,
+					},
+					&cli.StringFlag{
+						Name:        "telnet",
+						Usage:       "Address for DX cluster style telnet spots, e.g. :7300",
+						Destination: &telnetAddr,
+					},
+					&cli.Float64Flag{
+						Name:        "dial",
+						Usage:       "Dial frequency in kHz added to audio frequency of spots",
+						Destination: &dial,
+					},
+					&cli.StringFlag{
+						Name:        "spotter",
+						Usage:       "Spotter callsign in telnet spots",
+						Destination: &spotterCall,
+						Value:       "SKIMMER-#",
+					},
+					&cli.Float64Flag{
+						Name:  "cw-bandwidth",
+						Usage: "Bandwidth in Hz of a narrow filter following the carrier, 50 to 500, 0 disables it",
+					},
+					&cli.StringFlag{
+						Name:  "detector",
+						Usage: "Keying detector: threshold decides on every block, matched correlates the envelope with a dit at the estimated speed for weak signals",
+						Value: "threshold",
+					},
+					&cli.BoolFlag{
+						Name:  "envelope",
+						Usage: "Decode the smoothed envelope of the CW filtered audio every 1.5 ms instead of block magnitudes, needs --cw-bandwidth",
+					},
+					&cli.Float64Flag{
+						Name:  "channel-spacing",
+						Usage: "Decode every channel of the band independently, channels are 50 to 500 Hz apart, 0 decodes the strongest carrier",
+					},
+					bandFilterFlag(),
+					notchFlag(),
+					noiseReductionFlag(),
+					&cli.StringFlag{
+						Name:  "monitor",
+						Usage: "File or fifo receiving filtered audio, play it with aplay -t raw -f S16_LE -c1 -r44100",
+					},
+				}, append(append(decisionFlags(), captureFlags(&captureCfg)...), iqFlags(&iqCfg)...)...),
+			},
+			{
+				Name:  "filter",
+				Usage: "Write a filtered copy of a recording for listening and viewing",
+				Action: func(cCtx *cli.Context) error {
+					return filterRecording(fileName, cCtx.String("out"), cCtx.Float64("cw-bandwidth"), readerOptions(cCtx))
+				},
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "file",
+						Aliases:     []string{"f"},
+						Usage:       "File to filter",
+						Destination: &fileName,
 						Required:    true,
-						DefaultText: "default",
+					},
+					&cli.StringFlag{
+						Name:     "out",
+						Aliases:  []string{"o"},
+						Usage:    "Filtered file, .wav or raw",
+						Required: true,
+					},
+					&cli.Float64Flag{
+						Name:  "cw-bandwidth",
+						Usage: "Bandwidth in Hz of a narrow filter following the carrier, 50 to 500, 0 keeps only the band-pass",
+						Value: 200,
+					},
+					bandFilterFlag(),
+					notchFlag(),
+					noiseReductionFlag(),
+				},
+			},
+			{
+				Name:  "devices",
+				Usage: "List ALSA PCM devices",
+				Action: func(cCtx *cli.Context) error {
+					devices, err := listDevices()
+					if err != nil {
+						return err
+					}
+					for _, d := range devices {
+						if !d.Input && !cCtx.Bool("all") {
+							continue
+						}
+						fmt.Printf("%s\n", d.Name)
+						for _, line := range strings.Split(d.Description, "\n") {
+							fmt.Printf("    %s\n", line)
+						}
+					}
+					return nil
+				},
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "all",
+						Usage: "List playback only devices too",
 					},
 				},
 			},
@@ -152,21 +440,73 @@ func main() {
 				Aliases: []string{"d"},
 				Usage:   "Detect morse code in a file",
 				Action: func(cCtx *cli.Context) error {
+					anns, err := detectAnnotations(fileName, annotationsName)
+					if err != nil {
+						return err
+					}
+					chars := &characterCollector{}
+					if incremental {
+						sink, err := newEventSink(outputFormat, os.Stdout)
+						if err != nil {
+							return err
+						}
+						err = decodeFile(fileName, cCtx.String("detector"), decisionOptions(cCtx), readerOptions(cCtx), MultiSink{sink, chars})
+						if outputFormat == "text" {
+							fmt.Println()
+						}
+						if err != nil {
+							return err
+						}
+						return printAccuracy(os.Stdout, outputFormat, measureAccuracy(anns, chars.chars))
+					}
+					if outputFormat != "text" {
+						sink, err := newEventSink(outputFormat, os.Stdout)
+						if err != nil {
+							return err
+						}
+						_, _, spectra, err := readSpectra(fileName, &Range{lb, ub}, readerOptions(cCtx))
+						if err != nil {
+							return err
+						}
+						detection, err := detectKeying(spectra, cCtx.String("detector"), decisionOptions(cCtx))
+						if err != nil {
+							return err
+						}
+						if err := emitDetection(MultiSink{sink, chars}, detection); err != nil {
+							return err
+						}
+						return printAccuracy(os.Stdout, outputFormat, measureAccuracy(anns, chars.chars))
+					}
 					fmt.Printf("Handling file name: %s\n", fileName)
-					_, res, values, _, _, _ := processFile(
-						fileName,
-						&Range{lb, ub},
-						&Range{lowerClassificationBoundary, upperClassificationBoundary},
-					)
+					_, _, spectra, err := readSpectra(fileName, &Range{lb, ub}, readerOptions(cCtx))
+					if err != nil {
+						return err
+					}
+					detection, err := detectKeying(spectra, cCtx.String("detector"), decisionOptions(cCtx))
+					if err != nil {
+						return err
+					}
+					values := detection.values
 					printBoolArray(values)
-					drawChart("filtered.html", res[70000:180000])
 					es := measureIntervals(values)
 					fmt.Printf("Elements: %v\n", es)
-					s := detectCode(es)
+					s, err := detectCode(es)
+					if err != nil {
+						return err
+					}
 					fmt.Printf("String: %s\n", s)
-					return nil
+					if len(anns) > 0 {
+						decoded, err := decodeCharacters(es)
+						if err != nil {
+							return err
+						}
+						for _, c := range decoded {
+							chars.Character(newCharacterEvent(c, blockStamp(c.start), blockStamp(c.end), 0))
+						}
+					}
+					return printAccuracy(os.Stdout, outputFormat, measureAccuracy(anns, chars.chars))
 				},
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name:        "file",
 						Aliases:     []string{"f"},
@@ -202,6 +542,86 @@ func main() {
 						Destination: &upperClassificationBoundary,
 						Required:    false,
 					},
+					&cli.StringFlag{
+						Name:        "format",
+						Usage:       "Output format: text or jsonl",
+						Destination: &outputFormat,
+						Value:       "text",
+					},
+					&cli.StringFlag{
+						Name:        "annotations",
+						Usage:       "Annotations with ground truth text for measuring accuracy, json or Audacity labels. The sidecar of the file is used if it exists",
+						Destination: &annotationsName,
+					},
+					&cli.BoolFlag{
+						Name:        "incremental",
+						Usage:       "Decode block by block in constant memory, for long recordings",
+						Destination: &incremental,
+					},
+					&cli.StringFlag{
+						Name:  "detector",
+						Usage: "Keying detector: threshold decides on every block, matched correlates the envelope with a dit at the estimated speed for weak signals",
+						Value: "threshold",
+					},
+					bandFilterFlag(),
+					notchFlag(),
+					noiseReductionFlag(),
+				}, decisionFlags()...),
+			},
+			{
+				Name:  "report",
+				Usage: "Write html analysis report for every file",
+				Action: func(cCtx *cli.Context) error {
+					for _, f := range cCtx.StringSlice("file") {
+						fmt.Printf("Analysing file name: %s\n", f)
+						name, err := writeReport(f, outDir, cCtx.String("echarts"))
+						if err != nil {
+							return err
+						}
+						fmt.Printf("Report written: %s\n", name)
+					}
+					return nil
+				},
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:     "file",
+						Aliases:  []string{"f"},
+						Usage:    "File to analyse, may be repeated",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:        "out",
+						Aliases:     []string{"o"},
+						Usage:       "Directory for reports",
+						Destination: &outDir,
+						Value:       ".",
+					},
+					&cli.StringFlag{
+						Name:  "echarts",
+						Usage: "echarts.min.js to inline into reports so they open offline, loaded from the go-echarts host if not set",
+					},
+				},
+			},
+			{
+				Name:  "annotations",
+				Usage: "Convert annotations of a recording from and to Audacity labels",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "import",
+						Usage: "Write Audacity labels into the sidecar of the file",
+						Action: func(cCtx *cli.Context) error {
+							return convertAnnotations(labelsName, sidecarName(fileName))
+						},
+						Flags: annotationFlags(&fileName, &labelsName),
+					},
+					{
+						Name:  "export",
+						Usage: "Write the sidecar of the file as Audacity labels",
+						Action: func(cCtx *cli.Context) error {
+							return convertAnnotations(sidecarName(fileName), labelsName)
+						},
+						Flags: annotationFlags(&fileName, &labelsName),
+					},
 				},
 			},
 		},
@@ -212,6 +632,195 @@ func main() {
 	}
 }
 
+func annotationFlags(fileName, labelsName *string) []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "file",
+			Aliases:     []string{"f"},
+			Usage:       "Annotated recording",
+			Destination: fileName,
+			Required:    true,
+		},
+		&cli.StringFlag{
+			Name:        "labels",
+			Usage:       "Audacity label track, .txt",
+			Destination: labelsName,
+			Required:    true,
+		},
+	}
+}
+
+func bandFilterFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:  "band-filter",
+		Usage: "Band-pass in front of the decoder: fir is linear phase, butterworth and chebyshev have less latency and need less CPU, resonator is a single section needing the least",
+		Value: "fir",
+	}
+}
+
+func notchFlag() cli.Flag {
+	return &cli.BoolFlag{
+		Name:  "notch",
+		Usage: "Notch out carriers that aren't keyed, dead carriers and tuning signals",
+	}
+}
+
+func noiseReductionFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:  "noise-reduction",
+		Usage: "Noise reduction of the band-pass filtered audio: spectral subtracts the noise spectrum of unkeyed blocks, lms enhances the tone with an adaptive filter",
+	}
+}
+
+func decisionFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.Float64Flag{
+			Name:  "hysteresis",
+			Usage: "Hysteresis of the keying detector, 0 to 1: its off and on thresholds are moved this fraction of the way towards the noise and the signal levels",
+		},
+		&cli.Float64Flag{
+			Name:  "min-mark",
+			Usage: "Shortest mark in dits, shorter noise bursts are spaces",
+		},
+		&cli.Float64Flag{
+			Name:  "min-space",
+			Usage: "Shortest space in dits, shorter dropouts are marks",
+		},
+		&cli.Float64Flag{
+			Name:  "squelch",
+			Usage: "SNR in dB below which nothing is decoded, 0 disables it",
+		},
+	}
+}
+
+// decisionOptions are the options of the decision stage set by
+// decisionFlags.
+func decisionOptions(cCtx *cli.Context) DecisionOptions {
+	return DecisionOptions{
+		Hysteresis: cCtx.Float64("hysteresis"),
+		MinMark:    cCtx.Float64("min-mark"),
+		MinSpace:   cCtx.Float64("min-space"),
+		Squelch:    cCtx.Float64("squelch"),
+	}
+}
+
+// readerOptions are the stages of reading recordings set by
+// bandFilterFlag, notchFlag and noiseReductionFlag.
+func readerOptions(cCtx *cli.Context) ReaderOptions {
+	return ReaderOptions{
+		BandFilter:     cCtx.String("band-filter"),
+		Notch:          cCtx.Bool("notch"),
+		NoiseReduction: cCtx.String("noise-reduction"),
+	}
+}
+
+func captureFlags(cfg *CaptureConfig) []cli.Flag {
+	return []cli.Flag{
+		&cli.IntFlag{
+			Name:        "rate",
+			Usage:       "Sample rate in Hz",
+			Destination: &cfg.Rate,
+			Value:       cfg.Rate,
+		},
+		&cli.IntFlag{
+			Name:        "channels",
+			Usage:       "Number of channels to capture",
+			Destination: &cfg.Channels,
+			Value:       cfg.Channels,
+		},
+		&cli.IntFlag{
+			Name:        "channel",
+			Usage:       "Channel to decode starting from 0, -1 mixes all channels down",
+			Destination: &cfg.Channel,
+			Value:       cfg.Channel,
+		},
+		&cli.StringFlag{
+			Name:        "sample-format",
+			Usage:       "Sample format: " + sampleFormatNames(),
+			Destination: &cfg.Format,
+			Value:       cfg.Format,
+		},
+		&cli.IntFlag{
+			Name:        "period",
+			Usage:       "Period size in frames",
+			Destination: &cfg.PeriodSize,
+			Value:       cfg.PeriodSize,
+		},
+		&cli.IntFlag{
+			Name:        "buffer",
+			Usage:       "Buffer size in frames, by default chosen by ALSA",
+			Destination: &cfg.BufferSize,
+		},
+	}
+}
+
+func iqFlags(cfg *IQConfig) []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "iq",
+			Usage:       "Demodulate I/Q samples from a file, - for stdin or rtl_tcp://host:port instead of capturing audio",
+			Destination: &cfg.Source,
+		},
+		&cli.StringFlag{
+			Name:        "iq-format",
+			Usage:       "I/Q sample format: u8, s16 or f32, rtl_tcp always sends u8",
+			Destination: &cfg.Format,
+			Value:       cfg.Format,
+		},
+		&cli.IntFlag{
+			Name:        "iq-rate",
+			Usage:       "I/Q sample rate in Hz",
+			Destination: &cfg.Rate,
+			Value:       cfg.Rate,
+		},
+		&cli.Float64Flag{
+			Name:        "center",
+			Usage:       "Frequency in Hz rtl_tcp is tuned to",
+			Destination: &cfg.Center,
+		},
+		&cli.Float64Flag{
+			Name:        "gain",
+			Usage:       "rtl_tcp gain in dB, 0 for automatic gain",
+			Destination: &cfg.Gain,
+		},
+		&cli.Float64Flag{
+			Name:        "shift",
+			Usage:       "Offset of the station from the center in Hz",
+			Destination: &cfg.Shift,
+		},
+		&cli.IntFlag{
+			Name:        "decimation",
+			Usage:       "Decimation of I/Q samples, by default down to the audio rate",
+			Destination: &cfg.Decimation,
+		},
+		&cli.Float64Flag{
+			Name:        "bandwidth",
+			Usage:       "Bandwidth kept around the station in Hz",
+			Destination: &cfg.Bandwidth,
+			Value:       cfg.Bandwidth,
+		},
+		&cli.Float64Flag{
+			Name:        "bfo",
+			Usage:       "Audio frequency of the station in Hz",
+			Destination: &cfg.BFO,
+			Value:       cfg.BFO,
+		},
+	}
+}
+
+// sourceConfig chooses I/Q input when the iq flag is given and the
+// sound card otherwise.
+func sourceConfig(cCtx *cli.Context, device string, capture CaptureConfig, iq IQConfig) SourceConfig {
+	capture.Device = device
+	if iq.Source == "" {
+		return SourceConfig{Capture: capture}
+	}
+	if iq.isRTLTCP() && !cCtx.IsSet("iq-format") {
+		iq.Format = "u8"
+	}
+	return SourceConfig{Capture: capture, IQ: &iq}
+}
+
 func printBoolArray(bs []bool) {
 	for i := 0; i < len(bs); i++ {
 		if bs[i] {
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	matchedRiseTime = 0.005 // Seconds of the raised cosine edges of keyed elements.
+	matchedStartWpm = 30    // Speed of the template until the decoder estimates it.
+)
+
+var keyingDetectors = []string{"threshold", "matched"}
+
+func checkKeyingDetector(kind string) error {
+	for _, k := range keyingDetectors {
+		if k == kind {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown keying detector %v, want one of %v", kind, keyingDetectors)
+}
+
+// ditTemplate is a dit of dit blocks of step samples with raised cosine
+// edges sampled in the middle of every block. It sums up to 1, so the
+// correlation is a weighted mean of the envelope comparable with the
+// threshold of the detector.
+func ditTemplate(dit float64, step int) []float64 {
+	n := int(math.Max(1, math.Round(dit)))
+	rise := math.Min(matchedRiseTime*sampleRate/float64(step), float64(n)/4)
+	t := make([]float64, n)
+	var sum float64
+	for k := range t {
+		x := float64(k) + 0.5
+		t[k] = 1
+		if e := math.Min(x, float64(n)-x); e < rise {
+			t[k] = 0.5 * (1 - math.Cos(math.Pi*e/rise))
+		}
+		sum += t[k]
+	}
+	for k := range t {
+		t[k] /= sum
+	}
+	return t
+}
+
+// ditBlocks returns the dit in blocks of step samples at the speed.
+func ditBlocks(wpm float64, step int) float64 {
+	return 1.2 / wpm * sampleRate / float64(step)
+}
+
+// matchedFilter correlates the envelope with a dit ending at the newest
+// value. Its output lags the envelope by delay blocks.
+type matchedFilter struct {
+	template []float64
+	hist     []float64
+}
+
+func newMatchedFilter(dit float64, step int) *matchedFilter {
+	mf := &matchedFilter{}
+	mf.setDit(dit, step)
+	return mf
+}
+
+func (mf *matchedFilter) setDit(dit float64, step int) {
+	mf.template = ditTemplate(dit, step)
+}
+
+func (mf *matchedFilter) delay() float64 {
+	return float64(len(mf.template)-1) / 2
+}
+
+func (mf *matchedFilter) filter(v float64) float64 {
+	mf.hist = append(mf.hist, v)
+	if len(mf.hist) > len(mf.template) {
+		mf.hist = mf.hist[len(mf.hist)-len(mf.template):]
+	}
+	// Values before the first one are taken to be the first one.
+	var y float64
+	for k, t := range mf.template {
+		i := len(mf.hist) - len(mf.template) + k
+		if i < 0 {
+			i = 0
+		}
+		y += t * mf.hist[i]
+	}
+	return y
+}
+
+// crossing returns where between prev and cur, 0 at prev and 1 at cur,
+// the envelope crosses the threshold.
+func crossing(prev, cur, threshold float64) float64 {
+	if cur == prev {
+		return 0.5
+	}
+	return math.Max(0, math.Min(1, (threshold-prev)/(cur-prev)))
+}
+
+// matchDetection replaces block decisions of the detection with ones
+// of the envelope correlated with a dit centred on every block. Noise
+// breaks marks of the block decisions, so the dit is estimated on
+// decisions of a correlation with a dit at matchedStartWpm first.
+// Element boundaries are interpolated between blocks.
+func matchDetection(d *Detection) error {
+	d.threshold = d.detector.midpoint()
+	d.correlate(ditBlocks(matchedStartWpm, fragmentSize))
+	dit, _, err := classifySignals(measureIntervals(d.values))
+	if err != nil {
+		return err
+	}
+	d.correlate(float64(dit))
+	return nil
+}
+
+// correlate decides on signals correlated with a dit of dit blocks.
+func (d *Detection) correlate(dit float64) {
+	template := ditTemplate(dit, fragmentSize)
+	c := (len(template) - 1) / 2
+	// The middle of an even template is half a block later.
+	d.shift = float64(len(template)-1)/2 - float64(c)
+	d.filtered = make([]float64, len(d.signals))
+	for i := range d.filtered {
+		for k, t := range template {
+			j := i - c + k
+			if j < 0 {
+				j = 0
+			}
+			if j >= len(d.signals) {
+				j = len(d.signals) - 1
+			}
+			d.filtered[i] += t * d.signals[j]
+		}
+		d.values[i] = d.filtered[i] > d.threshold
+	}
+}
+
+// boundary returns the stamp of the boundary before block i. It is
+// between blocks where the filtered envelope crosses the threshold, or
+// without the correlation where magnitudes cross half way between the
+// means.
+func (d *Detection) boundary(i int) Stamp {
+	signals, threshold, shift := d.filtered, d.threshold, d.shift
+	if signals == nil {
+		signals, shift = d.signals, 0
+		if d.detector != nil {
+			threshold = d.detector.midpoint()
+		}
+	}
+	if i <= 0 || i >= len(signals) {
+		return blockStamp(i)
+	}
+	f := crossing(signals[i-1], signals[i], threshold)
+	return Stamp{Offset: int64(math.Round((float64(i) - 0.5 + shift + f) * fragmentSize))}
+}
+
+// detectKeying detects the carrier and its keying in spectra with one of
+// keyingDetectors followed by the decision stage.
+func detectKeying(spectra [][]float64, kind string, decision DecisionOptions) (*Detection, error) {
+	if err := checkKeyingDetector(kind); err != nil {
+		return nil, err
+	}
+	d, err := detectSignal(spectra)
+	if err != nil {
+		return nil, err
+	}
+	if kind == "matched" {
+		if err := matchDetection(d); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.decide(decision); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
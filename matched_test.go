@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestDitTemplate(t *testing.T) {
+	for _, dit := range []float64{1, 4, 30} {
+		tm := ditTemplate(dit, envelopeStep)
+		if len(tm) != int(dit) {
+			t.Fatalf("Template of %v blocks has %v values", dit, len(tm))
+		}
+		var sum float64
+		for k, v := range tm {
+			sum += v
+			if math.Abs(v-tm[len(tm)-1-k]) > 1e-12 {
+				t.Errorf("Template of %v blocks isn't symmetric: %v", dit, tm)
+			}
+		}
+		if math.Abs(sum-1) > 1e-12 {
+			t.Errorf("Template of %v blocks sums up to %v", dit, sum)
+		}
+	}
+	// Edges of 5 ms are raised cosines.
+	tm := ditTemplate(30, envelopeStep)
+	if tm[0] > tm[len(tm)/2]/4 || tm[5] != tm[len(tm)/2] {
+		t.Errorf("Template edges %v", tm)
+	}
+}
+
+// noisyPCM renders keyed elements as a tone in bin 15 buried in gaussian
+// noise of the deviation.
+func noisyPCM(es []Element, offset int, noise float64, seed int64) []byte {
+	rnd := rand.New(rand.NewSource(seed))
+	audio := append(make([]float64, offset), keyedTone(es, 15*sampleRate/fragmentSize, 1000)...)
+	pcm := make([]int16, len(audio))
+	for i, v := range audio {
+		pcm[i] = clampInt16(v + noise*rnd.NormFloat64())
+	}
+	raw := make([]byte, 2*len(pcm))
+	encodePCM(raw, pcm)
+	return raw
+}
+
+// keyedSpectra returns spectra of blocks of noisyPCM.
+func keyedSpectra(t *testing.T, es []Element, offset int, noise float64, seed int64) [][]float64 {
+	t.Helper()
+	sr := NewSpectrumReader(bytes.NewReader(noisyPCM(es, offset, noise, seed)))
+	var spectra [][]float64
+	for {
+		_, _, spectrum, err := sr.Next()
+		if err == io.EOF {
+			return spectra
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		spectra = append(spectra, spectrum[0:222])
+	}
+}
+
+func TestMatchedDetectionWeakSignal(t *testing.T) {
+	text := "paris paris paris"
+	es := encodeText(text, 4)
+	decoded := map[string]int{}
+	for seed := int64(1); seed <= 5; seed++ {
+		spectra := keyedSpectra(t, es, 0, 2200, seed)
+		for _, kind := range keyingDetectors {
+			d, err := detectKeying(spectra, kind, DecisionOptions{})
+			if err != nil {
+				continue
+			}
+			sink := &collectingSink{}
+			if err := emitDetection(sink, d); err == nil && strings.TrimSpace(sink.text.String()) == text {
+				decoded[kind]++
+			}
+		}
+	}
+	t.Logf("Decoded recordings: %v", decoded)
+	if decoded["matched"] < 4 || decoded["matched"] <= decoded["threshold"] {
+		t.Errorf("Decoded recordings: %v", decoded)
+	}
+}
+
+type elementRecorder struct {
+	collectingSink
+	elements []ElementEvent
+}
+
+func (er *elementRecorder) Element(e ElementEvent) {
+	er.elements = append(er.elements, e)
+}
+
+func TestMatchedBoundaries(t *testing.T) {
+	// Marks start a fraction of a block into blocks.
+	offset := fragmentSize * 3 / 10
+	es := encodeText("paris paris", 4)
+	spectra := keyedSpectra(t, es, offset, 100, 1)
+	var want []int
+	pos := offset
+	for _, e := range es {
+		want = append(want, pos)
+		pos += e.d * fragmentSize
+	}
+	// Block decisions are up to half a block off, interpolated boundaries
+	// are off by less on average. The band-pass delays both.
+	check := func(name string, elements []ElementEvent) {
+		t.Helper()
+		if len(elements) < len(es)/2 {
+			t.Fatalf("%v: got %v elements", name, len(elements))
+		}
+		var sum int
+		// The last element may be incomplete.
+		for _, e := range elements[:len(elements)-1] {
+			d := len(spectra) * fragmentSize
+			for _, w := range want {
+				if v := abs(int(e.EndSample) - w); v < d {
+					d = v
+				}
+			}
+			if d > fragmentSize/2 {
+				t.Errorf("%v: element ends at %v, %v samples from a boundary", name, e.EndSample, d)
+			}
+			sum += d
+		}
+		if mean := sum / (len(elements) - 1); mean > fragmentSize/4 {
+			t.Errorf("%v: boundaries are %v samples off on average", name, mean)
+		}
+	}
+
+	for _, kind := range []string{"matched", "threshold"} {
+		d, err := detectKeying(spectra, kind, DecisionOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		er := &elementRecorder{}
+		if err := emitDetection(er, d); err != nil {
+			t.Fatal(err)
+		}
+		check(kind+" detection", er.elements)
+
+		er = &elementRecorder{}
+		decoder := NewStreamDecoder(er)
+		if err := decoder.setKeyingDetector(kind); err != nil {
+			t.Fatal(err)
+		}
+		for i, s := range spectra {
+			decoder.Add(s, blockStamp(i))
+		}
+		decoder.Flush()
+		check(kind+" stream", er.elements)
+		if got := strings.TrimSpace(er.text.String()); !strings.HasSuffix(got, "paris") {
+			t.Errorf("%v: decoded %q", kind, got)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"github.com/mjibson/go-dsp/fft"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Lowest gain of a bin. Noise peaks passing the subtraction stand out
+	// of noise attenuated much more, the detector takes them for marks.
+	spectralFloor = 0.3
+	// Noise frames around a noise frame used for the profile, frames
+	// overlapping a mark aren't noise.
+	spectralQuietFrames = 3
+
+	lineEnhancerTaps  = 128
+	lineEnhancerDelay = 32 // Samples, band-pass filtered noise is correlated over fewer.
+	// Noise in the band pulls the weights to zero between marks, a big
+	// step brings them back within the first millisecond of a mark.
+	lineEnhancerStep = 0.05
+)
+
+var noiseReducers = []string{"spectral", "lms"}
+
+// newNoiseReducer returns one of noiseReducers filtering the band-pass
+// filtered audio, nil if kind is empty.
+func newNoiseReducer(kind string) (BlockFilter, error) {
+	switch kind {
+	case "":
+		return nil, nil
+	case "spectral":
+		return NewSpectralSubtractor(fragmentSize), nil
+	case "lms":
+		return NewLineEnhancer(lineEnhancerTaps, lineEnhancerDelay, lineEnhancerStep), nil
+	}
+	return nil, fmt.Errorf("Unknown noise reduction %v, want one of %v", kind, noiseReducers)
+}
+
+// SpectralSubtractor subtracts a noise profile from the power spectrum of
+// frames of n samples overlapping by half and adds them back together.
+// The profile is the mean power spectrum of frames the detector labels
+// as noise. The detector and the carrier are re-estimated on recent
+// frames like in the stream decoder, until they are found the audio
+// passes unchanged. The audio is delayed by n samples.
+type SpectralSubtractor struct {
+	n       int
+	window  []float64 // Square root of the Hann window, for analysis and synthesis.
+	hist    []float64 // Samples of the next frame, the newest half is being filled.
+	pending int       // Samples in the newest half.
+	acc     []float64 // Overlapping frames added together.
+	out     []float64 // Samples ready to be returned.
+
+	frames    [][]float64 // Magnitudes of recent frames in the band.
+	frame     int
+	frequency int
+	detector  *EMSingleFrequencyDetector
+
+	noise []float64 // Power spectrum profile.
+	last  []float64 // Power spectrum of the previous frame.
+	quiet int       // Consecutive noise frames.
+}
+
+func NewSpectralSubtractor(n int) *SpectralSubtractor {
+	window := make([]float64, n)
+	for i := range window {
+		window[i] = math.Sin(math.Pi * float64(i) / float64(n))
+	}
+	return &SpectralSubtractor{
+		n:      n,
+		window: window,
+		hist:   make([]float64, n),
+		acc:    make([]float64, n),
+		out:    make([]float64, n/2),
+	}
+}
+
+// Delay is the number of samples the audio is delayed by.
+func (ss *SpectralSubtractor) Delay() int {
+	return ss.n
+}
+
+func (ss *SpectralSubtractor) FilterBuf(buf []float64) []float64 {
+	hop := ss.n / 2
+	for _, v := range buf {
+		ss.hist[hop+ss.pending] = v
+		if ss.pending++; ss.pending == hop {
+			ss.pending = 0
+			ss.process()
+			copy(ss.hist, ss.hist[hop:])
+			ss.out = append(ss.out, ss.acc[:hop]...)
+			copy(ss.acc, ss.acc[hop:])
+			for i := hop; i < ss.n; i++ {
+				ss.acc[i] = 0
+			}
+		}
+	}
+	res := make([]float64, len(buf))
+	copy(res, ss.out)
+	ss.out = ss.out[:copy(ss.out, ss.out[len(buf):])]
+	return res
+}
+
+// process adds the newest frame with the noise subtracted to acc.
+func (ss *SpectralSubtractor) process() {
+	frame := make([]float64, ss.n)
+	for i, v := range ss.hist {
+		frame[i] = v * ss.window[i]
+	}
+	spectrum := fft.FFTReal(frame)
+	power := make([]float64, ss.n/2+1)
+	for k := range power {
+		a := cmplx.Abs(spectrum[k])
+		power[k] = a * a
+	}
+	ss.label(power)
+	if ss.noise != nil {
+		for k := range power {
+			g := spectralFloor
+			if power[k] > 0 {
+				g = math.Max(g, math.Sqrt(math.Max(0, 1-ss.noise[k]/power[k])))
+			}
+			spectrum[k] *= complex(g, 0)
+			// The spectrum of a real frame is symmetric.
+			if k > 0 && k < ss.n/2 {
+				spectrum[ss.n-k] *= complex(g, 0)
+			}
+		}
+	}
+	for i, v := range realParts(fft.IFFT(spectrum)) {
+		ss.acc[i] += v * ss.window[i]
+	}
+}
+
+// label decides whether the frame is noise with the detector and adds
+// the previous frame to the noise profile when it is surrounded by noise.
+func (ss *SpectralSubtractor) label(power []float64) {
+	magnitudes := make([]float64, 222)
+	for k := range magnitudes {
+		magnitudes[k] = math.Sqrt(power[k])
+	}
+	// Frames overlap, the window and the interval hold twice as many.
+	ss.frames = append(ss.frames, magnitudes)
+	if len(ss.frames) > 2*decoderWindow {
+		ss.frames = ss.frames[len(ss.frames)-2*decoderWindow:]
+	}
+	if len(ss.frames) >= 2*decoderInterval && (ss.detector == nil || ss.frame%(2*decoderInterval) == 0) {
+		ss.retune()
+	}
+	ss.frame++
+	if ss.detector == nil {
+		return
+	}
+	if ss.detector.isSignal(magnitudes[ss.frequency]) {
+		ss.quiet = 0
+	} else {
+		ss.quiet++
+	}
+	if ss.quiet >= spectralQuietFrames {
+		if ss.noise == nil {
+			ss.noise = make([]float64, len(power))
+			copy(ss.noise, ss.last)
+		}
+		for k := range ss.noise {
+			// Mean over about a second.
+			ss.noise[k] += (ss.last[k] - ss.noise[k]) / (2 * decoderInterval)
+		}
+	}
+	ss.last = power
+}
+
+func (ss *SpectralSubtractor) retune() {
+	frequency, err := calculateSignificantFrequency(ss.frames)
+	if err != nil {
+		return
+	}
+	detector, err := classifyEMFromSingleFrequency(cleanupSignal(extractFrequency(ss.frames, frequency)))
+	if err != nil {
+		log.Debugf("Noise profile detector failed: %v", err)
+		return
+	}
+	ss.frequency = frequency
+	ss.detector = detector
+}
+
+// LineEnhancer is an adaptive line enhancer. A normalised LMS filter
+// predicts every sample from samples delay and more samples earlier.
+// Noise isn't correlated over the delay and can't be predicted, the
+// prediction is the tone.
+type LineEnhancer struct {
+	w     []float64
+	hist  []float64 // Last samples, the newest at the end.
+	step  float64
+	power float64 // Of the samples the prediction is made of.
+}
+
+func NewLineEnhancer(taps, delay int, step float64) *LineEnhancer {
+	return &LineEnhancer{
+		w:    make([]float64, taps),
+		hist: make([]float64, taps+delay),
+		step: step,
+	}
+}
+
+func (le *LineEnhancer) FilterBuf(buf []float64) []float64 {
+	res := make([]float64, len(buf))
+	taps := len(le.w)
+	for i, x := range buf {
+		// past[k] is the sample delay+k samples before x.
+		past := le.hist[:taps]
+		var y float64
+		for k, w := range le.w {
+			y += w * past[taps-1-k]
+		}
+		res[i] = y
+		// Samples of a quantisation step bound the step in silence.
+		mu := le.step * (x - y) / (le.power + float64(taps))
+		for k := range le.w {
+			le.w[k] += mu * past[taps-1-k]
+		}
+		// The oldest sample leaves the prediction, the one delay samples
+		// before the next enters it.
+		le.power += le.hist[taps]*le.hist[taps] - le.hist[0]*le.hist[0]
+		copy(le.hist, le.hist[1:])
+		le.hist[len(le.hist)-1] = x
+	}
+	return res
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestNewNoiseReducer(t *testing.T) {
+	if r, err := newNoiseReducer(""); r != nil || err != nil {
+		t.Errorf("Empty kind gives %v, %v", r, err)
+	}
+	for _, kind := range noiseReducers {
+		if r, err := newNoiseReducer(kind); r == nil || err != nil {
+			t.Errorf("%v gives %v, %v", kind, r, err)
+		}
+	}
+	if _, err := newNoiseReducer("wiener"); err == nil {
+		t.Errorf("Unknown kind is accepted")
+	}
+}
+
+func TestSpectralSubtractorPassesUntilDetected(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	in := make([]float64, 5000)
+	for i := range in {
+		in[i] = 1000 * rnd.NormFloat64()
+	}
+	ss := NewSpectralSubtractor(fragmentSize)
+	var out []float64
+	// Blocks not lining up with frames.
+	for i := 0; i < len(in); i += 300 {
+		b := ss.FilterBuf(in[i:minInt(i+300, len(in))])
+		if len(b) != minInt(300, len(in)-i) {
+			t.Fatalf("Got %v samples", len(b))
+		}
+		out = append(out, b...)
+	}
+	for i := fragmentSize; i < len(out); i++ {
+		if math.Abs(out[i]-in[i-fragmentSize]) > 1e-6 {
+			t.Fatalf("Sample %v is %v, want %v", i, out[i], in[i-fragmentSize])
+		}
+	}
+}
+
+// markRatio returns the mean magnitude of the tone in bin 15 in marks
+// over the one in spaces. Spectra are delay blocks late.
+func markRatio(t *testing.T, es []Element, pcm []byte, kind string, delay int) float64 {
+	t.Helper()
+	sr := NewSpectrumReader(bytes.NewReader(pcm))
+	if err := sr.configure(ReaderOptions{NoiseReduction: kind}); err != nil {
+		t.Fatal(err)
+	}
+	var spectra [][]float64
+	for {
+		_, _, spectrum, err := sr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		spectra = append(spectra, spectrum)
+	}
+	var marks []bool
+	for _, e := range es {
+		for i := 0; i < e.d; i++ {
+			marks = append(marks, e.s)
+		}
+	}
+	var m, s float64
+	var nm, ns int
+	// After the profile is estimated.
+	for i := 2 * decoderInterval; i < len(marks) && i+delay < len(spectra); i++ {
+		if marks[i] {
+			m, nm = m+spectra[i+delay][15], nm+1
+		} else {
+			s, ns = s+spectra[i+delay][15], ns+1
+		}
+	}
+	return m / float64(nm) / (s / float64(ns))
+}
+
+func TestNoiseReduction(t *testing.T) {
+	text := "paris paris paris paris"
+	es := encodeText(text, 4)
+	pcm := noisyPCM(es, 0, 1000, 1)
+	plain := markRatio(t, es, pcm, "", 0)
+	for _, c := range []struct {
+		kind  string
+		delay int
+	}{
+		{"spectral", 1},
+		{"lms", 0},
+	} {
+		if r := markRatio(t, es, pcm, c.kind, c.delay); r < 1.5*plain {
+			t.Errorf("%v: marks are %.1f times the spaces, %.1f without noise reduction", c.kind, r, plain)
+		}
+		// Reduction doesn't break decoding of clean signals either.
+		for _, p := range [][]byte{pcm, keyedPCM(es, 1)} {
+			sink := &collectingSink{}
+			if err := decodeReader(bytes.NewReader(p), "threshold", DecisionOptions{}, ReaderOptions{NoiseReduction: c.kind}, sink); err != nil {
+				t.Fatal(err)
+			}
+			// The decoder needs a second of audio before it locks on.
+			if !strings.Contains(sink.text.String(), "paris paris paris") {
+				t.Errorf("%v: decoded %q", c.kind, sink.text.String())
+			}
+		}
+	}
+}
+
+func TestLineEnhancer(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	f := 15.0 * sampleRate / fragmentSize
+	x := sine(2*sampleRate, f, sampleRate, 1000)
+	for i := range x {
+		x[i] += 2000 * rnd.NormFloat64()
+	}
+	bp := NewBpFilter(200, bandLow, bandHigh, fragmentSize)
+	var in []float64
+	for i := 0; i+fragmentSize <= len(x); i += fragmentSize {
+		in = append(in, bp.FilterBuf(x[i:i+fragmentSize])...)
+	}
+	out := NewLineEnhancer(lineEnhancerTaps, lineEnhancerDelay, lineEnhancerStep).FilterBuf(in)
+	// Power besides the tone after the filter has converged.
+	noise := func(s []float64) float64 {
+		s = s[sampleRate:]
+		var p float64
+		for _, v := range s {
+			p += v * v
+		}
+		a := goertzel(s, f)
+		return p/float64(len(s)) - a*a/2
+	}
+	if a := goertzel(out[sampleRate:], f); a < 850 || a > 1050 {
+		t.Errorf("Tone amplitude is %v, want 1000", a)
+	}
+	if r := 10 * math.Log10(noise(in)/noise(out)); r < 2 {
+		t.Errorf("Noise is reduced by %.1f dB", r)
+	}
+}
+
+func TestSpectralSubtractorStamps(t *testing.T) {
+	const dit = 6
+	es := encodeText("paris paris paris paris", dit)
+	er := &eventRecorder{}
+	if err := decodeReader(bytes.NewReader(keyedPCM(es, 1)), "threshold", DecisionOptions{}, ReaderOptions{NoiseReduction: "spectral"}, er); err != nil {
+		t.Fatal(err)
+	}
+	// The last letter ends where the trailing word gap begins, stamps
+	// don't include the delay of the subtractor.
+	total := 0
+	for _, e := range es {
+		total += e.d
+	}
+	end := int64((total - 7*dit) * fragmentSize)
+	var last CharacterEvent
+	for _, c := range er.characters {
+		if c.Text != " " {
+			last = c
+		}
+	}
+	if d := last.EndSample - end; d < -fragmentSize/2 || d > fragmentSize/2 {
+		t.Errorf("Last letter %q ends at %v, want %v", last.Text, last.EndSample, end)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Ratio of loud and quiet magnitudes of a keyed carrier, 20 dB. It is
+	// about 13 dB in noise and close to 0 dB for a steady carrier.
+	keyedDepth     = 10
+	minKeyedMarks  = 3  // Marks in a window of a keyed carrier, a tuning carrier has one.
+	minKeyingBlock = 16 // Blocks needed to tell keyed carriers from steady ones.
+
+	notchWindow    = 5 * sampleRate / fragmentSize // Blocks a carrier has to stay unkeyed over.
+	notchBandwidth = 100                           // Hz, wider than the error of the estimated frequency.
+	notchMinLevel  = 10                            // dB above the median of the band.
+	maxNotches     = 4
+)
+
+// percentile returns the magnitude p of signals are below, signals are
+// left unchanged.
+func percentile(signals []float64, p float64) float64 {
+	s := make([]float64, len(signals))
+	copy(s, signals)
+	sort.Float64s(s)
+	return s[int(p*float64(len(s)-1))]
+}
+
+// keyed tells whether magnitudes of a carrier are switched on and off.
+// A steady carrier barely changes, a tuning carrier switches on and off
+// once. Switching is counted with hysteresis between the quiet and the
+// loud magnitudes, so noise on a steady level isn't counted.
+func keyed(signals []float64) bool {
+	lo, hi := percentile(signals, 0.1), percentile(signals, 0.9)
+	if hi < keyedDepth*lo {
+		return false
+	}
+	on, marks := false, 0
+	for _, v := range signals {
+		if !on && v > lo+(hi-lo)*2/3 {
+			on = true
+			marks++
+		} else if on && v < lo+(hi-lo)/3 {
+			on = false
+		}
+	}
+	return marks >= minKeyedMarks
+}
+
+// strongestKeyed returns the peak of sums of magnitudes with the largest
+// sum of the carrier in it keyed. A steady carrier is stronger than
+// a keyed one summed over blocks, but it isn't what is decoded. Without
+// a keyed carrier or without enough blocks to tell it is the strongest
+// bin.
+func strongestKeyed(spectra [][]float64, sum []float64) int {
+	bins := make([]int, len(sum))
+	for j := range bins {
+		bins[j] = j
+	}
+	sort.SliceStable(bins, func(a, b int) bool { return sum[bins[a]] > sum[bins[b]] })
+	if len(spectra) < minKeyingBlock {
+		return bins[0]
+	}
+	for _, j := range bins {
+		// Skirts of a stronger carrier aren't carriers.
+		peak := j > 0 && j < len(sum)-1 && sum[j] >= sum[j-1] && sum[j] > sum[j+1]
+		if peak && keyed(extractFrequency(spectra, j)) {
+			return j
+		}
+	}
+	return bins[0]
+}
+
+// unkeyedCarriers returns frequencies in Hz of the strongest carriers in
+// the band standing out of the noise that aren't keyed.
+func unkeyedCarriers(spectra [][]float64) []float64 {
+	if len(spectra) < minKeyingBlock {
+		return nil
+	}
+	lo := int(math.Ceil(bandLow * fragmentSize))
+	hi := int(math.Floor(bandHigh * fragmentSize))
+	sum := make([]float64, hi+2)
+	for _, s := range spectra {
+		for j := lo - 1; j <= hi+1; j++ {
+			sum[j] += s[j]
+		}
+	}
+	floor := percentile(sum[lo:hi+1], 0.5) * math.Pow(10, notchMinLevel/20.0)
+	var peaks []int
+	for j := lo; j <= hi; j++ {
+		if sum[j] > floor && sum[j] >= sum[j-1] && sum[j] > sum[j+1] && !keyed(extractFrequency(spectra, j)) {
+			peaks = append(peaks, j)
+		}
+	}
+	sort.Slice(peaks, func(a, b int) bool { return sum[peaks[a]] > sum[peaks[b]] })
+	if len(peaks) > maxNotches {
+		peaks = peaks[:maxNotches]
+	}
+	frequencies := make([]float64, len(peaks))
+	for i, j := range peaks {
+		// Vertex of the parabola through logarithms of the bin and its
+		// neighbours, the peak of a Hann window is nearly gaussian.
+		a, b, c := math.Log(sum[j-1]), math.Log(sum[j]), math.Log(sum[j+1])
+		delta := 0.0
+		if d := a - 2*b + c; d != 0 {
+			delta = 0.5 * (a - c) / d
+		}
+		frequencies[i] = (float64(j) + delta) * sampleRate / fragmentSize
+	}
+	return frequencies
+}
+
+type notch struct {
+	frequency float64
+	filter    *IIRFilter
+}
+
+// Notcher removes carriers that aren't keyed, dead carriers and tuning
+// signals, with notch filters. Carriers are re-estimated every second on
+// spectra of recent blocks. Notches of carriers still there are kept,
+// so their filters don't start over.
+type Notcher struct {
+	window  [][]float64
+	block   int
+	notches []notch
+}
+
+func NewNotcher() *Notcher {
+	return &Notcher{}
+}
+
+// FilterBuf notches a block of fragmentSize samples.
+func (n *Notcher) FilterBuf(buf []float64) []float64 {
+	n.window = append(n.window, blockSpectrum(buf))
+	if len(n.window) > notchWindow {
+		n.window = n.window[len(n.window)-notchWindow:]
+	}
+	n.block++
+	if len(n.window) >= decoderInterval && n.block%decoderInterval == 0 {
+		n.retune()
+	}
+	for _, nt := range n.notches {
+		buf = nt.filter.FilterBuf(buf)
+	}
+	return buf
+}
+
+func (n *Notcher) retune() {
+	var notches []notch
+	for _, f := range unkeyedCarriers(n.window) {
+		kept := false
+		for _, nt := range n.notches {
+			// Within half a bin it is the same carrier.
+			if math.Abs(nt.frequency-f) < sampleRate/fragmentSize/2 {
+				notches = append(notches, nt)
+				kept = true
+				break
+			}
+		}
+		if kept {
+			continue
+		}
+		filter, err := NewNotch(f/sampleRate, f/notchBandwidth)
+		if err != nil {
+			log.Warnf("Failed to notch %.1f Hz: %v", f, err)
+			continue
+		}
+		log.Infof("Notching unkeyed carrier at %.1f Hz", f)
+		notches = append(notches, notch{f, filter})
+	}
+	n.notches = notches
+}
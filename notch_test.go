@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestKeyed(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	level := func(on bool) float64 {
+		if on {
+			return 1000 + 20*rnd.NormFloat64()
+		}
+		return 10 * math.Abs(rnd.NormFloat64())
+	}
+	var steady, keying, tuning []float64
+	for i := 0; i < 400; i++ {
+		steady = append(steady, level(true))
+		keying = append(keying, level(i/8%2 == 0))
+		tuning = append(tuning, level(i > 100 && i < 300))
+	}
+	for _, c := range []struct {
+		name    string
+		signals []float64
+		keyed   bool
+	}{
+		{"steady", steady, false},
+		{"keying", keying, true},
+		{"tuning", tuning, false},
+	} {
+		if k := keyed(c.signals); k != c.keyed {
+			t.Errorf("%v carrier is keyed: %v", c.name, k)
+		}
+	}
+}
+
+// deadCarrierPCM renders keyed elements as a tone in bin 15 next to
+// a steady carrier at frequency Hz three times as strong.
+func deadCarrierPCM(es []Element, frequency float64) []byte {
+	rnd := rand.New(rand.NewSource(1))
+	audio := keyedTone(es, 15*sampleRate/fragmentSize, 1000)
+	pcm := make([]int16, len(audio))
+	for i, v := range audio {
+		v += 3000*math.Sin(2*math.Pi*frequency*float64(i)/sampleRate) + 200*rnd.NormFloat64()
+		pcm[i] = clampInt16(v)
+	}
+	raw := make([]byte, 2*len(pcm))
+	encodePCM(raw, pcm)
+	return raw
+}
+
+func readerSpectra(t *testing.T, pcm []byte, opts ReaderOptions) (res []float64, spectra [][]float64) {
+	t.Helper()
+	sr := NewSpectrumReader(bytes.NewReader(pcm))
+	if err := sr.configure(opts); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, r, spectrum, err := sr.Next()
+		if err == io.EOF {
+			return res, spectra
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, r...)
+		spectra = append(spectra, spectrum[0:222])
+	}
+}
+
+func TestKeyedCarrierIsDecoded(t *testing.T) {
+	text := "cq cq de ab1c ab1c k"
+	pcm := deadCarrierPCM(encodeText(text, 5), 900)
+	_, spectra := readerSpectra(t, pcm, ReaderOptions{})
+	if f, err := calculateSignificantFrequency(spectra); f != 15 || err != nil {
+		t.Errorf("Significant frequency is %v, %v", f, err)
+	}
+	for _, opts := range []ReaderOptions{{}, {Notch: true}} {
+		sink := &collectingSink{}
+		if err := decodeReader(bytes.NewReader(pcm), "threshold", DecisionOptions{}, opts, sink); err != nil {
+			t.Fatal(err)
+		}
+		if want := text[strings.Index(text, "de"):]; !strings.Contains(sink.text.String(), want) {
+			t.Errorf("%+v: decoded %q, want %q", opts, sink.text.String(), want)
+		}
+	}
+}
+
+func TestUnkeyedCarriers(t *testing.T) {
+	for _, frequency := range []float64{900, 1000, 2000} {
+		_, spectra := readerSpectra(t, deadCarrierPCM(encodeText("test test", 5), frequency), ReaderOptions{})
+		carriers := unkeyedCarriers(spectra)
+		if len(carriers) != 1 || math.Abs(carriers[0]-frequency) > 5 {
+			t.Errorf("Unkeyed carriers %v, want %v Hz", carriers, frequency)
+		}
+	}
+}
+
+func TestNotcher(t *testing.T) {
+	text := "test test test test"
+	es := encodeText(text, 5)
+	without, _ := readerSpectra(t, deadCarrierPCM(es, 1000), ReaderOptions{})
+	with, _ := readerSpectra(t, deadCarrierPCM(es, 1000), ReaderOptions{Notch: true})
+	// After the carrier is found.
+	without, with = without[2*sampleRate:], with[2*sampleRate:]
+	if r := goertzel(with, 1000) / goertzel(without, 1000); r > 0.1 {
+		t.Errorf("Carrier is attenuated to %v", r)
+	}
+	keyedFrequency := 15.0 * sampleRate / fragmentSize
+	if r := goertzel(with, keyedFrequency) / goertzel(without, keyedFrequency); r < 0.9 {
+		t.Errorf("Keyed tone is attenuated to %v", r)
+	}
+}
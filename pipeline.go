@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+
+	"github.com/mjibson/go-dsp/fft"
+)
+
+// SampleBlock is a chunk of mono PCM audio, Stamp locates its first sample.
+type SampleBlock struct {
+	Samples []int16
+	Stamp   Stamp
+}
+
+// Fragment is a block of fragmentSize samples going through the DSP stages.
+type Fragment struct {
+	Samples []float64
+	Stamp   Stamp
+	Carrier float64 // Hz the fragment is narrow filtered around, 0 if it isn't.
+}
+
+// SpectrumBlock is the spectrum of a fragment.
+type SpectrumBlock struct {
+	Magnitudes []float64
+	Stamp      Stamp
+	Carrier    float64
+}
+
+// Blocks of audio buffered between the device and the pipeline.
+const audioStreamBlocks = 16
+
+var sampleBlockPool = sync.Pool{
+	New: func() interface{} { return &SampleBlock{} },
+}
+
+// newSampleBlock takes a block of n samples from the pool. Whoever
+// consumes the block last releases it.
+func newSampleBlock(n int) *SampleBlock {
+	b := sampleBlockPool.Get().(*SampleBlock)
+	if cap(b.Samples) < n {
+		b.Samples = make([]int16, n)
+	}
+	b.Samples = b.Samples[:n]
+	b.Stamp = Stamp{}
+	return b
+}
+
+func (b *SampleBlock) Release() {
+	sampleBlockPool.Put(b)
+}
+
+// decodePCM converts little endian 16 bit samples from src into dst
+// and returns the number of samples converted.
+func decodePCM(dst []int16, src []byte) int {
+	n := len(src) / 2
+	if n > len(dst) {
+		n = len(dst)
+	}
+	for i := 0; i < n; i++ {
+		dst[i] = int16(binary.LittleEndian.Uint16(src[2*i:]))
+	}
+	return n
+}
+
+// encodePCM converts samples into little endian 16 bit PCM, dst must
+// have room for all of them.
+func encodePCM(dst []byte, src []int16) {
+	for i, v := range src {
+		binary.LittleEndian.PutUint16(dst[2*i:], uint16(v))
+	}
+}
+
+// Stages of the streaming pipeline. Every stage stops and closes its
+// output when its input is closed or ctx is cancelled. Sends block, so
+// a slow consumer slows down the whole pipeline up to the audio source.
+
+func filterSignal(ctx context.Context, in <-chan *SampleBlock) <-chan Fragment {
+	return filterSignalWith(ctx, in, NewBpFilter(200, bandLow, bandHigh, fragmentSize))
+}
+
+func filterSignalWith(ctx context.Context, in <-chan *SampleBlock, filter BlockFilter) <-chan Fragment {
+	out := make(chan Fragment)
+	go func() {
+		defer close(out)
+		br := &blockReader{ctx: ctx, in: in}
+		defer br.release()
+		for {
+			buf := make([]float64, fragmentSize)
+			stamp, ok := br.read(buf)
+			if !ok {
+				return
+			}
+			select {
+			case out <- Fragment{Samples: filter.FilterBuf(buf), Stamp: stamp}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// filterFragments is the pipeline stage passing fragments through the
+// filter, the notcher or one of noiseReducers. Stamps follow the delay
+// of the filter, but not before the first sample.
+func filterFragments(ctx context.Context, in <-chan Fragment, filter BlockFilter) <-chan Fragment {
+	out := make(chan Fragment)
+	delay := filterDelay(filter)
+	go func() {
+		defer close(out)
+		for {
+			var f Fragment
+			select {
+			case b, ok := <-in:
+				if !ok {
+					return
+				}
+				f = b
+			case <-ctx.Done():
+				return
+			}
+			f.Samples = filter.FilterBuf(f.Samples)
+			f.Stamp = f.Stamp.before(delay)
+			select {
+			case out <- f:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func filterSignalStream(ctx context.Context, in <-chan *SampleBlock) <-chan *SampleBlock {
+	out := make(chan *SampleBlock)
+	filter := NewBpFilter(200, bandLow, bandHigh, fragmentSize)
+	go func() {
+		defer close(out)
+		br := &blockReader{ctx: ctx, in: in}
+		defer br.release()
+		buf := make([]float64, fragmentSize)
+		for {
+			stamp, ok := br.read(buf)
+			if !ok {
+				return
+			}
+			b := newSampleBlock(fragmentSize)
+			b.Stamp = stamp
+			for i, v := range filter.FilterBuf(buf) {
+				b.Samples[i] = int16(v)
+			}
+			select {
+			case out <- b:
+			case <-ctx.Done():
+				b.Release()
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// blockReader regroups incoming sample blocks of any size into
+// buffers of the size the consumer needs.
+type blockReader struct {
+	ctx context.Context
+	in  <-chan *SampleBlock
+	cur *SampleBlock
+	pos int
+}
+
+// read fills buf completely and returns the stamp of its first sample.
+// An incomplete buffer at the end of the input is discarded.
+func (br *blockReader) read(buf []float64) (Stamp, bool) {
+	var stamp Stamp
+	for i := 0; i < len(buf); {
+		if br.cur == nil {
+			select {
+			case b, ok := <-br.in:
+				if !ok {
+					return stamp, false
+				}
+				br.cur, br.pos = b, 0
+			case <-br.ctx.Done():
+				return stamp, false
+			}
+		}
+		if i == 0 {
+			stamp = br.cur.Stamp.after(br.pos)
+		}
+		for ; i < len(buf) && br.pos < len(br.cur.Samples); i, br.pos = i+1, br.pos+1 {
+			buf[i] = float64(br.cur.Samples[br.pos])
+		}
+		if br.pos == len(br.cur.Samples) {
+			br.release()
+		}
+	}
+	return stamp, true
+}
+
+func (br *blockReader) release() {
+	if br.cur != nil {
+		br.cur.Release()
+		br.cur = nil
+	}
+}
+
+func produceSpectra(ctx context.Context, in <-chan Fragment) <-chan SpectrumBlock {
+	out := make(chan SpectrumBlock)
+	go func() {
+		defer close(out)
+		for {
+			var f Fragment
+			select {
+			case b, ok := <-in:
+				if !ok {
+					return
+				}
+				f = b
+			case <-ctx.Done():
+				return
+			}
+			hann(f.Samples)
+			rawSpectrum := ToAbs(fft.FFTReal(f.Samples))
+			select {
+			case out <- SpectrumBlock{rawSpectrum[0:222], f.Stamp, f.Carrier}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func tone(n int, bin float64) []int16 {
+	s := make([]int16, n)
+	for i := range s {
+		s[i] = int16(10000 * math.Sin(2*math.Pi*bin*float64(i)/fragmentSize))
+	}
+	return s
+}
+
+// toneSamples sends a tone in blocks of varying sizes, so that blocks
+// don't line up with fragments.
+func toneSamples(n int, bin float64) chan *SampleBlock {
+	s := tone(n, bin)
+	ch := make(chan *SampleBlock, n)
+	for i, size := 0, 1; i < n; i, size = i+size, size*3%1000+1 {
+		if i+size > n {
+			size = n - i
+		}
+		b := newSampleBlock(size)
+		copy(b.Samples, s[i:])
+		b.Stamp = Stamp{Offset: int64(i)}
+		ch <- b
+	}
+	close(ch)
+	return ch
+}
+
+// drain fails the test if ch isn't closed in time.
+func drain[T any](t *testing.T, ch <-chan T) int {
+	t.Helper()
+	n := 0
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return n
+			}
+			n++
+		case <-timeout:
+			t.Fatalf("Channel wasn't closed")
+		}
+	}
+}
+
+func TestPipelineClosesOnEndOfInput(t *testing.T) {
+	ctx := context.Background()
+	// The incomplete last block is discarded.
+	in := toneSamples(10*fragmentSize+100, 15)
+	spectra := produceSpectra(ctx, filterSignal(ctx, in))
+	var last []float64
+	n := 0
+	for sp := range spectra {
+		if sp.Stamp.Offset != int64(n*fragmentSize) {
+			t.Errorf("Spectrum %v has offset %v", n, sp.Stamp.Offset)
+		}
+		last = sp.Magnitudes
+		n++
+	}
+	if n != 10 {
+		t.Fatalf("Expected 10 spectra, got %v", n)
+	}
+	if len(last) != 222 {
+		t.Fatalf("Expected 222 bins, got %v", len(last))
+	}
+	peak := lowerMeaningfulHarmonic
+	for j := lowerMeaningfulHarmonic; j < upperMeaningfulHarmonic; j++ {
+		if last[j] > last[peak] {
+			peak = j
+		}
+	}
+	if peak != 15 {
+		t.Errorf("Expected the tone in bin 15, got %v", peak)
+	}
+
+	if n := drain(t, filterSignalStream(ctx, toneSamples(3*fragmentSize, 15))); n != 3 {
+		t.Errorf("Expected 3 blocks, got %v", n)
+	}
+}
+
+func TestBlockReader(t *testing.T) {
+	s := tone(5*fragmentSize, 10)
+	br := &blockReader{ctx: context.Background(), in: toneSamples(len(s), 10)}
+	buf := make([]float64, 300)
+	for i := 0; i+len(buf) <= len(s); i += len(buf) {
+		stamp, ok := br.read(buf)
+		if !ok {
+			t.Fatalf("Input ended early at %v", i)
+		}
+		if stamp.Offset != int64(i) {
+			t.Errorf("Buffer at %v has offset %v", i, stamp.Offset)
+		}
+		for j := range buf {
+			if buf[j] != float64(s[i+j]) {
+				t.Fatalf("Sample %v is %v, expected %v", i+j, buf[j], s[i+j])
+			}
+		}
+	}
+	if _, ok := br.read(buf); ok {
+		t.Errorf("Incomplete buffer wasn't discarded")
+	}
+}
+
+func TestDecodePCM(t *testing.T) {
+	dst := make([]int16, 4)
+	n := decodePCM(dst, []byte{0x01, 0x00, 0xff, 0xff, 0x00, 0x80, 0xff})
+	if n != 3 {
+		t.Fatalf("Expected 3 samples, got %v", n)
+	}
+	expected := []int16{1, -1, -32768, 0}
+	for i := range expected {
+		if dst[i] != expected[i] {
+			t.Errorf("Sample %v is %v, expected %v", i, dst[i], expected[i])
+		}
+	}
+}
+
+func TestPipelineStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	// The input is never closed and nobody reads the outputs,
+	// so every stage is blocked until cancellation.
+	in := make(chan *SampleBlock)
+	go func() {
+		for {
+			select {
+			case in <- newSampleBlock(100):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	spectra := produceSpectra(ctx, filterSignal(ctx, in))
+	samples := filterSignalStream(ctx, in)
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	drain(t, spectra)
+	drain(t, samples)
+}
+
+// pcmSecond is one second of a keyed tone as captured from the device.
+func pcmSecond() []byte {
+	s := tone(sampleRate, 15)
+	buf := make([]byte, 2*len(s))
+	for i, v := range s {
+		if i/(sampleRate/10)%2 == 1 {
+			v /= 100
+		}
+		binary.LittleEndian.PutUint16(buf[2*i:], uint16(v))
+	}
+	return buf
+}
+
+func cpuTime() time.Duration {
+	var ru syscall.Rusage
+	syscall.Getrusage(syscall.RUSAGE_SELF, &ru)
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// benchmarkStream runs a second of audio per iteration through the
+// stream pipeline fed by source. Stages run concurrently, so besides
+// wall time it reports cpu-ns/op, the CPU cost of a second of audio.
+func benchmarkStream(b *testing.B, source func(ctx context.Context, pcm []byte, n int) <-chan Fragment) {
+	pcm := pcmSecond()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	decoder := NewStreamDecoder(&TextSink{io.Discard})
+	b.ResetTimer()
+	start := cpuTime()
+	for sp := range produceSpectra(ctx, source(ctx, pcm, b.N)) {
+		decoder.Add(sp.Magnitudes, sp.Stamp)
+	}
+	b.ReportMetric(float64(cpuTime()-start)/float64(b.N), "cpu-ns/op")
+}
+
+func BenchmarkStreamPerSample(b *testing.B) {
+	// Transport used before blocks: a binary.Read and a channel
+	// operation for every sample.
+	benchmarkStream(b, func(ctx context.Context, pcm []byte, n int) <-chan Fragment {
+		samples := make(chan int16, 2000)
+		go func() {
+			defer close(samples)
+			for i := 0; i < n; i++ {
+				r := bytes.NewReader(pcm)
+				var v int16
+				for binary.Read(r, binary.LittleEndian, &v) == nil {
+					samples <- v
+				}
+			}
+		}()
+		out := make(chan Fragment)
+		filter := NewBpFilter(200, 7.0/fragmentSize, 30.0/fragmentSize, fragmentSize)
+		go func() {
+			defer close(out)
+			for {
+				buf := make([]float64, fragmentSize)
+				for i := range buf {
+					v, ok := <-samples
+					if !ok {
+						return
+					}
+					buf[i] = float64(v)
+				}
+				out <- Fragment{Samples: filter.FilterBuf(buf)}
+			}
+		}()
+		return out
+	})
+}
+
+func BenchmarkStreamBlocks(b *testing.B) {
+	benchmarkStream(b, func(ctx context.Context, pcm []byte, n int) <-chan Fragment {
+		blocks := make(chan *SampleBlock, audioStreamBlocks)
+		go func() {
+			defer close(blocks)
+			const period = 8192
+			for i := 0; i < n; i++ {
+				for j := 0; j < len(pcm); j += 2 * period {
+					end := j + 2*period
+					if end > len(pcm) {
+						end = len(pcm)
+					}
+					block := newSampleBlock((end - j) / 2)
+					decodePCM(block.Samples, pcm[j:end])
+					blocks <- block
+				}
+			}
+		}()
+		return filterSignal(ctx, blocks)
+	})
+}
+
+func TestFilterFragments(t *testing.T) {
+	ctx := context.Background()
+	in := make(chan Fragment)
+	go func() {
+		defer close(in)
+		for i := 0; i < 4; i++ {
+			in <- Fragment{Samples: make([]float64, fragmentSize), Stamp: blockStamp(i), Carrier: 700}
+		}
+	}()
+	i := 0
+	for f := range filterFragments(ctx, in, NewLineEnhancer(8, 4, lineEnhancerStep)) {
+		if len(f.Samples) != fragmentSize || f.Stamp != blockStamp(i) || f.Carrier != 700 {
+			t.Errorf("Fragment %v: %v samples at %v", i, len(f.Samples), f.Stamp)
+		}
+		i++
+	}
+	if i != 4 {
+		t.Errorf("Got %v fragments", i)
+	}
+}
+
+func TestFilterFragmentsDelay(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	samples := make([]float64, 10*fragmentSize)
+	for i := range samples {
+		samples[i] = float64(i%97) - 48
+	}
+	in := make(chan Fragment)
+	go func() {
+		defer close(in)
+		for i := 0; i < len(samples); i += fragmentSize {
+			f := Fragment{Samples: make([]float64, fragmentSize), Stamp: Stamp{0, start}.after(i)}
+			copy(f.Samples, samples[i:])
+			in <- f
+		}
+	}()
+	// The subtractor passes audio unchanged until it finds a carrier, its
+	// stamps locate samples of the input.
+	ss := NewSpectralSubtractor(fragmentSize)
+	i := 0
+	for f := range filterFragments(ctx, in, ss) {
+		want := Stamp{0, start}
+		if i*fragmentSize > ss.Delay() {
+			want = want.after(i * fragmentSize).after(-ss.Delay())
+		}
+		if f.Stamp != want {
+			t.Errorf("Fragment %v is at %v, want %v", i, f.Stamp, want)
+		}
+		if f.Stamp.Time.Before(start) {
+			t.Errorf("Fragment %v is before the start", i)
+		}
+		if i*fragmentSize < ss.Delay() {
+			i++
+			continue
+		}
+		for j, v := range f.Samples {
+			if k := f.Stamp.Offset + int64(j); math.Abs(v-samples[k]) > 1e-6 {
+				t.Fatalf("Sample %v of fragment %v is %v, want %v", j, i, v, samples[k])
+			}
+		}
+		i++
+	}
+	if i != 10 {
+		t.Errorf("Got %v fragments", i)
+	}
+}
@@ -1,141 +1,77 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"os"
 	"os/signal"
 	"syscall"
-
-	"unsafe"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
-/*
-#cgo LDFLAGS: -lasound
-#include <alsa/asoundlib.h>
-#include <stdint.h>
-*/
-import "C"
-
-func record(fileName string, device string) {
-	done := setupSignalHandling()
-
-	var handle *C.snd_pcm_t
-	var rc C.int
-
-	deviceCString := C.CString(device)
-	defer C.free(unsafe.Pointer(deviceCString))
-
-	rc = C.snd_pcm_open(&handle, deviceCString, C.SND_PCM_STREAM_CAPTURE, 0)
-	if rc < 0 {
-		log.Fatalf("Unable to open pcm device: %v", C.snd_strerror(rc))
-	}
-	defer C.snd_pcm_close(handle)
-	defer C.snd_pcm_drain(handle)
-
-	// Setup params
-	var params *C.snd_pcm_hw_params_t
-	rc = C.snd_pcm_hw_params_malloc(&params)
-	if rc < 0 {
-		log.Fatal("Couldn't alloc hw params")
-	}
-	defer C.snd_pcm_hw_params_free(params)
-	rc = C.snd_pcm_hw_params_any(handle, params)
-	if rc < 0 {
-		log.Fatal("Couldn't set default hw params")
-	}
-	rc = C.snd_pcm_hw_params_set_access(handle, params, C.SND_PCM_ACCESS_RW_INTERLEAVED)
-	if rc < 0 {
-		log.Fatal("Couldn't set access params")
-	}
-	rc = C.snd_pcm_hw_params_set_format(handle, params, C.SND_PCM_FORMAT_S16_LE)
-	if rc < 0 {
-		log.Fatal("Couldn't set endian format")
-	}
-	rc = C.snd_pcm_hw_params_set_channels(handle, params, 1)
-	if rc < 0 {
-		log.Fatal("Couldn't set channels")
-	}
-	var val C.uint = 44100
-	var dir C.int
-	rc = C.snd_pcm_hw_params_set_rate_near(handle, params, &val, &dir)
-	if rc < 0 {
-		log.Fatal("Couldn't set rate")
-	}
+// record writes audio from the device until interrupted.
+func record(fileName string, cfg SourceConfig, opts RecordingOptions) error {
+	return capture(cfg, func(ctx context.Context, in <-chan *SampleBlock) error {
+		r := NewRecorder(fileName, opts)
+		for b := range in {
+			err := r.Write(b)
+			b.Release()
+			if err != nil {
+				r.Close()
+				return err
+			}
+		}
+		return r.Close()
+	})
+}
 
-	var frames C.snd_pcm_uframes_t = 32
-	rc = C.snd_pcm_hw_params_set_period_size_near(handle, params, &frames, &dir)
-	if rc < 0 {
-		log.Fatal("Couldn't set period size")
-	}
+// recordVox records only segments with a carrier until interrupted.
+func recordVox(prefix string, cfg SourceConfig, opts VoxOptions, meta *RecordingMetadata) error {
+	return capture(cfg, func(ctx context.Context, in <-chan *SampleBlock) error {
+		return runVox(ctx, in, NewVox(prefix, opts, meta))
+	})
+}
 
-	rc = C.snd_pcm_hw_params(handle, params)
-	if rc < 0 {
-		log.Fatal("Couldn't set params")
+// capture runs consume on the audio stream of the device until
+// SIGINT or SIGTERM.
+func capture(cfg SourceConfig, consume func(ctx context.Context, in <-chan *SampleBlock) error) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	as, err := openSource(ctx, cfg)
+	if err != nil {
+		return err
 	}
-
-	rc = C.snd_pcm_hw_params_get_period_size(params, &frames, &dir)
-	if rc < 0 {
-		log.Fatal("Couldn't get period size")
+	defer as.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go reportLosses(as, done)
+	if err := consume(ctx, as.GetChan()); err != nil {
+		return err
 	}
-
-	var size C.snd_pcm_uframes_t
-	log.Tracef("Frames: %v\n", frames)
-	size = frames * 2
-	buffer := make([]byte, size)
-	log.Tracef("Buffer len: %v", len(buffer))
-
-	rc = C.snd_pcm_hw_params_get_period_time(params, &val, &dir)
-	if rc < 0 {
-		log.Fatal("Couldn't get period time")
+	select {
+	case err := <-as.Err():
+		return err
+	default:
+		return nil
 	}
+}
 
-	out, err := os.Create(fileName)
-	if err != nil {
-		log.Fatalf("Couldn't create output file: %v", err)
-	}
-	defer out.Close()
-loop:
+// reportLosses warns every second the source has lost samples since,
+// until done is closed.
+func reportLosses(as AudioSource, done <-chan struct{}) {
+	var stats AudioStats
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-done:
-			out.Close()
-			break loop
-		default:
-		}
-
-		var rcl C.long
-		rcl = C.snd_pcm_readi(handle, unsafe.Pointer(&buffer[0]), frames)
-		if rcl == -C.EPIPE {
-			fmt.Printf("Overrun occurred\n")
-			C.snd_pcm_prepare(handle)
-		} else if rcl < 0 {
-			fmt.Printf("Error from read: %v\n", C.snd_strerror(rc))
-		} else if rcl != C.long(frames) {
-			fmt.Printf("Short read, read %v frames\n", rc)
-		}
-		log.Tracef("rcl read: %v\n", rcl)
-		n, err := out.Write(buffer)
-		if err != nil && n < len(buffer) {
-			fmt.Printf("Failed to write: %v\n", err)
+			return
+		case <-ticker.C:
+			if s := as.Stats(); s != stats {
+				log.Warnf("Audio stream lost samples: %v overruns, %v samples dropped", s.Overruns, s.Dropped)
+				stats = s
+			}
 		}
 	}
 }
-
-func setupSignalHandling() chan bool {
-	sigs := make(chan os.Signal, 1)
-
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-
-	done := make(chan bool, 1)
-
-	go func() {
-		sig := <-sigs
-		fmt.Println()
-		fmt.Println(sig)
-		done <- true
-	}()
-
-	return done
-}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const wavHeaderSize = 44
+
+// maxWavSamples is the number of samples sizes in a WAV header can
+// count, about 13.5 hours.
+var maxWavSamples int64 = (math.MaxUint32 - (wavHeaderSize - 8)) / 2
+
+// RecordingMetadata is written as a json sidecar next to a recording.
+type RecordingMetadata struct {
+	Device     string    `json:"device,omitempty"`
+	Start      time.Time `json:"start"`
+	SampleRate int       `json:"sample_rate"`
+	Channels   int       `json:"channels"`
+	Format     string    `json:"format"`
+	Dial       float64   `json:"dial,omitempty"`      // kHz
+	Frequency  float64   `json:"frequency,omitempty"` // Hz, carrier of a vox segment.
+	Notes      string    `json:"notes,omitempty"`
+	Samples    int64     `json:"samples"`
+	Duration   float64   `json:"duration"`
+}
+
+func metadataName(recording string) string {
+	return strings.TrimSuffix(recording, filepath.Ext(recording)) + ".json"
+}
+
+// timestampedName inserts the time of the first sample and a suffix
+// between the name and the extension of the prefix.
+func timestampedName(prefix string, first Stamp, suffix string) string {
+	ext := filepath.Ext(prefix)
+	if ext == "" {
+		ext = ".raw"
+	}
+	base := strings.TrimSuffix(prefix, filepath.Ext(prefix))
+	start := first.Time
+	if start.IsZero() {
+		start = time.Unix(0, 0).Add(time.Duration(first.Offset) * time.Second / sampleRate)
+	}
+	return fmt.Sprintf("%s-%s%s%s", base, start.UTC().Round(time.Millisecond).Format("20060102T150405.000Z"), suffix, ext)
+}
+
+// RecordingFile is a file of S16_LE mono samples. Files ending with
+// .wav get a WAV header, the rest are raw like the ones record always
+// wrote. The metadata sidecar is written if meta isn't nil.
+type RecordingFile struct {
+	f       *os.File
+	name    string
+	wav     bool
+	samples int64
+	meta    *RecordingMetadata
+}
+
+func createRecordingFile(name string, meta *RecordingMetadata) (*RecordingFile, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	rf := &RecordingFile{f: f, name: name, wav: strings.EqualFold(filepath.Ext(name), ".wav")}
+	if meta != nil {
+		m := *meta
+		rf.meta = &m
+	}
+	if rf.wav {
+		err = rf.writeWavHeader()
+	}
+	if err == nil {
+		err = rf.writeMetadata()
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return rf, nil
+}
+
+// Write appends PCM bytes. A WAV file refuses samples past
+// maxWavSamples.
+func (rf *RecordingFile) Write(p []byte) (int, error) {
+	if rf.wav && rf.samples+int64(len(p)/2) > maxWavSamples {
+		return 0, fmt.Errorf("WAV file %v can't hold more than %v samples", rf.name, maxWavSamples)
+	}
+	n, err := rf.f.Write(p)
+	rf.samples += int64(n / 2)
+	return n, err
+}
+
+func (rf *RecordingFile) size() int64 {
+	return 2 * rf.samples
+}
+
+func (rf *RecordingFile) writeWavHeader() error {
+	dataSize := uint32(rf.size())
+	h := make([]byte, wavHeaderSize)
+	copy(h[0:], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:], 36+dataSize)
+	copy(h[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16) // Size of fmt chunk.
+	binary.LittleEndian.PutUint16(h[20:], 1)  // PCM.
+	binary.LittleEndian.PutUint16(h[22:], 1)  // Channels.
+	binary.LittleEndian.PutUint32(h[24:], sampleRate)
+	binary.LittleEndian.PutUint32(h[28:], sampleRate*2) // Byte rate.
+	binary.LittleEndian.PutUint16(h[32:], 2)            // Block align.
+	binary.LittleEndian.PutUint16(h[34:], 16)           // Bits per sample.
+	copy(h[36:], "data")
+	binary.LittleEndian.PutUint32(h[40:], dataSize)
+	_, err := rf.f.WriteAt(h, 0)
+	if err == nil && rf.samples == 0 {
+		_, err = rf.f.Seek(wavHeaderSize, io.SeekStart)
+	}
+	return err
+}
+
+func (rf *RecordingFile) writeMetadata() error {
+	if rf.meta == nil {
+		return nil
+	}
+	rf.meta.SampleRate = sampleRate
+	rf.meta.Channels = 1
+	rf.meta.Format = "S16_LE"
+	rf.meta.Samples = rf.samples
+	rf.meta.Duration = float64(rf.samples) / sampleRate
+	data, err := json.MarshalIndent(rf.meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(metadataName(rf.name), append(data, '\n'), 0644)
+}
+
+// Close updates sizes in the header and the metadata.
+func (rf *RecordingFile) Close() error {
+	var err error
+	if rf.wav {
+		err = rf.writeWavHeader()
+	}
+	if err == nil {
+		err = rf.writeMetadata()
+	}
+	if cerr := rf.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// RecordingOptions control splitting of long recordings.
+type RecordingOptions struct {
+	RotateEvery time.Duration // Start a new file after this much audio.
+	RotateSize  int64         // Start a new file after this many bytes of samples.
+	Timestamped bool          // Name files after the time of their first sample.
+	Metadata    *RecordingMetadata
+}
+
+// Recorder writes a stream of blocks into a file or a series of
+// files split by duration or size.
+type Recorder struct {
+	prefix string
+	opts   RecordingOptions
+	cur    *RecordingFile
+	buf    []byte
+	// A file without a limit was full, the next ones are timestamped.
+	rotated bool
+}
+
+func NewRecorder(prefix string, opts RecordingOptions) *Recorder {
+	return &Recorder{prefix: prefix, opts: opts}
+}
+
+// limit is the number of samples in a file, 0 means no limit.
+func (r *Recorder) limit() int64 {
+	var l int64
+	if r.opts.RotateEvery > 0 {
+		l = int64(r.opts.RotateEvery.Seconds() * sampleRate)
+	}
+	if s := r.opts.RotateSize / 2; s > 0 && (l == 0 || s < l) {
+		l = s
+	}
+	return l
+}
+
+// fileLimit is the number of samples in the current file, WAV files
+// are rotated before sizes in their headers overflow.
+func (r *Recorder) fileLimit() int64 {
+	l := r.limit()
+	if r.cur.wav && (l == 0 || l > maxWavSamples) {
+		l = maxWavSamples
+	}
+	return l
+}
+
+func (r *Recorder) open(first Stamp) error {
+	name := r.prefix
+	if r.opts.Timestamped || r.limit() > 0 || r.rotated {
+		name = timestampedName(r.prefix, first, "")
+	}
+	var meta *RecordingMetadata
+	if r.opts.Metadata != nil {
+		m := *r.opts.Metadata
+		m.Start = first.Time
+		meta = &m
+	}
+	f, err := createRecordingFile(name, meta)
+	if err != nil {
+		return err
+	}
+	r.cur = f
+	return nil
+}
+
+// Write appends the block splitting it between files if needed.
+func (r *Recorder) Write(b *SampleBlock) error {
+	samples := b.Samples
+	stamp := b.Stamp
+	for len(samples) > 0 {
+		if r.cur == nil {
+			if err := r.open(stamp); err != nil {
+				return err
+			}
+		}
+		n := len(samples)
+		if l := r.fileLimit(); l > 0 && int64(n) > l-r.cur.samples {
+			n = int(l - r.cur.samples)
+		}
+		if cap(r.buf) < 2*n {
+			r.buf = make([]byte, 2*n)
+		}
+		buf := r.buf[:2*n]
+		encodePCM(buf, samples[:n])
+		if _, err := r.cur.Write(buf); err != nil {
+			return err
+		}
+		samples = samples[n:]
+		stamp = stamp.after(n)
+		if l := r.fileLimit(); l > 0 && r.cur.samples >= l {
+			if !r.rotated && r.limit() == 0 && !r.opts.Timestamped {
+				log.Warnf("WAV file %v is full, recording continues in timestamped files", r.cur.name)
+			}
+			r.rotated = true
+			if err := r.Close(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Recorder) Close() error {
+	if r.cur == nil {
+		return nil
+	}
+	err := r.cur.Close()
+	r.cur = nil
+	return err
+}
+
+// openRecording opens a raw or WAV recording positioned at the first
+// sample. WAV files must hold 16 bit mono PCM. Raw files are at the
+// rate of their metadata sidecar, if there is one. Recordings at other
+// rates than the audio rate are resampled.
+func openRecording(name string) (io.ReadCloser, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	rate, err := skipWavHeader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%v: %w", name, err)
+	}
+	if rate == 0 {
+		rate = recordingRate(name)
+	}
+	if rate != sampleRate {
+		log.Infof("Resampling %v from %v Hz to %v Hz", name, rate, sampleRate)
+		return newResamplingReader(f, rate), nil
+	}
+	return f, nil
+}
+
+// recordingRate is the rate of a raw recording.
+func recordingRate(name string) int {
+	data, err := os.ReadFile(metadataName(name))
+	if err != nil || metadataName(name) == name {
+		return sampleRate
+	}
+	var meta RecordingMetadata
+	if err := json.Unmarshal(data, &meta); err != nil || meta.SampleRate <= 0 {
+		return sampleRate
+	}
+	return meta.SampleRate
+}
+
+// skipWavHeader returns the sample rate of a WAV file, it is 0 for
+// raw files.
+func skipWavHeader(f io.ReadSeeker) (int, error) {
+	riff := make([]byte, 12)
+	if _, err := io.ReadFull(f, riff); err != nil || string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		_, err := f.Seek(0, io.SeekStart)
+		return 0, err
+	}
+	chunk := make([]byte, 8)
+	rate := 0
+	for {
+		if _, err := io.ReadFull(f, chunk); err != nil {
+			return 0, fmt.Errorf("No data in WAV file")
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+		skip := size + size%2 // Chunks are padded to even size.
+		switch string(chunk[0:4]) {
+		case "data":
+			if rate == 0 {
+				return 0, fmt.Errorf("No format chunk in WAV file")
+			}
+			return rate, nil
+		case "fmt ":
+			format := make([]byte, size)
+			if _, err := io.ReadFull(f, format); err != nil || size < 16 {
+				return 0, fmt.Errorf("Broken WAV format chunk")
+			}
+			if binary.LittleEndian.Uint16(format[0:]) != 1 || binary.LittleEndian.Uint16(format[2:]) != 1 ||
+				binary.LittleEndian.Uint16(format[14:]) != 16 {
+				return 0, fmt.Errorf("Only 16 bit mono PCM WAV files are supported")
+			}
+			rate = int(binary.LittleEndian.Uint32(format[4:]))
+			if rate == 0 {
+				return 0, fmt.Errorf("Sample rate 0 isn't supported")
+			}
+			skip -= size
+		}
+		if _, err := f.Seek(skip, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+	}
+}
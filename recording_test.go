@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func recordBlocks(t *testing.T, r *Recorder, samples []int16, start time.Time) {
+	t.Helper()
+	for i := 0; i < len(samples); i += 1000 {
+		b := newSampleBlock(minInt(1000, len(samples)-i))
+		copy(b.Samples, samples[i:])
+		b.Stamp = Stamp{int64(i), start}.after(i)
+		if err := r.Write(b); err != nil {
+			t.Fatal(err)
+		}
+		b.Release()
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readRecording(t *testing.T, name string) []int16 {
+	t.Helper()
+	f, err := openRecording(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := make([]int16, len(data)/2)
+	decodePCM(samples, data)
+	return samples
+}
+
+func TestRecorderRotation(t *testing.T) {
+	dir := t.TempDir()
+	samples := tone(sampleRate*7/2, 10)
+	start := time.Date(2026, 5, 6, 7, 8, 9, 0, time.UTC)
+	r := NewRecorder(filepath.Join(dir, "band.wav"), RecordingOptions{
+		RotateEvery: time.Second,
+		Metadata:    &RecordingMetadata{Device: "hw:1", Dial: 7030, Notes: "test"},
+	})
+	recordBlocks(t, r, samples, start)
+
+	var got []int16
+	for i, name := range []string{
+		"band-20260506T070809.000Z.wav",
+		"band-20260506T070810.000Z.wav",
+		"band-20260506T070811.000Z.wav",
+		"band-20260506T070812.000Z.wav",
+	} {
+		name = filepath.Join(dir, name)
+		s := readRecording(t, name)
+		expected := sampleRate
+		if i == 3 {
+			expected = sampleRate / 2
+		}
+		if len(s) != expected {
+			t.Errorf("%v has %v samples, expected %v", name, len(s), expected)
+		}
+		got = append(got, s...)
+
+		data, err := os.ReadFile(metadataName(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var meta RecordingMetadata
+		if err := json.Unmarshal(data, &meta); err != nil {
+			t.Fatal(err)
+		}
+		if meta.Device != "hw:1" || meta.Dial != 7030 || meta.Notes != "test" || meta.SampleRate != sampleRate ||
+			meta.Samples != int64(expected) || !meta.Start.Equal(start.Add(time.Duration(i)*time.Second)) {
+			t.Errorf("Unexpected metadata of %v: %+v", name, meta)
+		}
+	}
+	if !reflect.DeepEqual(got, samples) {
+		t.Errorf("Samples changed after splitting")
+	}
+}
+
+func TestRecorderWavLimit(t *testing.T) {
+	defer func(n int64) { maxWavSamples = n }(maxWavSamples)
+	maxWavSamples = sampleRate
+	dir := t.TempDir()
+	samples := tone(sampleRate*5/2, 10)
+	start := time.Date(2026, 5, 6, 7, 8, 9, 0, time.UTC)
+	recordBlocks(t, NewRecorder(filepath.Join(dir, "band.wav"), RecordingOptions{}), samples, start)
+
+	// Files past the first one are timestamped as if rotated.
+	var got []int16
+	for _, name := range []string{"band.wav", "band-20260506T070810.000Z.wav", "band-20260506T070811.000Z.wav"} {
+		got = append(got, readRecording(t, filepath.Join(dir, name))...)
+	}
+	if !reflect.DeepEqual(got, samples) {
+		t.Errorf("Samples changed after splitting")
+	}
+
+	rf, err := createRecordingFile(filepath.Join(dir, "full.wav"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	if _, err := rf.Write(make([]byte, 2*sampleRate+2)); err == nil {
+		t.Errorf("WAV file accepted samples past its size")
+	}
+}
+
+func TestRecorderRaw(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "band.raw")
+	samples := tone(5000, 10)
+	recordBlocks(t, NewRecorder(name, RecordingOptions{}), samples, time.Now())
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 2*len(samples) || !reflect.DeepEqual(readRecording(t, name), samples) {
+		t.Errorf("Raw file has %v bytes", len(data))
+	}
+	if _, err := os.Stat(metadataName(name)); err == nil {
+		t.Errorf("Metadata written without being asked for")
+	}
+}
+
+func TestOpenRecordingRejectsStereo(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "stereo.wav")
+	rf, err := createRecordingFile(name, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf.Close()
+	data, _ := os.ReadFile(name)
+	binary.LittleEndian.PutUint16(data[22:], 2)
+	os.WriteFile(name, data, 0644)
+	if _, err := openRecording(name); err == nil {
+		t.Errorf("Stereo file was accepted")
+	}
+}
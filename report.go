@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/components"
+	"github.com/go-echarts/go-echarts/v2/opts"
+)
+
+// writeReport analyses a recording and writes a single html page
+// with charts and decoded text next to it into outDir. The echarts
+// script at the echarts path is inlined into the page so it opens
+// offline, without it the page loads the script from the go-echarts
+// host.
+func writeReport(fileName string, outDir string, echarts string) (string, error) {
+	var script []byte
+	if echarts != "" {
+		var err error
+		if script, err = os.ReadFile(echarts); err != nil {
+			return "", fmt.Errorf("Reading echarts script: %w", err)
+		}
+	}
+	_, _, spectra, err := readSpectra(fileName, nil, ReaderOptions{})
+	if err != nil {
+		return "", err
+	}
+	if len(spectra) == 0 {
+		return "", fmt.Errorf("%w: file %s is too short for a report", ErrInsufficientData, fileName)
+	}
+	detection, err := detectSignal(spectra)
+	if err != nil {
+		return "", err
+	}
+	es := measureIntervals(detection.values)
+	ditMean, dahMean, err := classifySignals(es)
+	if err != nil {
+		return "", err
+	}
+	timing := Timing{ditMean, dahMean}
+	chars, err := decodeCharacters(es)
+	if err != nil {
+		return "", err
+	}
+
+	page := components.NewPage()
+	page.PageTitle = filepath.Base(fileName)
+	page.AddCharts(
+		spectrogramChart(spectra),
+		envelopeChart(detection),
+		durationHistogram("Dits", es, timing, Dit),
+		durationHistogram("Dahs", es, timing, Dah),
+		durationHistogram("Gaps", es, timing, DitGap, CharGap, WordGap),
+	)
+	if script != nil {
+		page.JSAssets.Values = nil
+	}
+
+	var buf bytes.Buffer
+	if err := page.Render(&buf); err != nil {
+		return "", err
+	}
+	var summary bytes.Buffer
+	err = reportTemplate.Execute(&summary, newReportSummary(fileName, detection, timing, chars))
+	if err != nil {
+		return "", err
+	}
+	content := strings.Replace(buf.String(), "</body>", summary.String()+"</body>", 1)
+	if script != nil {
+		// The script can't end the script element early.
+		inline := strings.ReplaceAll(string(script), "</script", `<\/script`)
+		content = strings.Replace(content, "</head>", "<script>"+inline+"</script>\n</head>", 1)
+	}
+
+	base := strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+	outName := filepath.Join(outDir, base+".html")
+	if err := os.WriteFile(outName, []byte(content), 0644); err != nil {
+		return "", err
+	}
+	return outName, nil
+}
+
+func blockTime(i int) float64 {
+	return float64(i*fragmentSize) / sampleRate
+}
+
+func binFrequency(j int) float64 {
+	return float64(j) * sampleRate / fragmentSize
+}
+
+func blockTimeLabels(n int) []string {
+	labels := make([]string, n)
+	for i := 0; i < n; i++ {
+		labels[i] = fmt.Sprintf("%.2f", blockTime(i))
+	}
+	return labels
+}
+
+func spectrogramChart(spectra [][]float64) *charts.HeatMap {
+	bins := make([]string, 0, upperMeaningfulHarmonic-lowerMeaningfulHarmonic)
+	for j := lowerMeaningfulHarmonic; j < upperMeaningfulHarmonic; j++ {
+		bins = append(bins, fmt.Sprintf("%.0f", binFrequency(j)))
+	}
+	data := make([]opts.HeatMapData, 0, len(spectra)*len(bins))
+	var mx float64
+	for i := 0; i < len(spectra); i++ {
+		for j := lowerMeaningfulHarmonic; j < upperMeaningfulHarmonic; j++ {
+			v := spectra[i][j]
+			if v > mx {
+				mx = v
+			}
+			data = append(data, opts.HeatMapData{Value: [3]interface{}{i, j - lowerMeaningfulHarmonic, v}})
+		}
+	}
+
+	hm := charts.NewHeatMap()
+	hm.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{Title: "Spectrogram"}),
+		charts.WithInitializationOpts(opts.Initialization{Width: "1200px"}),
+		charts.WithXAxisOpts(opts.XAxis{Name: "s", Type: "category"}),
+		charts.WithYAxisOpts(opts.YAxis{Name: "Hz", Type: "category", Data: bins}),
+		charts.WithVisualMapOpts(opts.VisualMap{
+			Calculable: true,
+			Min:        0,
+			Max:        float32(mx),
+			InRange:    &opts.VisualMapInRange{Color: []string{"#f2f2f2", "#00a000"}},
+		}),
+		charts.WithDataZoomOpts(opts.DataZoom{Type: "slider"}),
+	)
+	hm.SetXAxis(blockTimeLabels(len(spectra))).AddSeries("magnitude", data)
+	return hm
+}
+
+func envelopeChart(d *Detection) *charts.Line {
+	data := make([]opts.LineData, len(d.signals))
+	for i := 0; i < len(d.signals); i++ {
+		data[i] = opts.LineData{Value: d.signals[i]}
+	}
+	threshold := d.detector.threshold()
+
+	line := charts.NewLine()
+	line.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title:    "Envelope",
+			Subtitle: fmt.Sprintf("%.0f Hz", binFrequency(d.frequency)),
+		}),
+		charts.WithInitializationOpts(opts.Initialization{Width: "1200px"}),
+		charts.WithXAxisOpts(opts.XAxis{Name: "s"}),
+		charts.WithDataZoomOpts(opts.DataZoom{Type: "slider"}),
+	)
+	line.SetXAxis(blockTimeLabels(len(d.signals))).AddSeries("envelope", data,
+		charts.WithMarkLineNameYAxisItemOpts(opts.MarkLineNameYAxisItem{
+			Name:  "threshold",
+			YAxis: threshold,
+		}),
+	)
+	return line
+}
+
+// durationHistogram counts durations of elements of the given classes.
+// Every class becomes a separate series.
+func durationHistogram(title string, es []Element, timing Timing, classes ...ElementClass) *charts.Bar {
+	mx := 0
+	for _, e := range es {
+		if e.d > mx {
+			mx = e.d
+		}
+	}
+	labels := make([]string, mx+1)
+	for d := 0; d <= mx; d++ {
+		labels[d] = fmt.Sprintf("%.0f", blockTime(d)*1000)
+	}
+
+	bar := charts.NewBar()
+	bar.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{Title: title}),
+		charts.WithXAxisOpts(opts.XAxis{Name: "ms"}),
+	)
+	bar.SetXAxis(labels)
+	for _, class := range classes {
+		counts := make([]int, mx+1)
+		for _, e := range es {
+			if timing.classify(e) == class {
+				counts[e.d]++
+			}
+		}
+		data := make([]opts.BarData, len(counts))
+		for d := 0; d < len(counts); d++ {
+			data[d] = opts.BarData{Value: counts[d]}
+		}
+		bar.AddSeries(class.String(), data, charts.WithBarChartOpts(opts.BarChart{Stack: title}))
+	}
+	return bar
+}
+
+type reportCharacter struct {
+	Start, End float64
+	Text       string
+}
+
+type reportSummary struct {
+	File       string
+	Frequency  float64
+	Threshold  float64
+	Means      []float64
+	Sigmas     []float64
+	Priors     []float64
+	Dit, Dah   float64
+	Text       string
+	Characters []reportCharacter
+}
+
+func newReportSummary(fileName string, d *Detection, timing Timing, chars []Character) *reportSummary {
+	s := &reportSummary{
+		File:      fileName,
+		Frequency: binFrequency(d.frequency),
+		Threshold: d.detector.threshold(),
+		Means:     d.detector.m,
+		Sigmas:    d.detector.sigma,
+		Priors:    d.detector.pi,
+		Dit:       blockTime(timing.dit) * 1000,
+		Dah:       blockTime(timing.dah) * 1000,
+	}
+	for _, c := range chars {
+		s.Text += c.text
+		s.Characters = append(s.Characters, reportCharacter{blockTime(c.start), blockTime(c.end), c.text})
+	}
+	return s
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`
+<div class="container"><div class="item">
+<h2>{{ .File }}</h2>
+<h3>Detector</h3>
+<table>
+<tr><td>Frequency</td><td>{{ printf "%.0f" .Frequency }} Hz</td></tr>
+<tr><td>Threshold</td><td>{{ printf "%.2f" .Threshold }}</td></tr>
+<tr><td>Means (signal, noise)</td><td>{{ range .Means }}{{ printf "%.2f" . }} {{ end }}</td></tr>
+<tr><td>Sigmas (signal, noise)</td><td>{{ range .Sigmas }}{{ printf "%.2f" . }} {{ end }}</td></tr>
+<tr><td>Priors (signal, noise)</td><td>{{ range .Priors }}{{ printf "%.3f" . }} {{ end }}</td></tr>
+<tr><td>Dit</td><td>{{ printf "%.0f" .Dit }} ms</td></tr>
+<tr><td>Dah</td><td>{{ printf "%.0f" .Dah }} ms</td></tr>
+</table>
+<h3>Text</h3>
+<pre>{{ .Text }}</pre>
+<table>
+<tr><th>Start, s</th><th>End, s</th><th>Character</th></tr>
+{{- range .Characters }}
+<tr><td>{{ printf "%.2f" .Start }}</td><td>{{ printf "%.2f" .End }}</td><td>{{ .Text }}</td></tr>
+{{- end }}
+</table>
+</div></div>
+`))
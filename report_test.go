@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteReport(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "paris.raw")
+	text := "paris paris paris"
+	if err := os.WriteFile(name, keyedPCM(encodeText(text, 6), 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := writeReport(name, dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != filepath.Join(dir, "paris.html") {
+		t.Errorf("Report is written to %v", out)
+	}
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(content)
+	for _, want := range []string{
+		"Spectrogram",
+		"Envelope",
+		"threshold",
+		`"Dits"`,
+		`"Dahs"`,
+		`"Gaps"`,
+		fmt.Sprintf("%.0f Hz", binFrequency(15)),
+		"<td>Means (signal, noise)</td>",
+		"<td>Sigmas (signal, noise)</td>",
+		"<td>Priors (signal, noise)</td>",
+		"<pre>" + text,
+		// p, .--., starts the recording.
+		fmt.Sprintf("<tr><td>%.2f</td><td>%.2f</td><td>p</td></tr>", blockTime(0), blockTime(6*(1+1+3+1+3+1+1))),
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Report doesn't contain %q", want)
+		}
+	}
+}
+
+func TestWriteReportOffline(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "paris.raw")
+	if err := os.WriteFile(name, keyedPCM(encodeText("paris paris", 6), 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	echarts := filepath.Join(dir, "echarts.min.js")
+	if err := os.WriteFile(echarts, []byte(`var echarts = "</script>";`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := writeReport(name, dir, echarts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(content)
+	if !strings.Contains(page, `<script>var echarts = "<\/script>";</script>`) {
+		t.Errorf("Script isn't inlined")
+	}
+	if strings.Contains(page, "<script src=") {
+		t.Errorf("Page loads scripts")
+	}
+	if _, err := writeReport(name, dir, filepath.Join(dir, "missing.js")); err == nil {
+		t.Errorf("Missing script is accepted")
+	}
+}
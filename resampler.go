@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Taps of every phase of the resampler filter when upsampling.
+const resamplerTaps = 32
+
+// Resampler converts audio between sample rates with a polyphase filter.
+// The ratio of the rates is reduced to L/M, the input is virtually
+// upsampled L times, low-pass filtered and decimated M times. Only the
+// phases of the filter giving output samples are computed.
+type Resampler struct {
+	l, m   int
+	phases [][]float64 // Reversed, the last tap multiplies the newest sample.
+	hist   []float64
+	next   int // Index in hist of the newest sample of the next output.
+	phase  int
+}
+
+func NewResampler(inRate, outRate int) *Resampler {
+	g := gcd(inRate, outRate)
+	l, m := outRate/g, inRate/g
+	// Downsampling needs a narrower filter, so longer phases.
+	k := resamplerTaps * ((m + l - 1) / l)
+	n := l * k
+	fc := 0.45 / float64(maxInt(l, m)) // Of the upsampled rate.
+	h := make([]float64, n)
+	var sum float64
+	for i := range h {
+		x := float64(i) - float64(n-1)/2
+		h[i] = sinc(2*fc*x) * kaiser(i, n, 8)
+		sum += h[i]
+	}
+	phases := make([][]float64, l)
+	for p := range phases {
+		phases[p] = make([]float64, k)
+		for j := 0; j < k; j++ {
+			phases[p][k-1-j] = h[p+j*l] * float64(l) / sum
+		}
+	}
+	return &Resampler{
+		l:      l,
+		m:      m,
+		phases: phases,
+		hist:   make([]float64, k-1),
+		next:   k - 1,
+	}
+}
+
+// Process appends resampled src to dst.
+func (r *Resampler) Process(dst []float64, src []float64) []float64 {
+	r.hist = append(r.hist, src...)
+	k := len(r.phases[0])
+	for r.next < len(r.hist) {
+		var y float64
+		x := r.hist[r.next-k+1 : r.next+1]
+		for j, t := range r.phases[r.phase] {
+			y += t * x[j]
+		}
+		dst = append(dst, y)
+		r.phase += r.m
+		r.next += r.phase / r.l
+		r.phase %= r.l
+	}
+	// Only the history needed by the next output is kept.
+	drop := r.next - k + 1
+	if drop > len(r.hist) {
+		drop = len(r.hist)
+	}
+	if drop > 0 {
+		n := copy(r.hist, r.hist[drop:])
+		r.hist = r.hist[:n]
+		r.next -= drop
+	}
+	return dst
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// kaiser is the i-th point of the Kaiser window of n points.
+func kaiser(i, n int, beta float64) float64 {
+	r := 2*float64(i)/float64(n-1) - 1
+	return besselI0(beta*math.Sqrt(1-r*r)) / besselI0(beta)
+}
+
+// besselI0 is the modified Bessel function of the first kind of order 0.
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; term > 1e-12*sum; k++ {
+		term *= (x / 2 / float64(k)) * (x / 2 / float64(k))
+		sum += term
+	}
+	return sum
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func clampInt16(v float64) int16 {
+	return int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, math.Round(v))))
+}
+
+// resampledSource converts audio of a source to the audio rate.
+type resampledSource struct {
+	AudioSource
+	ch     chan *SampleBlock
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func resampleSource(ctx context.Context, src AudioSource) AudioSource {
+	ctx, cancel := context.WithCancel(ctx)
+	rs := &resampledSource{src, make(chan *SampleBlock, audioStreamBlocks), cancel, make(chan struct{})}
+	go rs.run(ctx, NewResampler(src.Rate(), sampleRate))
+	return rs
+}
+
+// run resamples blocks of the source. Offsets of blocks follow stamps of
+// the source, samples it lost move them by as many samples at the audio
+// rate.
+func (rs *resampledSource) run(ctx context.Context, r *Resampler) {
+	defer close(rs.done)
+	defer close(rs.ch)
+	var in, out []float64
+	var next, offset int64 // Of the next sample of the source and of the output.
+	for {
+		var b *SampleBlock
+		var ok bool
+		select {
+		case b, ok = <-rs.AudioSource.GetChan():
+		case <-ctx.Done():
+		}
+		if !ok {
+			return
+		}
+		if lost := b.Stamp.Offset - next; lost != 0 {
+			offset += lost * int64(r.l) / int64(r.m)
+		}
+		next = b.Stamp.Offset + int64(len(b.Samples))
+		in = in[:0]
+		for _, v := range b.Samples {
+			in = append(in, float64(v))
+		}
+		t := b.Stamp.Time
+		b.Release()
+		out = r.Process(out[:0], in)
+		if len(out) == 0 {
+			continue
+		}
+		rb := newSampleBlock(len(out))
+		for i, v := range out {
+			rb.Samples[i] = clampInt16(v)
+		}
+		rb.Stamp = Stamp{offset, t}
+		offset += int64(len(out))
+		select {
+		case rs.ch <- rb:
+		case <-ctx.Done():
+			rb.Release()
+			return
+		}
+	}
+}
+
+func (rs *resampledSource) GetChan() <-chan *SampleBlock {
+	return rs.ch
+}
+
+func (rs *resampledSource) Rate() int {
+	return sampleRate
+}
+
+// Close stops the source and waits for the resampling to finish.
+func (rs *resampledSource) Close() {
+	rs.cancel()
+	rs.AudioSource.Close()
+	<-rs.done
+}
+
+// resamplingReader converts a S16_LE mono recording to the audio rate.
+type resamplingReader struct {
+	io.Closer
+	r       io.Reader
+	rs      *Resampler
+	in      []byte
+	samples []float64
+	out     []float64
+	pcm     []int16
+	pending []byte
+	pos     int
+	err     error
+}
+
+func newResamplingReader(r io.ReadCloser, rate int) *resamplingReader {
+	return &resamplingReader{
+		Closer: r,
+		r:      r,
+		rs:     NewResampler(rate, sampleRate),
+		in:     make([]byte, 2*fragmentSize),
+	}
+}
+
+func (rr *resamplingReader) Read(b []byte) (int, error) {
+	for rr.pos == len(rr.pending) && rr.err == nil {
+		n, err := io.ReadFull(rr.r, rr.in)
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		rr.err = err
+		rr.samples = rr.samples[:0]
+		for i := 0; i+1 < n; i += 2 {
+			rr.samples = append(rr.samples, float64(int16(binary.LittleEndian.Uint16(rr.in[i:]))))
+		}
+		rr.out = rr.rs.Process(rr.out[:0], rr.samples)
+		rr.pcm = rr.pcm[:0]
+		for _, v := range rr.out {
+			rr.pcm = append(rr.pcm, clampInt16(v))
+		}
+		if cap(rr.pending) < 2*len(rr.pcm) {
+			rr.pending = make([]byte, 2*len(rr.pcm))
+		}
+		rr.pending, rr.pos = rr.pending[:2*len(rr.pcm)], 0
+		encodePCM(rr.pending, rr.pcm)
+	}
+	if rr.pos == len(rr.pending) {
+		return 0, rr.err
+	}
+	n := copy(b, rr.pending[rr.pos:])
+	rr.pos += n
+	return n, nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// sine returns n samples of a tone of frequency f Hz at the rate.
+func sine(n int, f float64, rate int, amplitude float64) []float64 {
+	s := make([]float64, n)
+	for i := range s {
+		s[i] = amplitude * math.Sin(2*math.Pi*f*float64(i)/float64(rate))
+	}
+	return s
+}
+
+func TestResamplerTone(t *testing.T) {
+	in := sine(48000, 1000, 48000, 10000)
+	out := NewResampler(48000, sampleRate).Process(nil, in)
+	if len(out) != sampleRate {
+		t.Fatalf("Got %v samples, want %v", len(out), sampleRate)
+	}
+	if a := goertzel(out[1000:], 1000); math.Abs(a-10000) > 20 {
+		t.Errorf("Tone amplitude is %v, want 10000", a)
+	}
+}
+
+func TestResamplerDownsampling(t *testing.T) {
+	// 6 kHz is above the Nyquist frequency of 8 kHz and would alias to 2 kHz.
+	in := sine(sampleRate, 1000, sampleRate, 10000)
+	for i, v := range sine(sampleRate, 6000, sampleRate, 10000) {
+		in[i] += v
+	}
+	out := NewResampler(sampleRate, 8000).Process(nil, in)
+	if len(out) != 8000 {
+		t.Fatalf("Got %v samples, want 8000", len(out))
+	}
+	// goertzel works at the audio rate, scale the frequencies.
+	scale := float64(sampleRate) / 8000
+	if a := goertzel(out[500:], 1000*scale); math.Abs(a-10000) > 20 {
+		t.Errorf("Tone amplitude is %v, want 10000", a)
+	}
+	if a := goertzel(out[500:], 2000*scale); a > 10 {
+		t.Errorf("Alias amplitude is %v", a)
+	}
+}
+
+func TestResamplerBlocks(t *testing.T) {
+	in := sine(20000, 700, 48000, 10000)
+	want := NewResampler(48000, sampleRate).Process(nil, in)
+	r := NewResampler(48000, sampleRate)
+	var got []float64
+	for i, size := 0, 1; i < len(in); i, size = i+size, size*3%1000+1 {
+		got = r.Process(got, in[i:minInt(i+size, len(in))])
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Got %v samples in blocks, %v at once", len(got), len(want))
+	}
+	for i := range got {
+		if math.Abs(got[i]-want[i]) > 1e-9 {
+			t.Fatalf("Sample %v is %v in blocks, %v at once", i, got[i], want[i])
+		}
+	}
+}
+
+// writePCM writes a raw recording of the samples.
+func writePCM(t *testing.T, name string, s []float64) {
+	t.Helper()
+	pcm := make([]int16, len(s))
+	for i, v := range s {
+		pcm[i] = clampInt16(v)
+	}
+	b := make([]byte, 2*len(pcm))
+	encodePCM(b, pcm)
+	if err := os.WriteFile(name, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readAll(t *testing.T, name string) []float64 {
+	t.Helper()
+	f, err := openRecording(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := make([]float64, len(data)/2)
+	for i := range s {
+		s[i] = float64(int16(binary.LittleEndian.Uint16(data[2*i:])))
+	}
+	return s
+}
+
+func TestOpenRecordingResamples(t *testing.T) {
+	dir := t.TempDir()
+	in := sine(48000, 1000, 48000, 10000)
+
+	raw := filepath.Join(dir, "tone.raw")
+	writePCM(t, raw, in)
+	meta, _ := os.Create(metadataName(raw))
+	meta.WriteString(`{"sample_rate": 48000}`)
+	meta.Close()
+
+	wav := filepath.Join(dir, "tone.wav")
+	rf, err := createRecordingFile(wav, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf.Close()
+	header, _ := os.ReadFile(wav)
+	binary.LittleEndian.PutUint32(header[24:], 48000)
+	binary.LittleEndian.PutUint32(header[28:], 2*48000)
+	binary.LittleEndian.PutUint32(header[40:], uint32(2*len(in)))
+	writePCM(t, wav, in)
+	data, _ := os.ReadFile(wav)
+	os.WriteFile(wav, append(header, data...), 0644)
+
+	for _, name := range []string{raw, wav} {
+		out := readAll(t, name)
+		if len(out) != sampleRate {
+			t.Errorf("%v: got %v samples, want %v", name, len(out), sampleRate)
+			continue
+		}
+		if a := goertzel(out[1000:], 1000); math.Abs(a-10000) > 20 {
+			t.Errorf("%v: tone amplitude is %v, want 10000", name, a)
+		}
+	}
+}
+
+// blockSource is an AudioSource sending prepared blocks.
+type blockSource struct {
+	ch     chan *SampleBlock
+	rate   int
+	closed bool
+}
+
+func (bs *blockSource) GetChan() <-chan *SampleBlock { return bs.ch }
+func (bs *blockSource) Err() <-chan error            { return nil }
+func (bs *blockSource) Rate() int                    { return bs.rate }
+func (bs *blockSource) Stats() AudioStats            { return AudioStats{} }
+func (bs *blockSource) Close()                       { bs.closed = true }
+
+func TestResampleSource(t *testing.T) {
+	in := sine(48000, 1000, 48000, 10000)
+	bs := &blockSource{ch: make(chan *SampleBlock, 100), rate: 48000}
+	// The source loses the eleventh block, 882 samples at the audio rate.
+	const lost = 10
+	for i := 0; i < len(in); i += 960 {
+		if i == lost*960 {
+			continue
+		}
+		b := newSampleBlock(960)
+		for j := range b.Samples {
+			b.Samples[j] = clampInt16(in[i+j])
+		}
+		b.Stamp = Stamp{Offset: int64(i)}
+		bs.ch <- b
+	}
+	close(bs.ch)
+
+	src := resampleSource(context.Background(), bs)
+	if src.Rate() != sampleRate {
+		t.Errorf("Rate is %v", src.Rate())
+	}
+	var out []float64
+	var offset int64
+	jumps := 0
+	for b := range src.GetChan() {
+		if b.Stamp.Offset == offset+882 && jumps == 0 {
+			offset += 882
+			jumps++
+		}
+		if b.Stamp.Offset != offset {
+			t.Errorf("Block offset is %v, want %v", b.Stamp.Offset, offset)
+		}
+		offset += int64(len(b.Samples))
+		for _, v := range b.Samples {
+			out = append(out, float64(v))
+		}
+		b.Release()
+	}
+	src.Close()
+	if !bs.closed {
+		t.Errorf("Source wasn't closed")
+	}
+	if jumps != 1 || len(out) != sampleRate-882 || offset != sampleRate {
+		t.Fatalf("Got %v samples ending at %v after %v gaps, want %v ending at %v", len(out), offset, jumps, sampleRate-882, sampleRate)
+	}
+	if a := goertzel(out[1000:lost*882], 1000); math.Abs(a-10000) > 20 {
+		t.Errorf("Tone amplitude is %v, want 10000", a)
+	}
+}
+
+func TestResampleSourceClose(t *testing.T) {
+	bs := &blockSource{ch: make(chan *SampleBlock), rate: 48000}
+	src := resampleSource(context.Background(), bs)
+	src.Close()
+	// Resampling has finished when Close returns.
+	select {
+	case _, ok := <-src.GetChan():
+		if ok {
+			t.Errorf("Got a block after Close")
+		}
+	default:
+		t.Errorf("Resampling goes on after Close")
+	}
+}
+
+func benchmarkResampler(b *testing.B, in, out int) {
+	s := sine(in, 1000, in, 10000)
+	r := NewResampler(in, out)
+	var dst []float64
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		dst = r.Process(dst[:0], s)
+	}
+	// Every iteration is a second of audio.
+	b.ReportMetric(time.Since(start).Seconds()*1000/float64(b.N), "ms/audio-s")
+}
+
+func BenchmarkResample48k(b *testing.B) {
+	benchmarkResampler(b, 48000, sampleRate)
+}
+
+func BenchmarkResampleTo8k(b *testing.B) {
+	benchmarkResampler(b, sampleRate, 8000)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+const clientQueueSize = 256
+
+// Server publishes decoded events to websocket clients and serves
+// a page showing them.
+type Server struct {
+	mu       sync.Mutex
+	clients  map[*serverClient]struct{}
+	status   StatusEvent
+	upgrader websocket.Upgrader
+}
+
+type serverClient struct {
+	conn *websocket.Conn
+	send chan []byte
+}
+
+func NewServer() *Server {
+	return &Server{
+		clients: make(map[*serverClient]struct{}),
+		status:  StatusEvent{Type: "status"},
+	}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handlePage)
+	mux.HandleFunc("/ws", s.handleWebSocket)
+	mux.HandleFunc("/api/status", s.handleStatus)
+	return mux
+}
+
+// ListenAndServe serves until ctx is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	srv := &http.Server{Addr: addr, Handler: s.Handler()}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+		s.closeClients()
+	}()
+	log.Infof("Listening on %v", addr)
+	err := srv.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) handlePage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(serverPage))
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	status := s.status
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Warnf("Failed to write status: %v", err)
+	}
+}
+
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Warnf("Websocket upgrade failed: %v", err)
+		return
+	}
+	c := &serverClient{conn, make(chan []byte, clientQueueSize)}
+	s.mu.Lock()
+	// The status is queued before the client is registered, so broadcast
+	// and closeClients can't close the queue before it.
+	if status, err := json.Marshal(s.status); err != nil {
+		log.Warnf("Failed to marshal status: %v", err)
+	} else {
+		c.send <- status
+	}
+	s.clients[c] = struct{}{}
+	s.mu.Unlock()
+	log.Infof("Client connected: %v", r.RemoteAddr)
+
+	go c.writeLoop()
+	// Clients don't send anything, reading detects closed connections.
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	s.remove(c)
+	log.Infof("Client disconnected: %v", r.RemoteAddr)
+}
+
+func (c *serverClient) writeLoop() {
+	for msg := range c.send {
+		if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			break
+		}
+	}
+	c.conn.Close()
+}
+
+func (s *Server) remove(c *serverClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[c]; ok {
+		delete(s.clients, c)
+		close(c.send)
+	}
+}
+
+func (s *Server) closeClients() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		delete(s.clients, c)
+		close(c.send)
+	}
+}
+
+// broadcast sends the event to every client. Clients that don't keep up
+// are disconnected rather than slowing down decoding.
+func (s *Server) broadcast(v interface{}) {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		log.Warnf("Failed to marshal event: %v", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		select {
+		case c.send <- msg:
+		default:
+			log.Warnf("Dropping slow client")
+			delete(s.clients, c)
+			close(c.send)
+		}
+	}
+}
+
+func (s *Server) Element(e ElementEvent) {
+	s.broadcast(e)
+}
+
+func (s *Server) Character(c CharacterEvent) {
+	s.broadcast(c)
+}
+
+func (s *Server) Status(st StatusEvent) {
+	s.mu.Lock()
+	s.status = st
+	s.mu.Unlock()
+	s.broadcast(st)
+}
+
+func (s *Server) Spectrum(sp SpectrumEvent) {
+	s.broadcast(sp)
+}
+
+const serverPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>cw-server</title>
+<style>
+body { font-family: sans-serif; background: #f2f2f2; }
+#status { margin: 8px 0; }
+#text { font-family: monospace; font-size: 20px; white-space: pre-wrap; background: white; padding: 8px; min-height: 120px; }
+canvas { background: black; margin-top: 8px; }
+</style>
+</head>
+<body>
+<div id="status">Connecting...</div>
+<canvas id="waterfall" width="600" height="300"></canvas>
+<div id="text"></div>
+<script>
+const status = document.getElementById("status");
+const text = document.getElementById("text");
+const canvas = document.getElementById("waterfall");
+const ctx = canvas.getContext("2d");
+let peak = 1;
+
+function drawRow(sp) {
+	ctx.drawImage(canvas, 0, 0, canvas.width, canvas.height - 1, 0, 1, canvas.width, canvas.height - 1);
+	const w = canvas.width / sp.magnitudes.length;
+	peak = Math.max(peak * 0.999, ...sp.magnitudes);
+	sp.magnitudes.forEach((m, i) => {
+		const v = Math.floor(255 * m / peak);
+		ctx.fillStyle = "rgb(0," + v + ",0)";
+		ctx.fillRect(i * w, 0, w + 1, 1);
+	});
+}
+
+function connect() {
+	const ws = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/ws");
+	ws.onmessage = (msg) => {
+		const e = JSON.parse(msg.data);
+		if (e.type === "character") {
+			text.textContent += e.text;
+		} else if (e.type === "status") {
+			status.textContent = e.frequency.toFixed(0) + " Hz, " + e.wpm.toFixed(1) + " WPM, SNR " + e.snr.toFixed(1) + " dB";
+		} else if (e.type === "spectrum") {
+			drawRow(e);
+		}
+	};
+	ws.onclose = () => {
+		status.textContent = "Disconnected, reconnecting...";
+		setTimeout(connect, 1000);
+	};
+}
+connect();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestServerStatus(t *testing.T) {
+	s := NewServer()
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	want := StatusEvent{"status", 1.5, 20, 30, 700}
+	s.Status(want)
+	resp, err := http.Get(ts.URL + "/api/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content type is %v", ct)
+	}
+	var got StatusEvent
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("Status is %+v, want %+v", got, want)
+	}
+}
+
+// readEvent decodes the next message of the websocket into v.
+func readEvent(t *testing.T, conn *websocket.Conn, v interface{}) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(msg, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerWebSocket(t *testing.T) {
+	s := NewServer()
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	s.Status(StatusEvent{"status", 1, 20, 30, 700})
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+	var conns []*websocket.Conn
+	for i := 0; i < 2; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		// The current status comes first, the client is registered by then.
+		var st StatusEvent
+		readEvent(t, conn, &st)
+		if st.Type != "status" || st.Wpm != 20 {
+			t.Errorf("Client %v: first event is %+v", i, st)
+		}
+		conns = append(conns, conn)
+	}
+
+	// A client that doesn't read its queue is dropped, others keep getting
+	// events.
+	slow := &serverClient{send: make(chan []byte, clientQueueSize)}
+	for len(slow.send) < clientQueueSize {
+		slow.send <- nil
+	}
+	s.mu.Lock()
+	s.clients[slow] = struct{}{}
+	s.mu.Unlock()
+	s.Character(CharacterEvent{Type: "character", Text: "a"})
+	s.mu.Lock()
+	_, registered := s.clients[slow]
+	s.mu.Unlock()
+	if registered {
+		t.Fatalf("Slow client isn't dropped")
+	}
+	for range slow.send {
+	}
+
+	for i, conn := range conns {
+		var c CharacterEvent
+		readEvent(t, conn, &c)
+		if c.Type != "character" || c.Text != "a" {
+			t.Errorf("Client %v got %+v", i, c)
+		}
+	}
+}
+
+func TestServerShutdownClosesClients(t *testing.T) {
+	s := NewServer()
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var st StatusEvent
+	readEvent(t, conn, &st)
+	s.closeClients()
+	// Events after shutdown don't panic on closed queues.
+	s.Status(StatusEvent{Type: "status"})
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Errorf("Connection is open after shutdown")
+	}
+}
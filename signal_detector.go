@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"math"
+
+	log "github.com/sirupsen/logrus"
 )
 
 // -------------- KMeans Signal Detector ---------------
@@ -239,8 +241,48 @@ func (sd *EMSingleFrequencyDetector) isSignal(sample float64) bool {
 	return rk[0] > rk[1]
 }
 
-func classifyEMFromSingleFrequency(signals []float64) (sd *EMSingleFrequencyDetector) {
+// threshold finds the magnitude between the noise and the signal means
+// where the detector switches its decision.
+func (sd *EMSingleFrequencyDetector) threshold() float64 {
+	lo := math.Min(sd.m[0], sd.m[1])
+	hi := math.Max(sd.m[0], sd.m[1])
+	for i := 0; i < 64; i++ {
+		mid := (lo + hi) / 2
+		if sd.isSignal(mid) {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// midpoint is the magnitude half way between the noise and the signal
+// means. Averaging makes both equally narrow, so it is the threshold for
+// averaged magnitudes.
+func (sd *EMSingleFrequencyDetector) midpoint() float64 {
+	return (sd.m[0] + sd.m[1]) / 2
+}
+
+// maxSNR is the SNR in dB of a carrier over digital silence.
+const maxSNR = 120
+
+// snr returns ratio of the signal and the noise means in dB. The noise
+// mean of a clean tone can be zero, the ratio is capped at maxSNR.
+func (sd *EMSingleFrequencyDetector) snr() float64 {
+	signal := math.Max(sd.m[0], sd.m[1])
+	if signal <= 0 {
+		return 0
+	}
+	noise := math.Max(math.Min(sd.m[0], sd.m[1]), signal*math.Pow(10, -maxSNR/20.0))
+	return 20 * math.Log10(signal/noise)
+}
+
+func classifyEMFromSingleFrequency(signals []float64) (sd *EMSingleFrequencyDetector, err error) {
 	n := len(signals)
+	if n < 2 {
+		return nil, fmt.Errorf("%w: %v samples for signal detector", ErrInsufficientData, n)
+	}
 
 	r := make([][]float64, 2)
 
@@ -260,6 +302,10 @@ func classifyEMFromSingleFrequency(signals []float64) (sd *EMSingleFrequencyDete
 	allMin, _ := segmentMin(signals)
 	allMax, _ := segmentMax(signals)
 
+	if allMin == allMax {
+		return nil, fmt.Errorf("%w: constant magnitude %v", ErrNoSignal, allMin)
+	}
+
 	middle := (allMin + allMax) / 2
 
 	m[0] = allMax
@@ -274,7 +320,7 @@ func classifyEMFromSingleFrequency(signals []float64) (sd *EMSingleFrequencyDete
 		}
 	}
 	sigma[0] /= float64(aboveMiddle)
-	fmt.Printf("aboveMiddle: %v\n", aboveMiddle)
+	log.Debugf("aboveMiddle: %v", aboveMiddle)
 
 	belowMiddle := 0
 	sigma[1] = 0
@@ -285,7 +331,7 @@ func classifyEMFromSingleFrequency(signals []float64) (sd *EMSingleFrequencyDete
 		}
 	}
 	sigma[1] /= float64(belowMiddle)
-	fmt.Printf("belowMiddle: %v\n", belowMiddle)
+	log.Debugf("belowMiddle: %v", belowMiddle)
 
 	pi[0] = 0.5
 	pi[1] = 0.5
@@ -301,11 +347,11 @@ func classifyEMFromSingleFrequency(signals []float64) (sd *EMSingleFrequencyDete
 	*/
 	//updateStep(n, r, signals, m, sigma, pi)
 	assignmentStep(n, r, signals, m, sigma, pi)
-	fmt.Printf("Initial assignment\n")
-	fmt.Printf("r: %v\n", r)
-	fmt.Printf("m: %v\n", m)
-	fmt.Printf("sigma: %v\n", sigma)
-	fmt.Printf("pi: %v\n", pi)
+	log.Debugf("Initial assignment")
+	log.Tracef("r: %v", r)
+	log.Debugf("m: %v", m)
+	log.Debugf("sigma: %v", sigma)
+	log.Debugf("pi: %v", pi)
 	stepCount := 0
 	for {
 		copy(oldM, m)
@@ -342,8 +388,10 @@ func classifyEMFromSingleFrequency(signals []float64) (sd *EMSingleFrequencyDete
 		}
 		stepCount++
 	}
-	return &EMSingleFrequencyDetector{m, sigma, pi}
-
+	if math.IsNaN(m[0]) || math.IsNaN(m[1]) || m[0] <= m[1] {
+		return nil, fmt.Errorf("%w: signal and noise are not separable, means %v", ErrNoSignal, m)
+	}
+	return &EMSingleFrequencyDetector{m, sigma, pi}, nil
 }
 
 func assignmentStep(n int, r [][]float64, x []float64, m []float64, sigma []float64, pi []float64) {
@@ -473,6 +521,7 @@ func segmentMax(seg []float64) (mx float64, id int) {
 	for i := 1; i < len(seg); i++ {
 		if seg[i] > mx {
 			mx = seg[i]
+			id = i
 		}
 	}
 	return mx, id
@@ -495,7 +544,7 @@ func correlationMatrix(spectra [][]float64) (res [][]float64) {
 				sqb += spectra[i][b] * spectra[i][b]
 				s += spectra[i][a] * spectra[i][b]
 			}
-			res[a][b] = (float64(n)*s - sa*sb) / (float64(n)*sqa - sa*sa) / (float64(n)*sqb - sb*sb)
+			res[a][b] = (float64(n)*s - sa*sb) / math.Sqrt((float64(n)*sqa-sa*sa)*(float64(n)*sqb-sb*sb))
 		}
 	}
 	return res
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// bimodal generates keyed and silent magnitudes, every fourth sample is keyed.
+func bimodal(n int, signal, noise, spread float64) ([]float64, []bool) {
+	r := rand.New(rand.NewSource(1))
+	xs := make([]float64, n)
+	labels := make([]bool, n)
+	for i := 0; i < n; i++ {
+		labels[i] = i%4 == 0
+		if labels[i] {
+			xs[i] = signal + r.NormFloat64()*spread
+		} else {
+			xs[i] = math.Abs(noise + r.NormFloat64()*spread)
+		}
+	}
+	return xs, labels
+}
+
+func countErrors(xs []float64, labels []bool, isSignal func(float64) bool) int {
+	errs := 0
+	for i := range xs {
+		if isSignal(xs[i]) != labels[i] {
+			errs++
+		}
+	}
+	return errs
+}
+
+func TestClassifyFromSingleFrequency(t *testing.T) {
+	xs, labels := bimodal(1000, 100, 10, 5)
+	sd := classifyFromSingleFrequency(xs)
+	if math.Abs(sd.signal-100) > 2 || math.Abs(sd.noise-10) > 2 {
+		t.Errorf("Means: %v, %v", sd.signal, sd.noise)
+	}
+	if errs := countErrors(xs, labels, sd.isSignal); errs > 0 {
+		t.Errorf("Misclassified %v samples", errs)
+	}
+}
+
+func TestClassifyEMFromSingleFrequency(t *testing.T) {
+	xs, labels := bimodal(1000, 100, 10, 5)
+	sd, err := classifyEMFromSingleFrequency(xs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(sd.m[0]-100) > 2 || math.Abs(sd.m[1]-10) > 2 {
+		t.Errorf("Means: %v", sd.m)
+	}
+	if math.Abs(sd.pi[0]-0.25) > 0.02 {
+		t.Errorf("Priors: %v", sd.pi)
+	}
+	if errs := countErrors(xs, labels, sd.isSignal); errs > 0 {
+		t.Errorf("Misclassified %v samples", errs)
+	}
+	if th := sd.threshold(); th < 10 || th > 100 || sd.isSignal(th-0.01) || !sd.isSignal(th+0.01) {
+		t.Errorf("Threshold: %v", th)
+	}
+}
+
+func TestClassifyEMFromSingleFrequencyErrors(t *testing.T) {
+	if _, err := classifyEMFromSingleFrequency([]float64{1}); !errors.Is(err, ErrInsufficientData) {
+		t.Errorf("Single sample: %v", err)
+	}
+	if _, err := classifyEMFromSingleFrequency([]float64{3, 3, 3, 3}); !errors.Is(err, ErrNoSignal) {
+		t.Errorf("Constant signal: %v", err)
+	}
+}
+
+func TestClassifySegments(t *testing.T) {
+	xs, labels := bimodal(400, 100, 10, 5)
+	segments := make([][]float64, len(xs))
+	for i := range xs {
+		segments[i] = []float64{xs[i] / 2, xs[i], xs[i] / 2}
+	}
+	sd := classifySegments(segments)
+	for i := range segments {
+		if sd.isSignal(segments[i]) != labels[i] {
+			t.Errorf("Segment %v misclassified", i)
+		}
+	}
+}
+
+func TestSegmentMinMax(t *testing.T) {
+	seg := []float64{3, -1, 7, 2}
+	if v, id := segmentMin(seg); v != -1 || id != 1 {
+		t.Errorf("segmentMin: %v, %v", v, id)
+	}
+	if v, id := segmentMax(seg); v != 7 || id != 2 {
+		t.Errorf("segmentMax: %v, %v", v, id)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	spotterWords    = 8                // Recent words searched for CQ.
+	spotRepeatDelay = 10 * time.Minute // Same callsign isn't spotted more often.
+	// Dits after the end of a character a word ends without a space.
+	// Letters are decoded after the gap following them, the next letter
+	// of the word can come a gap and the longest letter later.
+	spotterWordTimeout = 30
+)
+
+var callsignRegexp = regexp.MustCompile(`^([A-Z0-9]{1,4}/)?([0-9]?[A-Z]{1,2}|[A-Z][0-9])[0-9]{1,2}[A-Z]{1,4}(/[A-Z0-9]{1,4})?$`)
+
+func isCallsign(w string) bool {
+	return callsignRegexp.MatchString(strings.ToUpper(w))
+}
+
+// Spot is a decoded callsign in the DX cluster sense.
+type Spot struct {
+	Spotter   string
+	Frequency float64 // kHz
+	Call      string
+	Snr       float64
+	Wpm       float64
+	Kind      string // CQ or DE
+	Time      time.Time
+}
+
+// String formats the spot the way reverse beacon network skimmers do.
+func (s Spot) String() string {
+	return fmt.Sprintf("DX de %-10s %8.1f  %-12s CW %5.0f dB %3.0f WPM  %-6s %sZ",
+		s.Spotter+":", s.Frequency, s.Call, s.Snr, s.Wpm, s.Kind, s.Time.UTC().Format("1504"))
+}
+
+// Spotter extracts callsigns from decoded text. It spots a callsign
+// following DE and a callsign sent after a recent CQ. Text of every
+// carrier frequency is followed separately, channels of a channelizer
+// share the spotter.
+type Spotter struct {
+	call    string
+	dial    float64 // kHz, added to audio frequency of the carrier.
+	publish func(Spot)
+	now     func() time.Time
+
+	channels map[float64]*spotterChannel
+	spotted  map[string]time.Time
+}
+
+// spotterChannel is the text decoded on a frequency.
+type spotterChannel struct {
+	frequency float64 // Hz
+	word      []byte
+	wordEnd   float64 // Seconds, end of the last character of the word.
+	words     []string
+	status    StatusEvent
+}
+
+func NewSpotter(call string, dial float64, publish func(Spot)) *Spotter {
+	return &Spotter{
+		call:     call,
+		dial:     dial,
+		publish:  publish,
+		now:      time.Now,
+		channels: make(map[float64]*spotterChannel),
+		spotted:  make(map[string]time.Time),
+	}
+}
+
+func (sp *Spotter) channel(frequency float64) *spotterChannel {
+	ch, ok := sp.channels[frequency]
+	if !ok {
+		ch = &spotterChannel{frequency: frequency}
+		sp.channels[frequency] = ch
+	}
+	return ch
+}
+
+func (sp *Spotter) Element(e ElementEvent) {
+}
+
+// Status also ends words when nothing has been decoded on their
+// frequency for spotterWordTimeout dits, the space after a callsign
+// ending a transmission can be lost to the squelch.
+func (sp *Spotter) Status(s StatusEvent) {
+	sp.channel(s.Frequency).status = s
+	for _, ch := range sp.sortedChannels() {
+		if wpm := ch.status.Wpm; wpm > 0 && s.Time-ch.wordEnd > spotterWordTimeout*1.2/wpm {
+			sp.flush(ch)
+		}
+	}
+}
+
+func (sp *Spotter) Character(c CharacterEvent) {
+	ch := sp.channel(c.Frequency)
+	if c.Text != " " {
+		ch.word = append(ch.word, c.Text...)
+		ch.wordEnd = c.End
+		return
+	}
+	sp.flush(ch)
+}
+
+// Flush handles words decoded so far, at the end of the input too.
+func (sp *Spotter) Flush() {
+	for _, ch := range sp.sortedChannels() {
+		sp.flush(ch)
+	}
+}
+
+// sortedChannels returns channels in the order of frequencies, so
+// spots are published in the same order every time.
+func (sp *Spotter) sortedChannels() []*spotterChannel {
+	chs := make([]*spotterChannel, 0, len(sp.channels))
+	for _, ch := range sp.channels {
+		chs = append(chs, ch)
+	}
+	sort.Slice(chs, func(i, j int) bool { return chs[i].frequency < chs[j].frequency })
+	return chs
+}
+
+func (sp *Spotter) flush(ch *spotterChannel) {
+	if len(ch.word) == 0 {
+		return
+	}
+	w := strings.ToUpper(string(ch.word))
+	ch.word = ch.word[0:0]
+	sp.addWord(ch, w)
+}
+
+func (sp *Spotter) addWord(ch *spotterChannel, w string) {
+	ch.words = append(ch.words, w)
+	if len(ch.words) > spotterWords {
+		ch.words = ch.words[len(ch.words)-spotterWords:]
+	}
+	if !isCallsign(w) {
+		return
+	}
+	kind := ""
+	if len(ch.words) > 1 && ch.words[len(ch.words)-2] == "DE" {
+		kind = "DE"
+	}
+	// CQ counts only if no other callsign was sent after it.
+	for i := len(ch.words) - 2; i >= 0; i-- {
+		if ch.words[i] == "CQ" {
+			kind = "CQ"
+			break
+		}
+		if ch.words[i] != w && isCallsign(ch.words[i]) {
+			break
+		}
+	}
+	if kind == "" {
+		return
+	}
+	now := sp.now()
+	if last, ok := sp.spotted[w]; ok && now.Sub(last) < spotRepeatDelay {
+		return
+	}
+	// Callsigns that can be spotted again aren't needed, so running for
+	// days doesn't accumulate every callsign heard.
+	for call, last := range sp.spotted {
+		if now.Sub(last) >= spotRepeatDelay {
+			delete(sp.spotted, call)
+		}
+	}
+	sp.spotted[w] = now
+	sp.publish(Spot{
+		Spotter:   sp.call,
+		Frequency: sp.dial + ch.frequency/1000,
+		Call:      w,
+		Snr:       ch.status.Snr,
+		Wpm:       ch.status.Wpm,
+		Kind:      kind,
+		Time:      now,
+	})
+}
+
+// TelnetServer sends spots to connected telnet clients line by line,
+// like a DX cluster node.
+type TelnetServer struct {
+	mu      sync.Mutex
+	conns   map[net.Conn]bool        // Connected clients, logged in or not.
+	clients map[net.Conn]chan string // Logged in clients.
+	closed  bool
+}
+
+func NewTelnetServer() *TelnetServer {
+	return &TelnetServer{conns: make(map[net.Conn]bool), clients: make(map[net.Conn]chan string)}
+}
+
+// ListenAndServe accepts clients until ctx is cancelled.
+func (ts *TelnetServer) ListenAndServe(ctx context.Context, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Infof("Telnet listening on %v", addr)
+	return ts.Serve(ctx, l)
+}
+
+// Serve accepts clients from l until ctx is cancelled, then it
+// disconnects them.
+func (ts *TelnetServer) Serve(ctx context.Context, l net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+		ts.closeClients()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go ts.serve(conn)
+	}
+}
+
+func (ts *TelnetServer) serve(conn net.Conn) {
+	ts.mu.Lock()
+	if ts.closed {
+		ts.mu.Unlock()
+		conn.Close()
+		return
+	}
+	ts.conns[conn] = true
+	ts.mu.Unlock()
+	defer func() {
+		ts.mu.Lock()
+		delete(ts.conns, conn)
+		ts.mu.Unlock()
+		conn.Close()
+	}()
+	// Logging programs send their callsign on login, it is not checked.
+	fmt.Fprintf(conn, "Please enter your call: ")
+	reader := bufio.NewReader(conn)
+	call, err := reader.ReadString('\n')
+	if err != nil {
+		return
+	}
+	call = strings.TrimSpace(call)
+	log.Infof("Telnet client %v logged in as %v", conn.RemoteAddr(), call)
+	fmt.Fprintf(conn, "Hello %s, this is cw-server\r\n", call)
+
+	lines := make(chan string, clientQueueSize)
+	ts.mu.Lock()
+	if ts.closed {
+		ts.mu.Unlock()
+		return
+	}
+	ts.clients[conn] = lines
+	ts.mu.Unlock()
+	defer ts.remove(conn)
+
+	go func() {
+		// Detect disconnect, input is ignored.
+		for {
+			if _, err := reader.ReadString('\n'); err != nil {
+				ts.remove(conn)
+				return
+			}
+		}
+	}()
+	for line := range lines {
+		if _, err := fmt.Fprintf(conn, "%s\r\n", line); err != nil {
+			return
+		}
+	}
+}
+
+// closeClients disconnects every client, serve and its reader return.
+func (ts *TelnetServer) closeClients() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.closed = true
+	for conn, lines := range ts.clients {
+		delete(ts.clients, conn)
+		close(lines)
+	}
+	for conn := range ts.conns {
+		conn.Close()
+	}
+}
+
+func (ts *TelnetServer) remove(conn net.Conn) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if lines, ok := ts.clients[conn]; ok {
+		delete(ts.clients, conn)
+		close(lines)
+	}
+}
+
+func (ts *TelnetServer) Publish(s Spot) {
+	line := s.String()
+	log.Infof("Spot: %v", line)
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for conn, lines := range ts.clients {
+		select {
+		case lines <- line:
+		default:
+			log.Warnf("Dropping slow telnet client %v", conn.RemoteAddr())
+			delete(ts.clients, conn)
+			close(lines)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpotString(t *testing.T) {
+	s := Spot{
+		Spotter:   "N0CALL-#",
+		Frequency: 14025.7,
+		Call:      "AB1C",
+		Snr:       21,
+		Wpm:       22,
+		Kind:      "CQ",
+		Time:      time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	want := "DX de N0CALL-#:   14025.7  AB1C         CW    21 dB  22 WPM  CQ     0304Z"
+	if s.String() != want {
+		t.Errorf("Spot is\n%q, want\n%q", s.String(), want)
+	}
+}
+
+// spotterInput keys text into the spotter one character per 0.1 s.
+type spotterInput struct {
+	sp    *Spotter
+	spots []Spot
+	clock time.Time
+	at    float64
+}
+
+func newSpotterInput() *spotterInput {
+	in := &spotterInput{clock: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	in.sp = NewSpotter("N0CALL", 14000, func(s Spot) { in.spots = append(in.spots, s) })
+	in.sp.now = func() time.Time { return in.clock }
+	in.sp.Status(StatusEvent{Type: "status", Wpm: 20, Snr: 15, Frequency: 700})
+	return in
+}
+
+func (in *spotterInput) send(text string) {
+	for _, r := range text {
+		in.sp.Character(CharacterEvent{Type: "character", Start: in.at, End: in.at + 0.1, Text: string(r), Frequency: 700})
+		in.at += 0.1
+	}
+}
+
+func (in *spotterInput) calls() (calls []string) {
+	for _, s := range in.spots {
+		calls = append(calls, s.Kind+" "+s.Call)
+	}
+	in.spots = nil
+	return calls
+}
+
+func TestSpotter(t *testing.T) {
+	in := newSpotterInput()
+	in.send("cq cq de ab1c ab1c k ")
+	if calls := in.calls(); strings.Join(calls, ",") != "CQ AB1C" {
+		t.Fatalf("Spotted %v", calls)
+	}
+	in.send("ab1c de k1xyz k ")
+	if calls := in.calls(); strings.Join(calls, ",") != "DE K1XYZ" {
+		t.Errorf("Spotted %v after DE", calls)
+	}
+	in.send("k1xyz tnx 599 ")
+	if calls := in.calls(); len(calls) != 0 {
+		t.Errorf("Spotted %v without CQ or DE", calls)
+	}
+
+	in.spots = nil
+	in.send("cq de ab1c ")
+	s := in.sp
+	if len(in.spots) != 0 {
+		t.Errorf("AB1C is spotted again right away")
+	}
+	in.clock = in.clock.Add(spotRepeatDelay)
+	in.send("cq de g4abc ")
+	// AB1C and K1XYZ can be spotted again, they are forgotten.
+	if _, ok := s.spotted["AB1C"]; ok || len(s.spotted) != 1 {
+		t.Errorf("Spotted callsigns kept %v", s.spotted)
+	}
+	in.send("cq de ab1c ")
+	spots := in.spots
+	if calls := in.calls(); strings.Join(calls, ",") != "CQ G4ABC,CQ AB1C" {
+		t.Fatalf("Spotted %v after the repeat delay", calls)
+	}
+	sp := spots[1]
+	if sp.Spotter != "N0CALL" || sp.Frequency != 14000.7 || sp.Snr != 15 || sp.Wpm != 20 || !sp.Time.Equal(in.clock) {
+		t.Errorf("Spot is %+v", sp)
+	}
+}
+
+func TestSpotterFlushesLastWord(t *testing.T) {
+	in := newSpotterInput()
+	in.send("cq de ab1c")
+	if len(in.spots) != 0 {
+		t.Fatalf("Spotted %v before the word ended", in.calls())
+	}
+	// The next letter can still be decoded a second later at 20 WPM.
+	in.sp.Status(StatusEvent{Type: "status", Time: in.at + 1, Wpm: 20})
+	if len(in.spots) != 0 {
+		t.Fatalf("Spotted %v while the word can go on", in.calls())
+	}
+	in.sp.Status(StatusEvent{Type: "status", Time: in.at + 2, Wpm: 20})
+	if calls := in.calls(); strings.Join(calls, ",") != "CQ AB1C" {
+		t.Errorf("Spotted %v after the transmission", calls)
+	}
+
+	in = newSpotterInput()
+	in.send("cq de g4abc")
+	in.sp.Flush()
+	if calls := in.calls(); strings.Join(calls, ",") != "CQ G4ABC" {
+		t.Errorf("Spotted %v at the end of the input", calls)
+	}
+}
+
+func TestTelnetServer(t *testing.T) {
+	ts := NewTelnetServer()
+	server, client := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		ts.serve(server)
+		close(done)
+	}()
+	r := bufio.NewReader(client)
+	prompt := make([]byte, len("Please enter your call: "))
+	if _, err := r.Read(prompt); err != nil || string(prompt) != "Please enter your call: " {
+		t.Fatalf("Prompt is %q, %v", prompt, err)
+	}
+	if _, err := client.Write([]byte("N0CALL\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if hello, err := r.ReadString('\n'); err != nil || !strings.HasPrefix(hello, "Hello N0CALL") {
+		t.Fatalf("Greeting is %q, %v", hello, err)
+	}
+	for {
+		ts.mu.Lock()
+		n := len(ts.clients)
+		ts.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	in := newSpotterInput()
+	in.sp.publish = ts.Publish
+	in.send("cq de ab1c ")
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "DX de N0CALL:     14000.7  AB1C         CW    15 dB  20 WPM  CQ     0304Z\r\n"
+	if line != want {
+		t.Errorf("Spot line is\n%q, want\n%q", line, want)
+	}
+
+	client.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client isn't disconnected")
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if len(ts.clients) != 0 {
+		t.Errorf("%v clients after disconnect", len(ts.clients))
+	}
+}
+
+func TestTelnetServerShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := NewTelnetServer()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() { served <- ts.Serve(ctx, l) }()
+
+	// A logged in client and one at the prompt.
+	var readers []*bufio.Reader
+	for _, login := range []bool{true, false} {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		r := bufio.NewReader(conn)
+		if _, err := r.ReadString(' '); err != nil {
+			t.Fatal(err)
+		}
+		if login {
+			conn.Write([]byte("N0CALL\r\n"))
+			if _, err := r.ReadString('\n'); err != nil {
+				t.Fatal(err)
+			}
+		}
+		readers = append(readers, r)
+	}
+	for {
+		ts.mu.Lock()
+		n, m := len(ts.clients), len(ts.conns)
+		ts.mu.Unlock()
+		if n == 1 && m == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	for i, r := range readers {
+		if rest, err := r.ReadString('\n'); err != io.EOF {
+			t.Errorf("Client %v read %q, %v after shutdown", i, rest, err)
+		}
+	}
+	if err := <-served; err != nil {
+		t.Error(err)
+	}
+	for {
+		ts.mu.Lock()
+		n := len(ts.conns)
+		ts.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
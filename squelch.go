@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// DecisionOptions configure the stage between the keying detector and
+// measureIntervals. Zero values disable each of its parts.
+type DecisionOptions struct {
+	// Fraction of the distances to the noise and the signal means the off
+	// and the on thresholds are moved from the threshold of the detector.
+	Hysteresis float64
+	MinMark    float64 // Dits, shorter marks are spaces.
+	MinSpace   float64 // Dits, shorter spaces are marks.
+	Squelch    float64 // dB, nothing is decoded at a lower SNR.
+}
+
+func checkDecision(opts DecisionOptions) error {
+	if opts.Hysteresis < 0 || opts.Hysteresis >= 1 {
+		return fmt.Errorf("Hysteresis %v is out of range, want 0 to 1", opts.Hysteresis)
+	}
+	if opts.MinMark < 0 || opts.MinSpace < 0 {
+		return fmt.Errorf("Minimum durations %v and %v dits are negative", opts.MinMark, opts.MinSpace)
+	}
+	if opts.Squelch < 0 {
+		return fmt.Errorf("Squelch %v dB is negative", opts.Squelch)
+	}
+	return nil
+}
+
+// squelched tells whether the signal of the detector is too weak to
+// decode.
+func (opts DecisionOptions) squelched(d *EMSingleFrequencyDetector) bool {
+	return opts.Squelch > 0 && d.snr() < opts.Squelch
+}
+
+// thresholds returns the off and the on thresholds around threshold of
+// the detector. The threshold of a detector isn't always half way
+// between its means, so they are moved towards the means.
+func (opts DecisionOptions) thresholds(d *EMSingleFrequencyDetector, threshold float64) (off, on float64) {
+	noise, signal := math.Min(d.m[0], d.m[1]), math.Max(d.m[0], d.m[1])
+	return threshold - opts.Hysteresis*(threshold-noise), threshold + opts.Hysteresis*(signal-threshold)
+}
+
+// hysteresis decides whether v is a mark, between the off and the on
+// thresholds the previous decision is kept.
+func hysteresis(v, off, on float64, prev bool) bool {
+	if v > on {
+		return true
+	}
+	if v < off || off == on {
+		return false
+	}
+	return prev
+}
+
+// decided is the decision on a block.
+type decided struct {
+	on    bool
+	block int
+	stamp Stamp
+}
+
+// debouncer holds decisions back until a change has lasted the minimum
+// duration of the new state. Shorter changes are decided as the state
+// before them, longer ones from their first block, so boundaries of
+// elements don't move.
+type debouncer struct {
+	opts    DecisionOptions
+	on      bool
+	pending []decided
+}
+
+// add takes the decision of the detector on the next block and returns
+// blocks decided since, dit is the current estimate in blocks, 0 if it is
+// unknown.
+func (db *debouncer) add(b decided, dit int) []decided {
+	if b.on == db.on {
+		return append(db.flush(), b)
+	}
+	db.pending = append(db.pending, b)
+	min := db.opts.MinSpace
+	if b.on {
+		min = db.opts.MinMark
+	}
+	if float64(len(db.pending)) < min*float64(dit) {
+		return nil
+	}
+	db.on = b.on
+	res := db.pending
+	db.pending = nil
+	return res
+}
+
+// flush returns blocks of a change that hasn't lasted long enough
+// decided as the state before it.
+func (db *debouncer) flush() []decided {
+	res := db.pending
+	for i := range res {
+		res[i].on = db.on
+	}
+	db.pending = nil
+	return res
+}
+
+// decide passes values of the detection through the decision stage.
+// Durations are relative to the dit of the values the detector decided.
+func (d *Detection) decide(opts DecisionOptions) error {
+	if err := checkDecision(opts); err != nil {
+		return err
+	}
+	if opts.squelched(d.detector) {
+		return fmt.Errorf("%w: SNR %.1f dB is below the squelch of %v dB", ErrNoSignal, d.detector.snr(), opts.Squelch)
+	}
+	if opts.Hysteresis > 0 {
+		signals, threshold := d.signals, d.detector.threshold()
+		if d.filtered != nil {
+			signals, threshold = d.filtered, d.threshold
+		}
+		off, on := opts.thresholds(d.detector, threshold)
+		keyed := false
+		for i, v := range signals {
+			keyed = hysteresis(v, off, on, keyed)
+			d.values[i] = keyed
+		}
+	}
+	if opts.MinMark == 0 && opts.MinSpace == 0 {
+		return nil
+	}
+	dit, _, err := classifySignals(measureIntervals(d.values))
+	if err != nil {
+		return err
+	}
+	db := &debouncer{opts: opts}
+	values := d.values[:0]
+	for i, v := range d.values {
+		for _, b := range db.add(decided{on: v, block: i}, dit) {
+			values = append(values, b.on)
+		}
+	}
+	for _, b := range db.flush() {
+		values = append(values, b.on)
+	}
+	d.values = values
+	return nil
+}
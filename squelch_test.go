@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestHysteresis(t *testing.T) {
+	for _, c := range []struct {
+		v, off, on  float64
+		prev, keyed bool
+	}{
+		{1, 1, 1, true, false},
+		{1.1, 1, 1, false, true},
+		{1.1, 0.8, 1.2, false, false},
+		{0.9, 0.8, 1.2, true, true},
+		{1.3, 0.8, 1.2, false, true},
+		{0.7, 0.8, 1.2, true, false},
+	} {
+		if keyed := hysteresis(c.v, c.off, c.on, c.prev); keyed != c.keyed {
+			t.Errorf("%+v: decided %v", c, keyed)
+		}
+	}
+	// The threshold of the detector is close to the noise.
+	d := &EMSingleFrequencyDetector{m: []float64{10, 1}}
+	if off, on := (DecisionOptions{Hysteresis: 0.5}).thresholds(d, 2); off != 1.5 || on != 6 {
+		t.Errorf("Thresholds are %v and %v", off, on)
+	}
+}
+
+func TestDebouncer(t *testing.T) {
+	db := &debouncer{opts: DecisionOptions{MinMark: 0.5, MinSpace: 0.5}}
+	in := "__#__##_#####_##__#"
+	want := "_____###########___"
+	var out []byte
+	push := func(bs []decided) {
+		for _, b := range bs {
+			if b.block != len(out) {
+				t.Fatalf("Block %v is decided after %v", b.block, len(out))
+			}
+			c := byte('_')
+			if b.on {
+				c = '#'
+			}
+			out = append(out, c)
+		}
+	}
+	for i := range in {
+		push(db.add(decided{on: in[i] == '#', block: i}, 4))
+	}
+	push(db.flush())
+	if string(out) != want {
+		t.Errorf("Decided %v, want %v", string(out), want)
+	}
+}
+
+func TestCheckDecision(t *testing.T) {
+	for _, opts := range []DecisionOptions{{Hysteresis: 1}, {MinMark: -1}, {Squelch: -3}} {
+		if checkDecision(opts) == nil {
+			t.Errorf("%+v is accepted", opts)
+		}
+	}
+	if err := checkDecision(DecisionOptions{Hysteresis: 0.3, MinMark: 0.5, MinSpace: 0.5, Squelch: 15}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDecisionStage(t *testing.T) {
+	text := "paris paris paris paris paris paris"
+	es := encodeText(text, 4)
+	decision := DecisionOptions{Hysteresis: 0.3, MinMark: 0.5, MinSpace: 0.5}
+	words := func(opts DecisionOptions) (stream, offline int) {
+		for seed := int64(1); seed <= 2; seed++ {
+			sink := &collectingSink{}
+			if err := decodeReader(bytes.NewReader(noisyPCM(es, 0, 2600, seed)), "threshold", opts, ReaderOptions{}, sink); err != nil {
+				t.Fatal(err)
+			}
+			stream += strings.Count(sink.text.String(), "paris")
+			d, err := detectKeying(keyedSpectra(t, es, 0, 2600, seed), "threshold", opts)
+			if err != nil {
+				continue
+			}
+			sink = &collectingSink{}
+			if emitDetection(sink, d) == nil {
+				offline += strings.Count(sink.text.String(), "paris")
+			}
+		}
+		return stream, offline
+	}
+	plainStream, plainOffline := words(DecisionOptions{})
+	stream, offline := words(decision)
+	if stream < plainStream+6 || offline < plainOffline+6 {
+		t.Errorf("Decoded %v and %v words of 12, %v and %v without the decision stage", stream, offline, plainStream, plainOffline)
+	}
+}
+
+func TestSquelch(t *testing.T) {
+	// Keying buried in noise decodes as random letters.
+	weak := encodeText("paris paris paris paris paris paris", 4)
+	pcm := noisyPCM(weak, 0, 3000, 1)
+	opts := DecisionOptions{Squelch: 15}
+	for _, c := range []struct {
+		decision DecisionOptions
+		decoded  bool
+	}{
+		{DecisionOptions{}, true},
+		{opts, false},
+	} {
+		sink := &collectingSink{}
+		if err := decodeReader(bytes.NewReader(pcm), "threshold", c.decision, ReaderOptions{}, sink); err != nil {
+			t.Fatal(err)
+		}
+		if decoded := strings.TrimSpace(sink.text.String()) != ""; decoded != c.decoded {
+			t.Errorf("%+v: decoded %q", c.decision, sink.text.String())
+		}
+	}
+	text := "paris paris paris"
+	sink := &collectingSink{}
+	if err := decodeReader(bytes.NewReader(keyedPCM(encodeText(text, 6), 1)), "threshold", opts, ReaderOptions{}, sink); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sink.text.String(), "paris paris") {
+		t.Errorf("Decoded %q with the squelch", sink.text.String())
+	}
+	if _, err := detectKeying(keyedSpectra(t, weak, 0, 3000, 1), "threshold", opts); !errors.Is(err, ErrNoSignal) {
+		t.Errorf("Detection of the weak signal fails with %v", err)
+	}
+}
@@ -1,23 +1,13 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/binary"
 	"fmt"
-	"unsafe"
+	"io"
 
-	"github.com/mjibson/go-dsp/fft"
 	log "github.com/sirupsen/logrus"
 )
 
-/*
-#cgo LDFLAGS: -lasound
-#include <alsa/asoundlib.h>
-#include <stdint.h>
-*/
-import "C"
-
 type Window struct {
 	a []int16
 }
@@ -30,206 +20,175 @@ func (w *Window) Add(v int16) {
 	w.a = append(w.a, v)
 }
 
-func stream(ctx context.Context, device string) error {
-	as, err := OpenAudioStream(device)
+// AudioSource delivers blocks of mono audio, a sound card capture or
+// demodulated I/Q samples.
+type AudioSource interface {
+	GetChan() <-chan *SampleBlock
+	Err() <-chan error
+	Rate() int
+	Stats() AudioStats
+	Close()
+}
+
+// SourceConfig selects the audio source, I/Q samples are used when IQ
+// isn't nil.
+type SourceConfig struct {
+	Capture CaptureConfig
+	IQ      *IQConfig
+}
+
+// openSource opens the source and resamples its audio if the device
+// doesn't capture at the audio rate.
+func openSource(ctx context.Context, cfg SourceConfig) (AudioSource, error) {
+	var src AudioSource
+	var err error
+	if cfg.IQ != nil {
+		src, err = OpenIQStream(ctx, *cfg.IQ)
+	} else {
+		src, err = OpenAudioStream(ctx, cfg.Capture)
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-	rawChan := as.GetChan()
-	filteredChan := filterSignal(rawChan)
-	spectraChan := produceSpectra(filteredChan)
-	textChan := decode(spectraChan)
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		case _ = <-textChan:
-		}
+	if src.Rate() != sampleRate {
+		log.Infof("Resampling %v Hz to %v Hz", src.Rate(), sampleRate)
+		return resampleSource(ctx, src), nil
 	}
+	return src, nil
 }
 
-type AudioStream struct {
-	handle *C.snd_pcm_t
-	ch     chan int16
+// blockDecoder decodes spectra of blocks, StreamDecoder the strongest
+// carrier, ChannelDecoder every channel and EnvelopeDecoder envelopes.
+type blockDecoder interface {
+	Add(spectrum []float64, at Stamp)
+	Flush()
+	setKeyingDetector(kind string) error
+	setDecision(opts DecisionOptions) error
 }
 
-func OpenAudioStream(device string) (*AudioStream, error) {
-	as := &AudioStream{nil, make(chan int16, 2000)}
-
-	var rc C.int
-
-	log.Tracef("Openning device: %v", device)
-	deviceCString := C.CString(device)
-	defer C.free(unsafe.Pointer(deviceCString))
+// StreamOptions are optional stages of the stream pipeline.
+type StreamOptions struct {
+	BandFilter     string          // One of bandFilterKinds, fir if empty.
+	CWBandwidth    float64         // Hz of the narrow filter following the carrier, 0 disables it.
+	ChannelSpacing float64         // Hz between channels decoded independently, 0 decodes the strongest carrier.
+	Envelope       bool            // Decode the Hilbert envelope of the CW filtered audio instead of spectra.
+	Detector       string          // One of keyingDetectors, threshold if empty.
+	Decision       DecisionOptions // Hysteresis, minimum durations and squelch after the detector.
+	Notch          bool            // Notch carriers that aren't keyed out.
+	NoiseReduction string          // One of noiseReducers, empty disables it.
+	Monitor        io.Writer       // Receives filtered audio for listening.
+}
 
-	rc = C.snd_pcm_open(&as.handle, deviceCString, C.SND_PCM_STREAM_CAPTURE, 0)
-	if rc < 0 {
-		return nil, fmt.Errorf("Unable to open pcm device: %v", C.snd_strerror(rc))
+// stream decodes audio from the source until ctx is cancelled, capture
+// fails or the source ends.
+func stream(ctx context.Context, cfg SourceConfig, opts StreamOptions, sink EventSink) error {
+	if opts.BandFilter == "" {
+		opts.BandFilter = "fir"
 	}
-
-	var params *C.snd_pcm_hw_params_t
-	rc = C.snd_pcm_hw_params_malloc(&params)
-	if rc < 0 {
-		return nil, fmt.Errorf("Couldn't alloc hw params")
+	bandFilter, err := newBandFilter(opts.BandFilter)
+	if err != nil {
+		return err
 	}
-	defer C.snd_pcm_hw_params_free(params)
-
-	rc = C.snd_pcm_hw_params_any(as.handle, params)
-	if rc < 0 {
-		return nil, fmt.Errorf("Couldn't set default hw params")
+	reducer, err := newNoiseReducer(opts.NoiseReduction)
+	if err != nil {
+		return err
 	}
-	rc = C.snd_pcm_hw_params_set_access(as.handle, params, C.SND_PCM_ACCESS_RW_INTERLEAVED)
-	if rc < 0 {
-		return nil, fmt.Errorf("Couldn't set access params")
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	as, err := openSource(ctx, cfg)
+	if err != nil {
+		return err
 	}
-	rc = C.snd_pcm_hw_params_set_format(as.handle, params, C.SND_PCM_FORMAT_S16_LE)
-	if rc < 0 {
-		return nil, fmt.Errorf("Couldn't set endian format")
+	defer as.Close()
+	filteredChan := filterSignalWith(ctx, as.GetChan(), bandFilter)
+	if opts.Notch {
+		filteredChan = filterFragments(ctx, filteredChan, NewNotcher())
 	}
-	rc = C.snd_pcm_hw_params_set_channels(as.handle, params, 1)
-	if rc < 0 {
-		return nil, fmt.Errorf("Couldn't set channels")
+	if reducer != nil {
+		filteredChan = filterFragments(ctx, filteredChan, reducer)
 	}
-	var val C.uint = 44100
-	var dir C.int
-	rc = C.snd_pcm_hw_params_set_rate_near(as.handle, params, &val, &dir)
-	if rc < 0 {
-		return nil, fmt.Errorf("Couldn't set rate")
+	if opts.CWBandwidth != 0 {
+		if filteredChan, err = narrowFilter(ctx, filteredChan, opts.CWBandwidth); err != nil {
+			return err
+		}
 	}
-
-	var frames C.snd_pcm_uframes_t = 8192
-	rc = C.snd_pcm_hw_params_set_period_size_near(as.handle, params, &frames, &dir)
-	if rc < 0 {
-		return nil, fmt.Errorf("Couldn't set period size")
+	if opts.Monitor != nil {
+		filteredChan = monitorAudio(ctx, filteredChan, opts.Monitor)
 	}
-
-	rc = C.snd_pcm_hw_params(as.handle, params)
-	if rc < 0 {
-		return nil, fmt.Errorf("Couldn't set params")
+	var spectraChan <-chan SpectrumBlock
+	var decoder blockDecoder
+	var envelope *EnvelopeDecoder
+	spectrumSink, _ := sink.(SpectrumSink)
+	spectrumEvent := newSpectrumEvent
+	if opts.ChannelSpacing != 0 {
+		if opts.CWBandwidth != 0 {
+			return fmt.Errorf("Channels are decoded without the CW filter")
+		}
+		c, err := NewChannelizer(opts.ChannelSpacing)
+		if err != nil {
+			return err
+		}
+		lo, hi := c.band()
+		log.Infof("Decoding %v channels %.1f Hz apart", hi-lo+1, c.Spacing())
+		spectraChan = channelEnvelopes(ctx, filteredChan, c)
+		decoder = NewChannelDecoder(c, sink)
+		spectrumEvent = func(at Stamp, envelopes []float64) SpectrumEvent {
+			return newChannelSpectrumEvent(at, envelopes, float64(lo)*c.Spacing(), c.Spacing())
+		}
+	} else if opts.Envelope {
+		if opts.CWBandwidth == 0 {
+			return fmt.Errorf("The envelope is detected on audio of the CW filter")
+		}
+		if spectraChan, err = envelopeSignal(ctx, filteredChan); err != nil {
+			return err
+		}
+		envelope = NewEnvelopeDecoder(sink)
+		decoder = envelope
+		// Envelopes aren't spectra to display.
+		spectrumSink = nil
+	} else {
+		spectraChan = produceSpectra(ctx, filteredChan)
+		decoder = NewStreamDecoder(sink)
 	}
-
-	rc = C.snd_pcm_hw_params_get_period_size(params, &frames, &dir)
-	if rc < 0 {
-		return nil, fmt.Errorf("Couldn't get period size")
+	if opts.Detector != "" {
+		if err := decoder.setKeyingDetector(opts.Detector); err != nil {
+			return err
+		}
 	}
-
-	var size C.snd_pcm_uframes_t
-	log.Tracef("Frames: %v\n", frames)
-	size = frames * 2 // Period - 2
-	buffer := make([]byte, size)
-	log.Tracef("Buffer len: %v", len(buffer))
-
-	rc = C.snd_pcm_hw_params_get_period_time(params, &val, &dir)
-	if rc < 0 {
-		log.Fatal("Couldn't get period time")
+	if err := decoder.setDecision(opts.Decision); err != nil {
+		return err
 	}
-
-	go func() {
-		for {
-			var rcl C.long
-			rcl = C.snd_pcm_readi(as.handle, unsafe.Pointer(&buffer[0]), frames)
-			log.Tracef("Received rcl: %v", rcl)
-			if rcl == -C.EPIPE {
-				fmt.Printf("Overrun occurred\n")
-				C.snd_pcm_prepare(as.handle)
-			} else if rcl < 0 {
-				fmt.Printf("Error from read: %v\n", C.snd_strerror(rc))
-			} else if rcl != C.long(frames) {
-				fmt.Printf("Short read, read %v frames\n", rc)
-			}
-			bf := bytes.NewReader(buffer)
-			var v int16
-			for {
-				err := binary.Read(bf, binary.LittleEndian, &v)
-				log.Tracef("Pushing to channel value: %v", v)
-				if err != nil {
-					break
+	var stats AudioStats
+	for block := 0; ; block++ {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-as.Err():
+			return err
+		case sp, ok := <-spectraChan:
+			if !ok {
+				// The reader reports its error before closing the pipeline.
+				select {
+				case err := <-as.Err():
+					return err
+				default:
+					decoder.Flush()
+					return nil
 				}
-				as.ch <- v
 			}
-		}
-	}()
-
-	return as, nil
-}
-
-func (as *AudioStream) GetChan() chan int16 {
-	return as.ch
-}
-
-func (as *AudioStream) Read() int16 {
-	return <-as.ch
-}
-
-func (as *AudioStream) Close() {
-	C.snd_pcm_drain(as.handle)
-	C.snd_pcm_close(as.handle)
-}
-
-func filterSignal(in chan int16) (out chan []float64) {
-	out = make(chan []float64)
-	filter := NewBpFilter(200, 7.0/fragmentSize, 30.0/fragmentSize, fragmentSize)
-	go func() {
-		for {
-			buf := make([]float64, fragmentSize)
-			for i := 0; i < fragmentSize; i++ {
-				var v int16
-				v = <-in
-				buf[i] = float64(v)
+			if spectrumSink != nil {
+				spectrumSink.Spectrum(spectrumEvent(sp.Stamp, sp.Magnitudes))
 			}
-			buf = filter.FilterBuf(buf)
-			out <- buf
-		}
-	}()
-	return out
-}
-
-func filterSignalStream(in chan int16) (out chan int16) {
-	out = make(chan int16)
-	filter := NewBpFilter(200, 7.0/fragmentSize, 30.0/fragmentSize, fragmentSize)
-	go func() {
-		for {
-			buf := make([]float64, fragmentSize)
-			for i := 0; i < fragmentSize; i++ {
-				var v int16
-				v = <-in
-				buf[i] = float64(v)
+			if envelope != nil {
+				envelope.Tune(sp.Carrier)
 			}
-			buf = filter.FilterBuf(buf)
-			for i := 0; i < fragmentSize; i++ {
-				out <- int16(buf[i])
+			decoder.Add(sp.Magnitudes, sp.Stamp)
+			if block%decoderInterval == 0 {
+				if s := as.Stats(); s != stats {
+					log.Warnf("Audio stream lost samples: %v overruns, %v samples dropped", s.Overruns, s.Dropped)
+					stats = s
+				}
 			}
 		}
-	}()
-	return out
-}
-
-func produceSpectra(in chan []float64) (out chan []float64) {
-	out = make(chan []float64)
-	go func() {
-		for {
-			buf := <-in
-			hann(buf)
-			rawSpectrum := ToAbs(fft.FFTReal(buf))
-			out <- rawSpectrum[0:222]
-		}
-	}()
-	return out
-}
-
-func decode(ch chan []float64) (out chan string) {
-	m := 222
-	spectra := make([][]float64, 0)
-	sum := make([]float64, m)
-	n := 0
-	for {
-		sp := <-ch
-		spectra := append(spectra, sp)
-		n++
-		sumV(sum, sp)
-		freq, _ := calculateSignificantFrequency(spectra)
-		//signal := extractFrequency(spectra, freq)
-		//sd := classifyFromSingleFrequency(signal)
-		fmt.Printf("%v\n", freq)
 	}
 }
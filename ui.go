@@ -7,7 +7,7 @@ import (
 	"github.com/veandco/go-sdl2/sdl"
 )
 
-func MainLoop(fileName string) {
+func MainLoop(fileName string, at int, annotationsName string) (err error) {
 	done := make(chan struct{})
 	renderLoopComplete := make(chan struct{})
 	sdl.Main(func() {
@@ -20,8 +20,12 @@ func MainLoop(fileName string) {
 
 		var fileViewer *FileViewer
 		sdl.Do(func() {
-			fileViewer = viewFile(fileName)
+			fileViewer, err = viewFile(fileName, at, annotationsName)
 		})
+		if err != nil {
+			close(renderLoopComplete)
+			return
+		}
 		defer sdl.Do(func() { fileViewer.Destroy() })
 
 		go RenderLoop(fileViewer, done, renderLoopComplete)
@@ -29,6 +33,7 @@ func MainLoop(fileName string) {
 	})
 	log.Info("Waiting for completion2")
 	<-renderLoopComplete
+	return err
 }
 
 func EventLoop(fileViewer *FileViewer, done, complete chan struct{}) {
@@ -32,3 +32,11 @@ func (this *View) Shift(d int) {
 	log.Tracef("Scale factor: %v\n", this.scaleFactor)
 	this.start = this.start + d/barWidth*this.scaleFactor
 }
+
+// JumpTo moves the view so that it starts at the given sample.
+func (this *View) JumpTo(sample int) {
+	if sample < 0 {
+		sample = 0
+	}
+	this.start = sample / this.scaleFactor * this.scaleFactor
+}
@@ -0,0 +1,66 @@
+package main
+
+import "testing"
+
+func TestViewScaleKeepsCursorPosition(t *testing.T) {
+	for _, start := range []int{0, 512, 1000, 4096} {
+		for _, dx := range []int32{0, 10, 333} {
+			for _, s := range []int32{-3, -1, 1, 2} {
+				v := &View{start, 4}
+				fixedPoint := v.start + int(dx/barWidth)*v.scaleFactor
+				v.Scale(s, dx)
+				if v.scaleFactor < 1 {
+					t.Fatalf("Scale factor: %v", v.scaleFactor)
+				}
+				if v.start%v.scaleFactor != 0 {
+					t.Errorf("Start %v is not aligned to scale factor %v", v.start, v.scaleFactor)
+				}
+				p := v.start + int(dx/barWidth)*v.scaleFactor
+				if p > fixedPoint || fixedPoint-p >= v.scaleFactor {
+					t.Errorf("Point under cursor moved from %v to %v, scale %v", fixedPoint, p, v.scaleFactor)
+				}
+			}
+		}
+	}
+}
+
+func TestViewScaleFactor(t *testing.T) {
+	v := NewView()
+	v.Scale(-3, 0)
+	if v.scaleFactor != 8 {
+		t.Errorf("Zoom out: %v", v.scaleFactor)
+	}
+	v.Scale(2, 0)
+	if v.scaleFactor != 2 {
+		t.Errorf("Zoom in: %v", v.scaleFactor)
+	}
+	v.Scale(5, 0)
+	if v.scaleFactor != 1 {
+		t.Errorf("Zoom in beyond one sample: %v", v.scaleFactor)
+	}
+}
+
+func TestViewShift(t *testing.T) {
+	v := &View{100, 4}
+	v.Shift(10)
+	if v.start != 100+10/barWidth*4 {
+		t.Errorf("Start after shift: %v", v.start)
+	}
+	v.Shift(-10)
+	if v.start != 100 {
+		t.Errorf("Start after shift back: %v", v.start)
+	}
+}
+
+func TestViewJumpTo(t *testing.T) {
+	v := NewView()
+	v.scaleFactor = 4
+	v.JumpTo(1001)
+	if v.start != 1000 {
+		t.Errorf("Expected start 1000, got %v", v.start)
+	}
+	v.JumpTo(-5)
+	if v.start != 0 {
+		t.Errorf("Expected start 0, got %v", v.start)
+	}
+}
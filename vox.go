@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/mjibson/go-dsp/fft"
+	log "github.com/sirupsen/logrus"
+)
+
+// VoxOptions configure signal triggered recording.
+type VoxOptions struct {
+	PreRoll   time.Duration // Audio kept before the signal appears.
+	Hang      time.Duration // Silence recorded after the signal disappears.
+	Threshold float64       // Level above the noise floor in dB opening a segment.
+}
+
+const (
+	voxNoiseTracking = 0.01 // Weight of a block above the noise floor in it.
+	voxNoiseDecay    = 0.1  // Weight of a block below the noise floor in it.
+	voxNoiseRise     = 3.0  // dB per second the noise floor rises at most.
+)
+
+// Vox writes only the parts of a stream where a carrier is present.
+// Every part goes into a separate file named after the prefix, the time
+// of its first sample and the frequency of the carrier.
+type Vox struct {
+	opts    VoxOptions
+	prefix  string
+	meta    *RecordingMetadata
+	create  func(name string, meta *RecordingMetadata) (io.WriteCloser, error)
+	onClose func(name string)
+
+	noise   float64
+	preRoll []*SampleBlock
+	hang    int
+	out     io.WriteCloser
+	outName string
+	buf     []byte
+}
+
+// NewVox creates a vox writing segments as recording files with
+// metadata sidecars if meta isn't nil.
+func NewVox(prefix string, opts VoxOptions, meta *RecordingMetadata) *Vox {
+	return &Vox{
+		opts:   opts,
+		prefix: prefix,
+		meta:   meta,
+		create: func(name string, meta *RecordingMetadata) (io.WriteCloser, error) {
+			return createRecordingFile(name, meta)
+		},
+	}
+}
+
+func blocksIn(d time.Duration) int {
+	return int(math.Ceil(d.Seconds() * sampleRate / fragmentSize))
+}
+
+// Add handles the next fragment, spectrum is the spectrum of the
+// band-pass filtered fragment.
+func (v *Vox) Add(fragment *SampleBlock, spectrum []float64) error {
+	bin := lowerMeaningfulHarmonic
+	for j := lowerMeaningfulHarmonic; j < upperMeaningfulHarmonic; j++ {
+		if spectrum[j] > spectrum[bin] {
+			bin = j
+		}
+	}
+	level := spectrum[bin]
+	if v.noise == 0 {
+		v.noise = level
+	}
+	active := level > v.noise*math.Pow(10, v.opts.Threshold/20)
+	v.trackNoise(level)
+
+	if v.out == nil {
+		if !active {
+			v.keep(fragment)
+			return nil
+		}
+		if err := v.open(fragment, bin); err != nil {
+			fragment.Release()
+			return err
+		}
+	}
+	if active {
+		v.hang = blocksIn(v.opts.Hang)
+	} else {
+		v.hang--
+	}
+	err := v.write(fragment)
+	fragment.Release()
+	if err != nil {
+		return err
+	}
+	if v.hang <= 0 {
+		return v.closeSegment()
+	}
+	return nil
+}
+
+// trackNoise moves the noise floor towards level. It falls fast and
+// rises slowly, so gaps of keying pull it down and a carrier lifts it
+// little, but a segment opened by rising noise still closes.
+func (v *Vox) trackNoise(level float64) {
+	if level < v.noise {
+		v.noise += voxNoiseDecay * (level - v.noise)
+		return
+	}
+	rise := math.Pow(10, voxNoiseRise/20*fragmentSize/sampleRate)
+	v.noise = math.Min(v.noise+voxNoiseTracking*(level-v.noise), v.noise*rise)
+}
+
+// keep puts the fragment into the pre-roll ring.
+func (v *Vox) keep(fragment *SampleBlock) {
+	v.preRoll = append(v.preRoll, fragment)
+	if n := blocksIn(v.opts.PreRoll); len(v.preRoll) > n {
+		drop := len(v.preRoll) - n
+		for _, b := range v.preRoll[:drop] {
+			b.Release()
+		}
+		v.preRoll = append(v.preRoll[:0], v.preRoll[drop:]...)
+	}
+}
+
+func (v *Vox) open(fragment *SampleBlock, bin int) error {
+	first := fragment.Stamp
+	if len(v.preRoll) > 0 {
+		first = v.preRoll[0].Stamp
+	}
+	frequency := binFrequency(bin)
+	v.outName = timestampedName(v.prefix, first, fmt.Sprintf("-%.0fHz", frequency))
+	var meta *RecordingMetadata
+	if v.meta != nil {
+		m := *v.meta
+		m.Start = first.Time
+		m.Frequency = frequency
+		meta = &m
+	}
+	out, err := v.create(v.outName, meta)
+	if err != nil {
+		return err
+	}
+	log.Infof("Recording segment %v", v.outName)
+	v.out = out
+	for i, b := range v.preRoll {
+		if err == nil {
+			err = v.write(b)
+		}
+		b.Release()
+		v.preRoll[i] = nil
+	}
+	v.preRoll = v.preRoll[:0]
+	return err
+}
+
+func (v *Vox) write(b *SampleBlock) error {
+	if cap(v.buf) < 2*len(b.Samples) {
+		v.buf = make([]byte, 2*len(b.Samples))
+	}
+	buf := v.buf[:2*len(b.Samples)]
+	encodePCM(buf, b.Samples)
+	_, err := v.out.Write(buf)
+	return err
+}
+
+func (v *Vox) closeSegment() error {
+	err := v.out.Close()
+	v.out = nil
+	if v.onClose != nil {
+		v.onClose(v.outName)
+	}
+	return err
+}
+
+// Close finishes the current segment.
+func (v *Vox) Close() error {
+	for _, b := range v.preRoll {
+		b.Release()
+	}
+	v.preRoll = nil
+	if v.out == nil {
+		return nil
+	}
+	return v.closeSegment()
+}
+
+// runVox feeds the stream into vox until the input is closed or ctx
+// is cancelled.
+func runVox(ctx context.Context, in <-chan *SampleBlock, vox *Vox) error {
+	defer vox.Close()
+	filter := NewBpFilter(200, 7.0/fragmentSize, 30.0/fragmentSize, fragmentSize)
+	br := &blockReader{ctx: ctx, in: in}
+	defer br.release()
+	buf := make([]float64, fragmentSize)
+	for {
+		stamp, ok := br.read(buf)
+		if !ok {
+			return nil
+		}
+		fragment := newSampleBlock(fragmentSize)
+		fragment.Stamp = stamp
+		for i, x := range buf {
+			fragment.Samples[i] = int16(x)
+		}
+		filtered := filter.FilterBuf(buf)
+		hann(filtered)
+		if err := vox.Add(fragment, ToAbs(fft.FFTReal(filtered))); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+type memFile struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (mf *memFile) Close() error {
+	mf.closed = true
+	return nil
+}
+
+// voxSignal generates gaussian noise with a carrier in bin 15 turned on
+// and off.
+type voxSignal struct {
+	rnd     *rand.Rand
+	samples []int16
+}
+
+func (vs *voxSignal) add(on bool, d, sigma float64) {
+	for i := 0; i < int(d*sampleRate); i++ {
+		v := sigma * vs.rnd.NormFloat64()
+		if on {
+			v += 5000 * math.Sin(2*math.Pi*15*float64(len(vs.samples))/fragmentSize)
+		}
+		vs.samples = append(vs.samples, int16(v))
+	}
+}
+
+// runVoxSamples runs the vox over samples and returns segments it has
+// written in order.
+func runVoxSamples(t *testing.T, samples []int16) (names []string, files map[string]*memFile) {
+	t.Helper()
+	in := make(chan *SampleBlock, 1)
+	go func() {
+		defer close(in)
+		start := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+		for i := 0; i < len(samples); i += 4096 {
+			b := newSampleBlock(minInt(4096, len(samples)-i))
+			copy(b.Samples, samples[i:])
+			b.Stamp = Stamp{0, start}.after(i)
+			in <- b
+		}
+	}()
+
+	files = map[string]*memFile{}
+	vox := NewVox("/rec/band.raw", VoxOptions{PreRoll: 500 * time.Millisecond, Hang: time.Second, Threshold: 12}, nil)
+	vox.create = func(name string, meta *RecordingMetadata) (io.WriteCloser, error) {
+		f := &memFile{}
+		files[name] = f
+		names = append(names, name)
+		return f, nil
+	}
+	if err := runVox(context.Background(), in, vox); err != nil {
+		t.Fatal(err)
+	}
+	return names, files
+}
+
+func TestVoxSegments(t *testing.T) {
+	// Noise with two carriers of 1 and 0.5 seconds.
+	vs := &voxSignal{rnd: rand.New(rand.NewSource(3))}
+	vs.add(false, 3, 100)
+	vs.add(true, 1, 100)
+	vs.add(false, 4, 100)
+	vs.add(true, 0.5, 100)
+	vs.add(false, 0.5, 100)
+	samples := vs.samples
+	names, files := runVoxSamples(t, samples)
+
+	if len(names) != 2 {
+		t.Fatalf("Expected 2 segments, got %v", names)
+	}
+	if !strings.HasPrefix(names[0], "/rec/band-20260304T050609.") || !strings.HasSuffix(names[0], "-1292Hz.raw") {
+		t.Errorf("Unexpected name: %v", names[0])
+	}
+	// The second segment is cut by the end of the stream.
+	expected := []float64{0.5 + 1 + 1, 0.5 + 0.5 + 0.5}
+	for i, name := range names {
+		f := files[name]
+		if !f.closed {
+			t.Errorf("Segment %v isn't closed", name)
+		}
+		d := float64(f.Len()/2) / sampleRate
+		if math.Abs(d-expected[i]) > 0.05 {
+			t.Errorf("Segment %v is %.3f s long, expected %.3f s", name, d, expected[i])
+		}
+	}
+	// The first segment starts with the pre-roll.
+	first := files[names[0]].Bytes()
+	offset := (3*sampleRate - blocksIn(500*time.Millisecond)*fragmentSize) / fragmentSize * fragmentSize
+	for i := 0; i < 100; i++ {
+		v := int16(uint16(first[2*i]) | uint16(first[2*i+1])<<8)
+		if v != samples[offset+i] {
+			t.Fatalf("Sample %v of the segment is %v, expected %v", i, v, samples[offset+i])
+		}
+	}
+}
+
+func TestVoxNoiseStep(t *testing.T) {
+	// The noise rises by 20 dB after a carrier, later a carrier appears
+	// in the louder noise.
+	vs := &voxSignal{rnd: rand.New(rand.NewSource(4))}
+	vs.add(false, 3, 100)
+	vs.add(true, 1, 100)
+	vs.add(false, 3, 100)
+	vs.add(false, 8, 1000)
+	vs.add(true, 1, 1000)
+	vs.add(false, 2, 1000)
+	names, files := runVoxSamples(t, vs.samples)
+
+	if len(names) != 3 {
+		t.Fatalf("Expected 3 segments, got %v", names)
+	}
+	// The rising noise opens a segment, it closes when the noise floor
+	// follows.
+	if d := float64(files[names[1]].Len()/2) / sampleRate; d > 6 {
+		t.Errorf("Segment of the noise step is %.3f s long", d)
+	}
+	if !strings.HasPrefix(names[2], "/rec/band-20260304T050621.") {
+		t.Errorf("Segment of the second carrier is %v", names[2])
+	}
+	if d := float64(files[names[2]].Len()/2) / sampleRate; math.Abs(d-2.5) > 0.05 {
+		t.Errorf("Segment of the second carrier is %.3f s long, expected 2.5 s", d)
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"time"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
@@ -37,7 +39,9 @@ func watchSound() {
 	clearScreen(renderer)
 	renderer.Present()
 
-	audioStream, err := OpenAudioStream("default")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	audioStream, err := openSource(ctx, SourceConfig{Capture: defaultCaptureConfig("default")})
 	if err != nil {
 		panic(err)
 	}
@@ -45,8 +49,8 @@ func watchSound() {
 
 	ticker := time.NewTicker(100 * time.Millisecond)
 	eventChan := eventListener()
-	//ch := filterSignalStream(compressor(audioStream.GetChan()))
-	ch := compressor(filterSignalStream(audioStream.GetChan()))
+	//ch := filterSignalStream(ctx, compressor(ctx, audioStream.GetChan()))
+	ch := compressor(ctx, filterSignalStream(ctx, audioStream.GetChan()))
 	//ch := audioStream.GetChan()
 outer:
 	for {
@@ -56,7 +60,11 @@ outer:
 		receiver:
 			for {
 				select {
-				case v := <-ch:
+				case v, ok := <-ch:
+					if !ok {
+						log.Debugf("Audio stream closed")
+						break outer
+					}
 					fmt.Printf("%d ", v)
 					buffer = append(buffer, v)
 					if len(buffer) > maxBufLen {
@@ -145,20 +153,38 @@ func clearScreen(r *sdl.Renderer) {
 	r.Clear()
 }
 
-func compressor(ch chan int16) (r chan int16) {
+func compressor(ctx context.Context, ch <-chan *SampleBlock) (r chan int16) {
 	r = make(chan int16, 20000)
 	go func() {
+		defer close(r)
 		c := 0
 		v := int16(0)
 		for {
-			x := <-ch
-			v = max(v, x)
-			c++
-			if c == compressionRate {
-				c = 0
-				r <- v
-				v = 0
+			var b *SampleBlock
+			select {
+			case x, ok := <-ch:
+				if !ok {
+					return
+				}
+				b = x
+			case <-ctx.Done():
+				return
+			}
+			for _, x := range b.Samples {
+				v = max(v, x)
+				c++
+				if c == compressionRate {
+					c = 0
+					select {
+					case r <- v:
+					case <-ctx.Done():
+						b.Release()
+						return
+					}
+					v = 0
+				}
 			}
+			b.Release()
 		}
 	}()
 	return r